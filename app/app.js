@@ -1,4 +1,11 @@
 document.addEventListener('DOMContentLoaded', async () => {
+  // Single sign-on sends the user back with a code to trade for tokens.
+  const oidcCode = new URLSearchParams(window.location.hash.slice(1)).get('oidc_code');
+  if (oidcCode) {
+    history.replaceState(null, '', window.location.pathname + window.location.search);
+    if (await oidcLogin(oidcCode)) return;
+  }
+
   const token = localStorage.getItem('token');
 
   if (token) {
@@ -26,11 +33,10 @@ async function createVideoDraft() {
   const description = document.getElementById('video-description').value;
 
   try {
-    const res = await fetch('/api/videos', {
+    const res = await authFetch('/api/videos', {
       method: 'POST',
       headers: {
         'Content-Type': 'application/json',
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
       },
       body: JSON.stringify({ title, description }),
     });
@@ -65,20 +71,67 @@ async function login() {
     if (!res.ok) {
       throw new Error(`Failed to login: ${data.error}`);
     }
+    await finishLogin(data);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
 
-    if (data.token) {
-      localStorage.setItem('token', data.token);
-      document.getElementById('auth-section').style.display = 'none';
-      document.getElementById('video-section').style.display = 'block';
-      await getVideos();
-    } else {
-      alert('Login failed. Please check your credentials.');
+// oidcLogin trades the code single sign-on sent the user back with for a
+// login. It returns whether the user ended up logged in.
+async function oidcLogin(code) {
+  try {
+    const res = await fetch('/api/oidc/token', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ code }),
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to login: ${data.error}`);
     }
+    return await finishLogin(data);
   } catch (error) {
     alert(`Error: ${error.message}`);
+    return false;
   }
 }
 
+// finishLogin keeps the tokens from a login, first asking for a code from
+// the user's authenticator app if the login needs one. It returns whether
+// the user ended up logged in.
+async function finishLogin(data) {
+  if (data.mfa_required) {
+    const code = prompt('Enter the code from your authenticator app, or a recovery code');
+    if (!code) return false;
+    const recovery = !/^\d{6}$/.test(code.replace(/\s/g, ''));
+    const res = await fetch('/api/login/mfa', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ mfa_token: data.mfa_token, [recovery ? 'recovery_code' : 'code']: code }),
+    });
+    data = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to login: ${data.error}`);
+    }
+  }
+  if (!data.token) {
+    alert('Login failed. Please check your credentials.');
+    return false;
+  }
+
+  localStorage.setItem('token', data.token);
+  localStorage.setItem('refreshToken', data.refresh_token);
+  document.getElementById('auth-section').style.display = 'none';
+  document.getElementById('video-section').style.display = 'block';
+  await getVideos();
+  return true;
+}
+
 async function signup() {
   const email = document.getElementById('email').value;
   const password = document.getElementById('password').value;
@@ -102,8 +155,63 @@ async function signup() {
   }
 }
 
+// authFetch sends a request with the user's access token. Access tokens
+// last an hour, so when one is refused it's refreshed and the request is
+// sent once more.
+async function authFetch(url, options = {}) {
+  const send = () =>
+    fetch(url, {
+      ...options,
+      headers: { ...options.headers, Authorization: `Bearer ${localStorage.getItem('token')}` },
+    });
+  const res = await send();
+  if (res.status !== 401 || !(await refreshSession())) {
+    return res;
+  }
+  return send();
+}
+
+// refreshSession trades the refresh token for new tokens. Only one refresh
+// runs at a time: presenting a refresh token twice logs the session out.
+let refreshing = null;
+function refreshSession() {
+  if (!refreshing) {
+    refreshing = (async () => {
+      const refreshToken = localStorage.getItem('refreshToken');
+      if (!refreshToken) return false;
+      const res = await fetch('/api/refresh', {
+        method: 'POST',
+        headers: {
+          Authorization: `Bearer ${refreshToken}`,
+        },
+      });
+      if (!res.ok) {
+        logout();
+        return false;
+      }
+      const data = await res.json();
+      localStorage.setItem('token', data.token);
+      localStorage.setItem('refreshToken', data.refresh_token);
+      return true;
+    })().finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+}
+
 function logout() {
+  const refreshToken = localStorage.getItem('refreshToken');
+  if (refreshToken) {
+    fetch('/api/revoke', {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${refreshToken}`,
+      },
+    }).catch((error) => console.log(`Logout failed: ${error.message}`));
+  }
   localStorage.removeItem('token');
+  localStorage.removeItem('refreshToken');
   document.getElementById('auth-section').style.display = 'block';
   document.getElementById('video-section').style.display = 'none';
 }
@@ -130,11 +238,8 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/thumbnail_upload/${videoID}`, {
+    const res = await authFetch(`/api/thumbnail_upload/${videoID}`, {
       method: 'POST',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
       body: formData,
     });
     if (!res.ok) {
@@ -163,11 +268,8 @@ async function uploadVideoFile(videoID) {
   setUploadButtonState(true, uploadBtnSelector);
 
   try {
-    const res = await fetch(`/api/video_upload/${videoID}`, {
+    const res = await authFetch(`/api/video_upload/${videoID}`, {
       method: 'POST',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
       body: formData,
     });
     if (!res.ok) {
@@ -188,18 +290,26 @@ const videoStateHandler = createVideoStateHandler();
 
 async function getVideos() {
   try {
-    const res = await fetch('/api/videos', {
-      method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
-    });
-    if (!res.ok) {
-      const data = await res.json();
-      throw new Error(`Failed to get videos. Error: ${data.error}`);
-    }
+    const videos = [];
+    let cursor = null;
+    do {
+      const params = new URLSearchParams({ limit: '100' });
+      if (cursor) {
+        params.set('cursor', cursor);
+      }
+      const res = await authFetch(`/api/videos?${params}`, {
+        method: 'GET',
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(`Failed to get videos. Error: ${data.error}`);
+      }
+
+      const page = await res.json();
+      videos.push(...page.items);
+      cursor = page.next_cursor;
+    } while (cursor);
 
-    const videos = await res.json();
     const videoList = document.getElementById('video-list');
     videoList.innerHTML = '';
     for (const video of videos) {
@@ -231,11 +341,8 @@ function createVideoStateHandler() {
 
 async function getVideo(videoID) {
   try {
-    const res = await fetch(`/api/videos/${videoID}`, {
+    const res = await authFetch(`/api/videos/${videoID}`, {
       method: 'GET',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
     });
     if (!res.ok) {
       throw new Error('Failed to get video.');
@@ -255,14 +362,9 @@ function viewVideo(video) {
   document.getElementById('video-display').style.display = 'block';
   document.getElementById('video-title-display').textContent = video.title;
   document.getElementById('video-description-display').textContent = video.description;
+  document.getElementById('video-visibility').value = video.visibility;
 
-  const thumbnailImg = document.getElementById('thumbnail-image');
-  if (!video.thumbnail_url) {
-    thumbnailImg.style.display = 'none';
-  } else {
-    thumbnailImg.style.display = 'block';
-    thumbnailImg.src = video.thumbnail_url;
-  }
+  showThumbnail(video);
 
   const videoPlayer = document.getElementById('video-player');
   if (videoPlayer) {
@@ -273,6 +375,103 @@ function viewVideo(video) {
       videoPlayer.src = video.video_url;
       videoPlayer.load();
     }
+    trackViews(video.id, videoPlayer);
+  }
+}
+
+// showThumbnail fetches the thumbnail with the user's token, since
+// thumbnails of private videos are only served to their owner.
+async function showThumbnail(video) {
+  const thumbnailImg = document.getElementById('thumbnail-image');
+  if (thumbnailImg.src.startsWith('blob:')) {
+    URL.revokeObjectURL(thumbnailImg.src);
+  }
+  if (!video.thumbnail_url) {
+    thumbnailImg.style.display = 'none';
+    thumbnailImg.removeAttribute('src');
+    return;
+  }
+  try {
+    const res = await authFetch(video.thumbnail_url);
+    if (!res.ok) {
+      throw new Error(res.statusText);
+    }
+    const blob = await res.blob();
+    if (currentVideo !== video) return;
+    thumbnailImg.src = URL.createObjectURL(blob);
+    thumbnailImg.style.display = 'block';
+  } catch (error) {
+    console.log(`Couldn't load thumbnail: ${error.message}`);
+    thumbnailImg.style.display = 'none';
+  }
+}
+
+const viewSessionID = crypto.randomUUID();
+let viewTracker = null;
+
+// trackViews reports a view the first time the video plays and sends
+// heartbeats with the playback position while it keeps playing.
+function trackViews(videoID, videoPlayer) {
+  if (viewTracker) {
+    clearInterval(viewTracker.interval);
+  }
+  const tracker = { viewID: null, interval: null };
+  viewTracker = tracker;
+
+  const heartbeat = () => {
+    if (!tracker.viewID || videoPlayer.paused) return;
+    authFetch(`/api/videos/${videoID}/views/${tracker.viewID}/heartbeat`, {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ position: videoPlayer.currentTime }),
+    }).catch((error) => console.log(`Heartbeat failed: ${error.message}`));
+  };
+
+  videoPlayer.onplay = async () => {
+    if (viewTracker !== tracker || tracker.viewID) return;
+    try {
+      const res = await authFetch(`/api/videos/${videoID}/views`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+        },
+        body: JSON.stringify({ session_id: viewSessionID }),
+      });
+      const data = await res.json();
+      if (!res.ok) {
+        throw new Error(data.error);
+      }
+      tracker.viewID = data.view_id;
+      tracker.interval = setInterval(heartbeat, data.heartbeat_interval * 1000);
+    } catch (error) {
+      console.log(`Couldn't record view: ${error.message}`);
+    }
+  };
+  videoPlayer.onpause = heartbeat;
+  videoPlayer.onended = heartbeat;
+}
+
+async function updateVisibility(visibility) {
+  if (!currentVideo) return;
+
+  try {
+    const res = await authFetch(`/api/videos/${currentVideo.id}/visibility`, {
+      method: 'PUT',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ visibility }),
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to update visibility: ${data.error}`);
+    }
+    currentVideo = data;
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+    document.getElementById('video-visibility').value = currentVideo.visibility;
   }
 }
 
@@ -283,16 +482,13 @@ async function deleteVideo() {
   }
 
   try {
-    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+    const res = await authFetch(`/api/videos/${currentVideo.id}`, {
       method: 'DELETE',
-      headers: {
-        Authorization: `Bearer ${localStorage.getItem('token')}`,
-      },
     });
     if (!res.ok) {
       throw new Error('Failed to delete video.');
     }
-    alert('Video deleted successfully.');
+    alert('Video moved to trash.');
     document.getElementById('video-display').style.display = 'none';
     await getVideos();
   } catch (error) {
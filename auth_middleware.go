@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// apiKeyUsageGranularity is how stale an API key's last-used time may get
+// before a request updates it, so busy keys don't write on every request.
+const apiKeyUsageGranularity = time.Minute
+
+// errUnauthenticated wraps every reason a request's credentials are
+// rejected, as opposed to failures looking them up.
+var errUnauthenticated = errors.New("unauthenticated")
+
+// errAccountDisabled rejects valid credentials of a disabled user. It wraps
+// errUnauthenticated, so optionalAuth serves them as anonymous.
+var errAccountDisabled = fmt.Errorf("%w: account is disabled", errUnauthenticated)
+
+// principal is who a request is authenticated as.
+type principal struct {
+	UserID uuid.UUID
+	Role   database.Role
+	// EmailVerified is whether the user has verified their email address.
+	EmailVerified bool
+	// APIKeyID is set when the request used an API key.
+	APIKeyID uuid.UUID
+	// Scopes limits what an API key may do. It's nil for access tokens,
+	// which may do anything their user can.
+	Scopes []auth.Scope
+}
+
+func (p principal) can(scope auth.Scope) bool {
+	return p.Scopes == nil || slices.Contains(p.Scopes, scope)
+}
+
+type principalContextKey struct{}
+
+// requestUserID returns the user that requireAuth or optionalAuth
+// authenticated, or uuid.Nil for anonymous requests.
+func requestUserID(r *http.Request) uuid.UUID {
+	return requestPrincipal(r).UserID
+}
+
+func requestPrincipal(r *http.Request) principal {
+	p, _ := r.Context().Value(principalContextKey{}).(principal)
+	return p
+}
+
+// authenticate checks the Authorization header, which may hold either a
+// bearer access token or an API key, and then that the user may still use
+// the API. The user's role is always read from the database, so role
+// changes and disabled accounts take effect on the next request.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	var p principal
+	if key, err := auth.GetAPIKey(r.Header); err == nil {
+		p, err = cfg.authenticateAPIKey(key)
+		if err != nil {
+			return principal{}, err
+		}
+	} else {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			return principal{}, fmt.Errorf("%w: %v", errUnauthenticated, err)
+		}
+		claims, err := auth.ValidateJWT(token, cfg.jwtKeys)
+		if err != nil {
+			return principal{}, fmt.Errorf("%w: %v", errUnauthenticated, err)
+		}
+		p = principal{UserID: claims.UserID}
+	}
+
+	user, err := cfg.db.GetUser(p.UserID)
+	if err != nil {
+		return principal{}, err
+	}
+	if user == nil {
+		return principal{}, fmt.Errorf("%w: user no longer exists", errUnauthenticated)
+	}
+	if user.DisabledAt != nil {
+		return principal{}, errAccountDisabled
+	}
+	p.Role = user.Role
+	p.EmailVerified = user.EmailVerifiedAt != nil
+	return p, nil
+}
+
+func (cfg *apiConfig) authenticateAPIKey(key string) (principal, error) {
+	prefix, ok := auth.APIKeyPrefix(key)
+	if !ok {
+		return principal{}, fmt.Errorf("%w: malformed API key", errUnauthenticated)
+	}
+	apiKey, err := cfg.db.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		return principal{}, err
+	}
+	hash := auth.HashAPIKey(key)
+	if apiKey.ID == uuid.Nil || subtle.ConstantTimeCompare([]byte(hash), []byte(apiKey.KeyHash)) != 1 {
+		return principal{}, fmt.Errorf("%w: unknown API key", errUnauthenticated)
+	}
+	if apiKey.RevokedAt != nil {
+		return principal{}, fmt.Errorf("%w: API key was revoked", errUnauthenticated)
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyUsageGranularity {
+		if err := cfg.db.MarkAPIKeyUsed(apiKey.ID, now); err != nil {
+			log.Printf("Couldn't record use of API key %s: %v", apiKey.ID, err)
+		}
+	}
+
+	scopes := make([]auth.Scope, len(apiKey.Scopes))
+	for i, s := range apiKey.Scopes {
+		scopes[i] = auth.Scope(s)
+	}
+	return principal{UserID: apiKey.UserID, APIKeyID: apiKey.ID, Scopes: scopes}, nil
+}
+
+// requireAuth only lets authenticated requests through to next, and only
+// API keys granted scope. Handlers behind it read the user with
+// requestUserID.
+func (cfg *apiConfig) requireAuth(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.authenticate(r)
+		if errors.Is(err, errAccountDisabled) {
+			respondWithError(w, http.StatusForbidden, "Account is disabled", err)
+			return
+		}
+		if errors.Is(err, errUnauthenticated) {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't authenticate request", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't authenticate request", err)
+			return
+		}
+		if !p.can(scope) {
+			msg := fmt.Sprintf("API key lacks the %s scope", scope)
+			if scope == auth.ScopeAccount {
+				msg = "API keys can't be used here; log in instead"
+			}
+			respondWithError(w, http.StatusForbidden, msg, nil)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	}
+}
+
+// optionalAuth is requireAuth for endpoints that also serve anonymous
+// visitors. Requests whose credentials don't check out are served as
+// anonymous rather than rejected.
+func (cfg *apiConfig) optionalAuth(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := cfg.authenticate(r)
+		if err == nil && p.can(scope) {
+			r = r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p))
+		}
+		next(w, r)
+	}
+}
+
+// requireRole only lets through users whose role includes role. It goes
+// inside requireAuth, which loads the role.
+func requireRole(role database.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !requestPrincipal(r).Role.Includes(role) {
+			respondWithError(w, http.StatusForbidden, fmt.Sprintf("This needs the %s role", role), nil)
+			return
+		}
+		next(w, r)
+	}
+}
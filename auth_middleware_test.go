@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// whoami responds with who the request was authenticated as.
+func whoami(w http.ResponseWriter, r *http.Request) {
+	p := requestPrincipal(r)
+	respondWithJSON(w, http.StatusOK, map[string]uuid.UUID{"user_id": p.UserID, "api_key_id": p.APIKeyID})
+}
+
+func createTestAPIKey(t *testing.T, cfg *apiConfig, userID uuid.UUID, scopes ...auth.Scope) (string, database.APIKey) {
+	t.Helper()
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		t.Fatalf("MakeAPIKey: %v", err)
+	}
+	names := []string{}
+	for _, s := range scopes {
+		names = append(names, string(s))
+	}
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:  userID,
+		Name:    "test",
+		Prefix:  prefix,
+		KeyHash: auth.HashAPIKey(key),
+		Scopes:  names,
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	return key, apiKey
+}
+
+type authCase struct {
+	name          string
+	authorization string
+	scope         auth.Scope
+	// wantCode is what requireAuth responds with; optionalAuth serves
+	// every request, as wantUser or anonymously.
+	wantCode int
+	wantUser uuid.UUID
+}
+
+func authCases(t *testing.T, cfg *apiConfig) []authCase {
+	alice := createTestUser(t, cfg, "alice@example.com")
+	token := testAccessToken(t, cfg, alice.ID)
+
+	expired, err := auth.MakeJWT(alice.ID, "", cfg.jwtKeys, -time.Minute)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	forged, err := auth.MakeJWT(alice.ID, "", auth.NewSecretKeySet("another-secret"), time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	mfa, err := auth.MakeMFAToken(alice.ID, cfg.jwtKeys, time.Minute)
+	if err != nil {
+		t.Fatalf("MakeMFAToken: %v", err)
+	}
+
+	disabled := createTestUser(t, cfg, "disabled@example.com")
+	if err := cfg.db.SetUserDisabled(disabled.ID, true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	deleted := createTestUser(t, cfg, "deleted@example.com")
+	if err := cfg.db.DeleteUser(deleted.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	readKey, _ := createTestAPIKey(t, cfg, alice.ID, auth.ScopeVideosRead)
+	revokedKey, revoked := createTestAPIKey(t, cfg, alice.ID, auth.ScopeVideosRead)
+	if err := cfg.db.RevokeAPIKey(revoked.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	disabledKey, _ := createTestAPIKey(t, cfg, disabled.ID, auth.ScopeVideosRead)
+	prefix, _ := auth.APIKeyPrefix(readKey)
+	wrongSecret := "tubely_" + prefix + "_0000"
+
+	read, write, account := auth.ScopeVideosRead, auth.ScopeVideosWrite, auth.ScopeAccount
+	return []authCase{
+		{"no credentials", "", read, http.StatusUnauthorized, uuid.Nil},
+		{"access token", "Bearer " + token, read, http.StatusOK, alice.ID},
+		{"access token on an account route", "Bearer " + token, account, http.StatusOK, alice.ID},
+		{"malformed header", "Token " + token, read, http.StatusUnauthorized, uuid.Nil},
+		{"expired access token", "Bearer " + expired, read, http.StatusUnauthorized, uuid.Nil},
+		{"access token signed with another key", "Bearer " + forged, read, http.StatusUnauthorized, uuid.Nil},
+		{"MFA token", "Bearer " + mfa, read, http.StatusUnauthorized, uuid.Nil},
+		{"disabled user", "Bearer " + testAccessToken(t, cfg, disabled.ID), read, http.StatusForbidden, uuid.Nil},
+		{"deleted user", "Bearer " + testAccessToken(t, cfg, deleted.ID), read, http.StatusUnauthorized, uuid.Nil},
+		{"API key with the scope", "ApiKey " + readKey, read, http.StatusOK, alice.ID},
+		{"API key without the scope", "ApiKey " + readKey, write, http.StatusForbidden, uuid.Nil},
+		{"API key on an account route", "ApiKey " + readKey, account, http.StatusForbidden, uuid.Nil},
+		{"revoked API key", "ApiKey " + revokedKey, read, http.StatusUnauthorized, uuid.Nil},
+		{"API key of a disabled user", "ApiKey " + disabledKey, read, http.StatusForbidden, uuid.Nil},
+		{"API key with the wrong secret", "ApiKey " + wrongSecret, read, http.StatusUnauthorized, uuid.Nil},
+		{"malformed API key", "ApiKey tubely_nope", read, http.StatusUnauthorized, uuid.Nil},
+	}
+}
+
+func authenticatedUser(t *testing.T, rec *httptest.ResponseRecorder) uuid.UUID {
+	t.Helper()
+	var body map[string]uuid.UUID
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body, err)
+	}
+	return body["user_id"]
+}
+
+func TestRequireAuth(t *testing.T) {
+	cfg := newTestConfig(t)
+	for _, tc := range authCases(t, cfg) {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/whoami", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := serve("GET /api/whoami", cfg.requireAuth(tc.scope, whoami), req)
+			if rec.Code != tc.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.wantCode, rec.Body)
+			}
+			if tc.wantCode == http.StatusOK {
+				if got := authenticatedUser(t, rec); got != tc.wantUser {
+					t.Errorf("authenticated as %s, want %s", got, tc.wantUser)
+				}
+			}
+		})
+	}
+}
+
+func TestOptionalAuth(t *testing.T) {
+	cfg := newTestConfig(t)
+	for _, tc := range authCases(t, cfg) {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/whoami", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rec := serve("GET /api/whoami", cfg.optionalAuth(tc.scope, whoami), req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if got := authenticatedUser(t, rec); got != tc.wantUser {
+				t.Errorf("authenticated as %s, want %s", got, tc.wantUser)
+			}
+		})
+	}
+}
+
+func TestAPIKeyUseIsRecorded(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	key, apiKey := createTestAPIKey(t, cfg, alice.ID, auth.ScopeVideosRead)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/whoami", nil)
+	req.Header.Set("Authorization", "ApiKey "+key)
+	if rec := serve("GET /api/whoami", cfg.requireAuth(auth.ScopeVideosRead, whoami), req); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	got, err := cfg.db.GetAPIKey(apiKey.ID)
+	if err != nil {
+		t.Fatalf("GetAPIKey: %v", err)
+	}
+	if got.LastUsedAt == nil {
+		t.Error("API key's last use wasn't recorded")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerAdminUsersRetrieve(w http.ResponseWriter, r *http.Request) {
+	users, err := cfg.db.GetUsers()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve users", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Items []database.User `json:"items"`
+	}{Items: users})
+}
+
+func (cfg *apiConfig) handlerAdminUserRoleUpdate(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.getAdminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		Role database.Role `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Role.Valid() {
+		respondWithError(w, http.StatusUnprocessableEntity, "Role must be viewer, creator or admin", nil)
+		return
+	}
+
+	if err := cfg.db.SetUserRole(user.ID, params.Role); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update role", err)
+		return
+	}
+	cfg.respondWithUser(w, user.ID)
+}
+
+// handlerAdminUserDisabledUpdate locks or unlocks an account. Locking also
+// revokes the user's refresh tokens, so unlocking later means logging in
+// again.
+func (cfg *apiConfig) handlerAdminUserDisabledUpdate(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.getAdminTargetUser(w, r)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		Disabled bool `json:"disabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	if err := cfg.db.SetUserDisabled(user.ID, params.Disabled); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update account", err)
+		return
+	}
+	if params.Disabled {
+		if err := cfg.db.RevokeUserRefreshTokens(user.ID); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't revoke sessions", err)
+			return
+		}
+	}
+	cfg.respondWithUser(w, user.ID)
+}
+
+func (cfg *apiConfig) handlerAdminVideoOwnerUpdate(w http.ResponseWriter, r *http.Request) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	var params struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	owner, err := cfg.db.GetUser(params.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if owner == nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "New owner doesn't exist", nil)
+		return
+	}
+
+	video.UserID = owner.ID
+	err = cfg.db.UpdateVideo(video)
+	if errors.Is(err, database.ErrVideoNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+func (cfg *apiConfig) handlerAdminStatsGet(w http.ResponseWriter, r *http.Request) {
+	stats, err := cfg.db.GetSystemStats()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get stats", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, stats)
+}
+
+// getAdminTargetUser looks up the user named by the {userID} path value.
+// Admins can't change their own account here, so there's always another
+// admin left to undo a mistake.
+func (cfg *apiConfig) getAdminTargetUser(w http.ResponseWriter, r *http.Request) (*database.User, bool) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return nil, false
+	}
+	if userID == requestUserID(r) {
+		respondWithError(w, http.StatusUnprocessableEntity, "You can't change your own role or account status", nil)
+		return nil, false
+	}
+
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return nil, false
+	}
+	if user == nil {
+		respondWithError(w, http.StatusNotFound, "User not found", nil)
+		return nil, false
+	}
+	return user, true
+}
+
+func (cfg *apiConfig) respondWithUser(w http.ResponseWriter, userID uuid.UUID) {
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, user)
+}
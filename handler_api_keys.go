@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxAPIKeyNameLength = 100
+
+func validateAPIKeyName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("name is required")
+	}
+	if utf8.RuneCountInString(name) > maxAPIKeyNameLength {
+		return fmt.Errorf("name can't be longer than %d characters", maxAPIKeyNameLength)
+	}
+	return nil
+}
+
+// API keys are managed with access tokens only, so a leaked key can't be
+// used to mint more.
+func (cfg *apiConfig) handlerAPIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	var params struct {
+		Name   string       `json:"name"`
+		Scopes []auth.Scope `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateAPIKeyName(params.Name); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+	if len(params.Scopes) == 0 {
+		respondWithError(w, http.StatusUnprocessableEntity, "At least one scope is required", nil)
+		return
+	}
+	scopes := []string{}
+	for _, scope := range params.Scopes {
+		if !scope.Valid() {
+			respondWithError(w, http.StatusUnprocessableEntity, "Scopes must be videos:read, videos:write or uploads", nil)
+			return
+		}
+		if !slices.Contains(scopes, string(scope)) {
+			scopes = append(scopes, string(scope))
+		}
+	}
+
+	key, prefix, err := auth.MakeAPIKey()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+	apiKey, err := cfg.db.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:  userID,
+		Name:    params.Name,
+		Prefix:  prefix,
+		KeyHash: auth.HashAPIKey(key),
+		Scopes:  scopes,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create API key", err)
+		return
+	}
+
+	// This is the only time the key itself is ever shown.
+	respondWithJSON(w, http.StatusCreated, struct {
+		database.APIKey
+		Key string `json:"key"`
+	}{APIKey: apiKey, Key: key})
+}
+
+func (cfg *apiConfig) handlerAPIKeysRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	keys, err := cfg.db.ListAPIKeys(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve API keys", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Items []database.APIKey `json:"items"`
+	}{Items: keys})
+}
+
+func (cfg *apiConfig) handlerAPIKeyUpdate(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := cfg.getOwnedAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateAPIKeyName(params.Name); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+
+	if err := cfg.db.RenameAPIKey(apiKey.ID, params.Name); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't rename API key", err)
+		return
+	}
+	apiKey.Name = params.Name
+
+	respondWithJSON(w, http.StatusOK, apiKey)
+}
+
+func (cfg *apiConfig) handlerAPIKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := cfg.getOwnedAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.RevokeAPIKey(apiKey.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't revoke API key", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOwnedAPIKey loads the caller's API key named in the path. Other users'
+// keys look like missing ones. It responds with the error itself and
+// returns false on failure.
+func (cfg *apiConfig) getOwnedAPIKey(w http.ResponseWriter, r *http.Request) (database.APIKey, bool) {
+	keyID, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.APIKey{}, false
+	}
+
+	userID := requestUserID(r)
+
+	apiKey, err := cfg.db.GetAPIKey(keyID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get API key", err)
+		return database.APIKey{}, false
+	}
+	if apiKey.ID == uuid.Nil || apiKey.UserID != userID {
+		respondWithError(w, http.StatusNotFound, "API key not found", nil)
+		return database.APIKey{}, false
+	}
+	return apiKey, true
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxCommentLength = 2000
+
+type commentPageResponse struct {
+	// Pinned is only set on the first page of top-level comments.
+	Pinned *database.Comment `json:"pinned,omitempty"`
+	database.CommentPage
+}
+
+func validateCommentBody(body string) error {
+	if strings.TrimSpace(body) == "" {
+		return errors.New("body is required")
+	}
+	if utf8.RuneCountInString(body) > maxCommentLength {
+		return fmt.Errorf("body can't be longer than %d characters", maxCommentLength)
+	}
+	return nil
+}
+
+func parseCommentPageParams(query url.Values, params *database.ListCommentsParams) error {
+	params.Cursor = query.Get("cursor")
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return errors.New("limit must be a positive integer")
+		}
+		params.Limit = min(n, database.MaxCommentPageSize)
+	}
+	return nil
+}
+
+// getVideoComment loads the comment named in the path and checks it belongs
+// to video. It responds with the error itself and returns false if not.
+func (cfg *apiConfig) getVideoComment(w http.ResponseWriter, r *http.Request, video database.Video) (database.Comment, bool) {
+	commentID, err := uuid.Parse(r.PathValue("commentID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid comment ID", err)
+		return database.Comment{}, false
+	}
+	comment, err := cfg.db.GetComment(commentID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return database.Comment{}, false
+	}
+	if comment.ID == uuid.Nil || comment.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Comment not found", nil)
+		return database.Comment{}, false
+	}
+	return comment, true
+}
+
+func (cfg *apiConfig) handlerCommentsRetrieve(w http.ResponseWriter, r *http.Request) {
+	viewerID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, viewerID)
+	if !ok {
+		return
+	}
+
+	params := database.ListCommentsParams{
+		VideoID:       video.ID,
+		ViewerID:      viewerID,
+		IncludeHidden: video.UserID == viewerID,
+	}
+	if err := parseCommentPageParams(r.URL.Query(), &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	page, err := cfg.db.ListComments(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve comments", err)
+		return
+	}
+
+	resp := commentPageResponse{CommentPage: page}
+	if params.Cursor == "" {
+		pinned, err := cfg.db.GetPinnedComment(video.ID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve comments", err)
+			return
+		}
+		if pinned.ID != uuid.Nil && (!pinned.Hidden || params.IncludeHidden || pinned.UserID == viewerID) {
+			resp.Pinned = &pinned
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+func (cfg *apiConfig) handlerCommentRepliesRetrieve(w http.ResponseWriter, r *http.Request) {
+	viewerID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, viewerID)
+	if !ok {
+		return
+	}
+	comment, ok := cfg.getVideoComment(w, r, video)
+	if !ok {
+		return
+	}
+
+	params := database.ListCommentsParams{
+		VideoID:       video.ID,
+		ParentID:      comment.ID,
+		ViewerID:      viewerID,
+		IncludeHidden: video.UserID == viewerID,
+	}
+	if err := parseCommentPageParams(r.URL.Query(), &params); err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	page, err := cfg.db.ListComments(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve replies", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, page)
+}
+
+func (cfg *apiConfig) handlerCommentCreate(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		Body     string     `json:"body"`
+		ParentID *uuid.UUID `json:"parent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateCommentBody(params.Body); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+
+	if params.ParentID != nil {
+		parent, err := cfg.db.GetComment(*params.ParentID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+			return
+		}
+		if parent.ID == uuid.Nil || parent.VideoID != video.ID {
+			respondWithError(w, http.StatusNotFound, "Parent comment not found", nil)
+			return
+		}
+		if parent.ParentID != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, "Replies can't be replied to", nil)
+			return
+		}
+	}
+
+	comment, err := cfg.db.CreateComment(database.CreateCommentParams{
+		VideoID:  video.ID,
+		UserID:   userID,
+		ParentID: params.ParentID,
+		Body:     params.Body,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create comment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, comment)
+}
+
+func (cfg *apiConfig) handlerCommentUpdate(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, userID)
+	if !ok {
+		return
+	}
+	comment, ok := cfg.getVideoComment(w, r, video)
+	if !ok {
+		return
+	}
+	if comment.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this comment", nil)
+		return
+	}
+
+	var params struct {
+		Body string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateCommentBody(params.Body); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+
+	if err := cfg.db.UpdateCommentBody(comment.ID, params.Body); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update comment", err)
+		return
+	}
+	comment, err := cfg.db.GetComment(comment.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, comment)
+}
+
+// handlerCommentDelete lets authors delete their comments and video owners
+// delete any comment on their videos. Replies go with their comment.
+func (cfg *apiConfig) handlerCommentDelete(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, userID)
+	if !ok {
+		return
+	}
+	comment, ok := cfg.getVideoComment(w, r, video)
+	if !ok {
+		return
+	}
+	if comment.UserID != userID && video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't delete this comment", nil)
+		return
+	}
+
+	if err := cfg.db.DeleteComment(comment.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete comment", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerCommentHiddenUpdate lets a video's owner hide a comment from
+// everybody but themselves and its author, or show it again.
+func (cfg *apiConfig) handlerCommentHiddenUpdate(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, userID)
+	if !ok {
+		return
+	}
+	comment, ok := cfg.getVideoComment(w, r, video)
+	if !ok {
+		return
+	}
+	if !authorizeVideoOwner(w, video, userID) {
+		return
+	}
+
+	var params struct {
+		Hidden *bool `json:"hidden"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Hidden == nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "hidden is required", nil)
+		return
+	}
+
+	if err := cfg.db.SetCommentHidden(comment.ID, *params.Hidden); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update comment", err)
+		return
+	}
+	comment, err := cfg.db.GetComment(comment.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, comment)
+}
+
+// handlerCommentPin pins a top-level comment above the others on the
+// owner's video, replacing any earlier pin.
+func (cfg *apiConfig) handlerCommentPin(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, userID)
+	if !ok {
+		return
+	}
+	comment, ok := cfg.getVideoComment(w, r, video)
+	if !ok {
+		return
+	}
+	if !authorizeVideoOwner(w, video, userID) {
+		return
+	}
+	if comment.ParentID != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, "Replies can't be pinned", nil)
+		return
+	}
+
+	if err := cfg.db.PinComment(video.ID, comment.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't pin comment", err)
+		return
+	}
+	comment, err := cfg.db.GetComment(comment.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get comment", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, comment)
+}
+
+func (cfg *apiConfig) handlerCommentUnpin(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, userID)
+	if !ok {
+		return
+	}
+	comment, ok := cfg.getVideoComment(w, r, video)
+	if !ok {
+		return
+	}
+	if !authorizeVideoOwner(w, video, userID) {
+		return
+	}
+
+	if comment.Pinned {
+		if err := cfg.db.PinComment(video.ID, uuid.Nil); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't unpin comment", err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mail"
+	"github.com/google/uuid"
+)
+
+const (
+	verifyEmailTokenLifetime   = 48 * time.Hour
+	resetPasswordTokenLifetime = time.Hour
+	// mailSendTimeout bounds how long a background send may take.
+	mailSendTimeout = 30 * time.Second
+)
+
+// sendEmailToken saves a new token for purpose and emails the user a link
+// with it. The email is sent in the background, so neither a slow mail
+// server nor whether the user exists shows in the response time.
+func (cfg *apiConfig) sendEmailToken(user database.User, purpose database.EmailTokenPurpose) error {
+	token, err := auth.MakeEmailToken()
+	if err != nil {
+		return err
+	}
+	lifetime := verifyEmailTokenLifetime
+	if purpose == database.EmailTokenResetPassword {
+		lifetime = resetPasswordTokenLifetime
+	}
+	err = cfg.db.CreateEmailToken(database.CreateEmailTokenParams{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: auth.HashEmailToken(token),
+		ExpiresAt: time.Now().UTC().Add(lifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := cfg.emailTokenMessage(user.Email, purpose, token, lifetime)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+		if err := cfg.mailer.Send(ctx, msg); err != nil {
+			log.Printf("Couldn't send %s email to user %s: %v", purpose, user.ID, err)
+		}
+	}()
+	return nil
+}
+
+// describeLifetime says how long a link lasts in words, in whole hours.
+func describeLifetime(d time.Duration) string {
+	if hours := int(d.Hours()); hours != 1 {
+		return fmt.Sprintf("%d hours", hours)
+	}
+	return "an hour"
+}
+
+func (cfg *apiConfig) emailTokenMessage(to string, purpose database.EmailTokenPurpose, token string, lifetime time.Duration) mail.Message {
+	link := cfg.appURL + "?" + url.Values{string(purpose): {token}}.Encode()
+	if purpose == database.EmailTokenResetPassword {
+		return mail.Message{
+			To:      to,
+			Subject: "Reset your Tubely password",
+			Body: fmt.Sprintf("Someone asked to reset the password of your Tubely account. If it was you, open this link within %s to choose a new one:\n\n%s\n\nIf it wasn't you, ignore this email; your password hasn't changed.\n",
+				describeLifetime(lifetime), link),
+		}
+	}
+	return mail.Message{
+		To:      to,
+		Subject: "Verify your email for Tubely",
+		Body: fmt.Sprintf("Welcome to Tubely! Open this link within %s to confirm this is your email address:\n\n%s\n",
+			describeLifetime(lifetime), link),
+	}
+}
+
+// handlerVerificationEmailSend emails the user a new verification link.
+func (cfg *apiConfig) handlerVerificationEmailSend(w http.ResponseWriter, r *http.Request) {
+	user, err := cfg.db.GetUser(requestUserID(r))
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.EmailVerifiedAt != nil {
+		respondWithError(w, http.StatusConflict, "Email address is already verified", nil)
+		return
+	}
+
+	if err := cfg.sendEmailToken(*user, database.EmailTokenVerifyEmail); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't send verification email", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerEmailVerify marks a user's email address as verified with the
+// token from their verification email. It doesn't need a login, since the
+// link may be opened on another device.
+func (cfg *apiConfig) handlerEmailVerify(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := cfg.db.UseEmailToken(database.EmailTokenVerifyEmail, auth.HashEmailToken(params.Token))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token", err)
+		return
+	}
+	if userID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired link; request a new one", nil)
+		return
+	}
+	if err := cfg.db.SetUserEmailVerified(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email address", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerPasswordForgot emails a password reset link. It answers the same
+// whether or not the address belongs to a user, so it can't be used to
+// find out who has an account.
+func (cfg *apiConfig) handlerPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	user, err := cfg.db.GetUserByEmail(params.Email)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.ID != uuid.Nil && user.DisabledAt == nil {
+		if err := cfg.sendEmailToken(user, database.EmailTokenResetPassword); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't send password reset email", err)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handlerPasswordReset sets a new password with the token from a password
+// reset email, and logs the user out everywhere. Following the link also
+// proves the email address works, so it's marked verified.
+func (cfg *apiConfig) handlerPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Password == "" {
+		respondWithError(w, http.StatusBadRequest, "Password is required", nil)
+		return
+	}
+	hashedPassword, err := auth.HashPassword(params.Password)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't hash password", err)
+		return
+	}
+
+	tokenHash := auth.HashEmailToken(params.Token)
+	userID, err := cfg.db.GetEmailTokenUser(database.EmailTokenResetPassword, tokenHash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token", err)
+		return
+	}
+	if userID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired link; request a new one", nil)
+		return
+	}
+	// A disabled account keeps its link, so it still works if the account
+	// is enabled again before it expires.
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired link; request a new one", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	userID, err = cfg.db.UseEmailToken(database.EmailTokenResetPassword, tokenHash)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check token", err)
+		return
+	}
+	if userID == uuid.Nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid or expired link; request a new one", nil)
+		return
+	}
+
+	if err := cfg.db.SetUserPassword(userID, hashedPassword); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update password", err)
+		return
+	}
+	if err := cfg.db.RevokeUserRefreshTokens(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't log out other sessions", err)
+		return
+	}
+	if err := cfg.db.SetUserEmailVerified(userID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't verify email address", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// requireVerifiedEmail blocks users who haven't verified their email
+// address from next when REQUIRE_VERIFIED_EMAIL is on. It goes inside
+// requireAuth.
+func (cfg *apiConfig) requireVerifiedEmail(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.uploadsNeedVerifiedEmail && !requestPrincipal(r).EmailVerified {
+			respondWithError(w, http.StatusForbidden, "Verify your email address before uploading", nil)
+			return
+		}
+		next(w, r)
+	}
+}
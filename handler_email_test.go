@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestPasswordResetDisabledAccount(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	err := cfg.db.CreateEmailToken(database.CreateEmailTokenParams{
+		UserID:    alice.ID,
+		Purpose:   database.EmailTokenResetPassword,
+		TokenHash: auth.HashEmailToken("reset-token"),
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateEmailToken: %v", err)
+	}
+	if err := cfg.db.SetUserDisabled(alice.ID, true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+
+	reset := func() int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/password/reset", strings.NewReader(`{"token":"reset-token","password":"new password"}`))
+		return serve("POST /api/password/reset", http.HandlerFunc(cfg.handlerPasswordReset), req).Code
+	}
+	if code := reset(); code != http.StatusForbidden {
+		t.Fatalf("reset for a disabled account = %d, want 403", code)
+	}
+	user, err := cfg.db.GetUser(alice.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if auth.CheckPasswordHash("password", user.Password) != nil {
+		t.Error("the disabled account's password was changed")
+	}
+	if user.EmailVerifiedAt != nil {
+		t.Error("the disabled account's email was verified")
+	}
+
+	if err := cfg.db.SetUserDisabled(alice.ID, false); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if code := reset(); code != http.StatusNoContent {
+		t.Errorf("reset once the account is enabled again = %d, want 204", code)
+	}
+}
@@ -1,32 +1,68 @@
 package main
 
 import (
-	"fmt"
+	"errors"
+	"io/fs"
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
+// avatarKeyPrefix is where avatars are kept among the assets. Avatars are
+// public; every other asset is a video thumbnail.
+const avatarKeyPrefix = "avatars/"
+
+// handlerThumbnailGet sends the requester to the video's thumbnail.
 func (cfg *apiConfig) handlerThumbnailGet(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+	video, ok := cfg.getWatchableVideo(w, r, requestUserID(r))
+	if !ok {
 		return
 	}
-
-	tn, ok := videoThumbnails[videoID]
-	if !ok {
+	if video.ThumbnailURL == nil {
 		respondWithError(w, http.StatusNotFound, "Thumbnail not found", nil)
 		return
 	}
+	http.Redirect(w, r, *video.ThumbnailURL, http.StatusFound)
+}
 
-	w.Header().Set("Content-Type", tn.mediaType)
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(tn.data)))
+// handlerAssetGet serves a file from the assets directory. A thumbnail is
+// only served to those who may see its video, so it's looked up by its URL;
+// files that aren't any video's current thumbnail aren't served at all.
+// Directories aren't listed.
+func (cfg *apiConfig) handlerAssetGet(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if !strings.HasPrefix(key, avatarKeyPrefix) {
+		video, err := cfg.db.GetVideoByThumbnailURL(cfg.thumbnailStorage.URL(key))
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+			return
+		}
+		if video.ID == uuid.Nil || !video.VisibleTo(requestUserID(r)) {
+			respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+			return
+		}
+	}
 
-	_, err = w.Write(tn.data)
+	f, err := http.Dir(cfg.assetsRoot).Open("/" + key)
+	if errors.Is(err, fs.ErrNotExist) {
+		respondWithError(w, http.StatusNotFound, "Asset not found", nil)
+		return
+	}
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error writing response", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open asset", err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open asset", err)
+		return
+	}
+	if info.IsDir() {
+		respondWithError(w, http.StatusNotFound, "Asset not found", nil)
 		return
 	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
 }
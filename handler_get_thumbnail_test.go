@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestAssetVisibility(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	bob := createTestUser(t, cfg, "bob@example.com")
+	aliceToken, bobToken := testAccessToken(t, cfg, alice.ID), testAccessToken(t, cfg, bob.ID)
+
+	writeAsset := func(key string) {
+		t.Helper()
+		p := filepath.Join(cfg.assetsRoot, filepath.FromSlash(key))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("image of "+key), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addThumbnail := func(visibility database.Visibility, key string) {
+		t.Helper()
+		video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: key, UserID: alice.ID, Visibility: visibility})
+		if err != nil {
+			t.Fatalf("CreateVideo: %v", err)
+		}
+		writeAsset(key)
+		url := cfg.thumbnailStorage.URL(key)
+		video.ThumbnailURL = &url
+		if err := cfg.db.UpdateVideo(video); err != nil {
+			t.Fatalf("UpdateVideo: %v", err)
+		}
+	}
+	addThumbnail(database.VisibilityPrivate, "private.png")
+	addThumbnail(database.VisibilityPublic, "public.png")
+	writeAsset("avatars/alice.png")
+	writeAsset("orphan.png")
+
+	handler := cfg.optionalAuth(auth.ScopeVideosRead, cfg.handlerAssetGet)
+	for _, tc := range []struct {
+		name, path, token string
+		want              int
+	}{
+		{"private thumbnail to its owner", "/assets/private.png", aliceToken, http.StatusOK},
+		{"private thumbnail to another user", "/assets/private.png", bobToken, http.StatusNotFound},
+		{"private thumbnail to anonymous", "/assets/private.png", "", http.StatusNotFound},
+		{"public thumbnail to anonymous", "/assets/public.png", "", http.StatusOK},
+		{"avatar to anonymous", "/assets/avatars/alice.png", "", http.StatusOK},
+		{"file that's no video's thumbnail", "/assets/orphan.png", aliceToken, http.StatusNotFound},
+		{"missing avatar", "/assets/avatars/missing.png", "", http.StatusNotFound},
+		{"assets directory", "/assets/", aliceToken, http.StatusNotFound},
+		{"avatars directory", "/assets/avatars/", "", http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := withBearer(httptest.NewRequest(http.MethodGet, tc.path, nil), tc.token)
+			rec := serve("GET /assets/{key...}", handler, req)
+			if rec.Code != tc.want {
+				t.Fatalf("GET %s = %d, want %d: %s", tc.path, rec.Code, tc.want, rec.Body)
+			}
+			if tc.want == http.StatusOK && rec.Body.String() != "image of "+tc.path[len("/assets/"):] {
+				t.Errorf("GET %s served %q", tc.path, rec.Body)
+			}
+		})
+	}
+}
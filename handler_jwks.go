@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// handlerJWKS publishes the public keys access tokens are signed with, so
+// other services can verify them. Verifiers should refetch it when they
+// see an unknown kid.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, http.StatusOK, struct {
+		Keys []auth.JWK `json:"keys"`
+	}{Keys: cfg.jwtKeys.JWKS()})
+}
@@ -14,12 +14,6 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 		Email    string `json:"email"`
 	}
-	type response struct {
-		database.User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
-	}
-
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
@@ -40,10 +34,50 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
+
+// respondWithLogin finishes logging in a user who proved who they are,
+// with a password or through single sign-on. Users with two-factor
+// authentication get an MFA challenge; everyone else gets a new session.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	totp, err := cfg.db.GetTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp.Enabled() {
+		mfaToken, err := auth.MakeMFAToken(user.ID, cfg.jwtKeys, mfaChallengeLifetime)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't create MFA token", err)
+			return
+		}
+		respondWithJSON(w, http.StatusOK, mfaChallengeResponse{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+
+	cfg.respondWithNewSession(w, r, user)
+}
+
+// respondWithNewSession finishes a login by issuing the user an access
+// token and the first refresh token of a new session.
+func (cfg *apiConfig) respondWithNewSession(w http.ResponseWriter, r *http.Request, user database.User) {
+	type response struct {
+		database.User
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
-		time.Hour*24*30,
+		string(user.Role),
+		cfg.jwtKeys,
+		accessTokenLifetime,
 	)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
@@ -57,9 +91,10 @@ func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	_, err = cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
-		UserID:    user.ID,
-		Token:     refreshToken,
-		ExpiresAt: time.Now().UTC().Add(time.Hour * 24 * 60),
+		UserID:        user.ID,
+		Token:         refreshToken,
+		ExpiresAt:     time.Now().UTC().Add(refreshTokenLifetime),
+		SessionClient: sessionClient(r),
 	})
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save refresh token", err)
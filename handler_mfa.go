@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	totpIssuer = "Tubely"
+	// mfaChallengeLifetime is how long a user has to enter their second
+	// factor after getting their password right.
+	mfaChallengeLifetime = 5 * time.Minute
+	recoveryCodeCount    = 10
+	// After maxMFAFailures wrong codes in a row, codes are refused until
+	// mfaLockout has passed since the last one.
+	maxMFAFailures = 5
+	mfaLockout     = 15 * time.Minute
+)
+
+var (
+	errMFALocked      = errors.New("too many wrong codes")
+	errMFACodeInvalid = errors.New("invalid code")
+)
+
+type mfaChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// secondFactorParams is how a user proves they have their authenticator:
+// a code from the app, or one of their recovery codes.
+type secondFactorParams struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// verifySecondFactor checks params against the user's TOTP enrollment. It
+// counts wrong codes and returns errMFALocked once there have been too
+// many. Each attempt is counted as wrong before the code is checked, so
+// guesses sent all at once are held to the limit too.
+func (cfg *apiConfig) verifySecondFactor(totp database.TOTP, params secondFactorParams) error {
+	now := time.Now()
+	allowed, err := cfg.db.RecordTOTPAttempt(totp.UserID, now, maxMFAFailures, mfaLockout)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errMFALocked
+	}
+
+	var ok bool
+	switch {
+	case params.Code != "":
+		var step int64
+		step, ok = auth.ValidateTOTP(totp.Secret, params.Code, now)
+		if ok {
+			ok, err = cfg.db.UseTOTPStep(totp.UserID, step)
+		}
+	case params.RecoveryCode != "":
+		ok, err = cfg.db.UseRecoveryCode(totp.UserID, auth.HashRecoveryCode(params.RecoveryCode))
+	}
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return errMFACodeInvalid
+	}
+	return cfg.db.ClearTOTPFailures(totp.UserID)
+}
+
+// respondWithSecondFactorError responds to a failed verifySecondFactor
+// and returns false, or returns true if it succeeded.
+func respondWithSecondFactorError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errMFALocked):
+		respondWithError(w, http.StatusTooManyRequests, "Too many wrong codes; try again later", err)
+	case errors.Is(err, errMFACodeInvalid):
+		respondWithError(w, http.StatusUnauthorized, "Invalid code", err)
+	default:
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check code", err)
+	}
+	return false
+}
+
+// handlerLoginMFA is the second step of logging in with two-factor
+// authentication turned on. It trades the mfa_token from handlerLogin and
+// a code for the usual access and refresh tokens.
+func (cfg *apiConfig) handlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		MFAToken string `json:"mfa_token"`
+		secondFactorParams
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := auth.ValidateMFAToken(params.MFAToken, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token; log in again", err)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired MFA token; log in again", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	totp, err := cfg.db.GetTOTP(user.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if !totp.Enabled() {
+		respondWithError(w, http.StatusUnauthorized, "Two-factor authentication was turned off; log in again", nil)
+		return
+	}
+	if !respondWithSecondFactorError(w, cfg.verifySecondFactor(totp, params.secondFactorParams)) {
+		return
+	}
+
+	cfg.respondWithNewSession(w, r, *user)
+}
+
+func (cfg *apiConfig) handlerTOTPGet(w http.ResponseWriter, r *http.Request) {
+	totp, err := cfg.db.GetTOTP(requestUserID(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Enabled           bool       `json:"enabled"`
+		ConfirmedAt       *time.Time `json:"confirmed_at"`
+		RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	}{
+		Enabled:           totp.Enabled(),
+		ConfirmedAt:       totp.ConfirmedAt,
+		RecoveryCodesLeft: totp.RecoveryCodesLeft,
+	})
+}
+
+// handlerTOTPEnroll starts setting up an authenticator app. TOTP isn't
+// required at login until the user confirms a code from it.
+func (cfg *apiConfig) handlerTOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	totp, err := cfg.db.GetTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp.Enabled() {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already on", nil)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create secret", err)
+		return
+	}
+	if err := cfg.db.StartTOTPEnrollment(userID, secret); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save secret", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	})
+}
+
+// handlerTOTPConfirm turns two-factor authentication on once the user
+// enters a code from their app, and returns their recovery codes. They're
+// only shown this once.
+func (cfg *apiConfig) handlerTOTPConfirm(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	var params struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	totp, err := cfg.db.GetTOTP(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return
+	}
+	if totp.Enabled() {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is already on", nil)
+		return
+	}
+	if totp.Secret == "" {
+		respondWithError(w, http.StatusConflict, "Start setting up two-factor authentication first", nil)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, http.StatusUnprocessableEntity, "Invalid code; check your device's clock and try again", nil)
+		return
+	}
+
+	codes, hashes, err := makeRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	if err := cfg.db.ConfirmTOTP(userID, step, hashes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn on two-factor authentication", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// handlerRecoveryCodesRegenerate replaces the user's recovery codes,
+// used or not, with new ones.
+func (cfg *apiConfig) handlerRecoveryCodesRegenerate(w http.ResponseWriter, r *http.Request) {
+	totp, ok := cfg.getEnabledTOTPWithSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	codes, hashes, err := makeRecoveryCodes()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create recovery codes", err)
+		return
+	}
+	if err := cfg.db.ReplaceRecoveryCodes(totp.UserID, hashes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save recovery codes", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (cfg *apiConfig) handlerTOTPDisable(w http.ResponseWriter, r *http.Request) {
+	totp, ok := cfg.getEnabledTOTPWithSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DeleteTOTP(totp.UserID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't turn off two-factor authentication", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getEnabledTOTPWithSecondFactor guards changes to two-factor settings,
+// which need a code on top of the access token. It responds with the error
+// itself and returns false if the code doesn't check out.
+func (cfg *apiConfig) getEnabledTOTPWithSecondFactor(w http.ResponseWriter, r *http.Request) (database.TOTP, bool) {
+	var params secondFactorParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return database.TOTP{}, false
+	}
+
+	totp, err := cfg.db.GetTOTP(requestUserID(r))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get two-factor settings", err)
+		return database.TOTP{}, false
+	}
+	if !totp.Enabled() {
+		respondWithError(w, http.StatusConflict, "Two-factor authentication is off", nil)
+		return database.TOTP{}, false
+	}
+	if !respondWithSecondFactorError(w, cfg.verifySecondFactor(totp, params)) {
+		return database.TOTP{}, false
+	}
+	return totp, true
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func makeRecoveryCodes() (codes, hashes []string, err error) {
+	codes, err = auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	hashes = make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = auth.HashRecoveryCode(code)
+	}
+	return codes, hashes, nil
+}
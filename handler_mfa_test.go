@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+// totpCode computes the code an authenticator app shows for secret at at,
+// following RFC 6238 independently of the auth package.
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret: %v", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1_000_000)
+}
+
+// enableTOTP turns on two-factor authentication for the user, as if they
+// had confirmed it long ago, and returns their secret and recovery codes.
+func enableTOTP(t *testing.T, cfg *apiConfig, userID uuid.UUID) (string, []string) {
+	t.Helper()
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		t.Fatalf("MakeTOTPSecret: %v", err)
+	}
+	if err := cfg.db.StartTOTPEnrollment(userID, secret); err != nil {
+		t.Fatalf("StartTOTPEnrollment: %v", err)
+	}
+	codes, hashes, err := makeRecoveryCodes()
+	if err != nil {
+		t.Fatalf("makeRecoveryCodes: %v", err)
+	}
+	if err := cfg.db.ConfirmTOTP(userID, 0, hashes); err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	return secret, codes
+}
+
+// verify runs verifySecondFactor against the user's current enrollment,
+// as the handlers do.
+func verify(t *testing.T, cfg *apiConfig, userID uuid.UUID, params secondFactorParams) error {
+	t.Helper()
+	totp, err := cfg.db.GetTOTP(userID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	return cfg.verifySecondFactor(totp, params)
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	secret, recoveryCodes := enableTOTP(t, cfg, alice.ID)
+	now := time.Now()
+
+	for _, tc := range []struct {
+		name   string
+		params secondFactorParams
+		want   error
+	}{
+		{"current code", secondFactorParams{Code: totpCode(t, secret, now)}, nil},
+		{"current code again", secondFactorParams{Code: totpCode(t, secret, now)}, errMFACodeInvalid},
+		{"code from before the last one used", secondFactorParams{Code: totpCode(t, secret, now.Add(-30*time.Second))}, errMFACodeInvalid},
+		{"next code, within the allowed drift", secondFactorParams{Code: totpCode(t, secret, now.Add(30*time.Second))}, nil},
+		{"code from too far ahead", secondFactorParams{Code: totpCode(t, secret, now.Add(2*time.Minute))}, errMFACodeInvalid},
+		{"wrong code", secondFactorParams{Code: "000000"}, errMFACodeInvalid},
+		{"recovery code typed loosely", secondFactorParams{RecoveryCode: strings.ToLower(strings.ReplaceAll(recoveryCodes[0], "-", " "))}, nil},
+		{"recovery code again", secondFactorParams{RecoveryCode: recoveryCodes[0]}, errMFACodeInvalid},
+		{"another recovery code", secondFactorParams{RecoveryCode: recoveryCodes[1]}, nil},
+		{"made-up recovery code", secondFactorParams{RecoveryCode: "ABCD-EFGH-IJKL-MNOP-QRST-UVWX"}, errMFACodeInvalid},
+		{"no code", secondFactorParams{}, errMFACodeInvalid},
+	} {
+		if err := verify(t, cfg, alice.ID, tc.params); !errors.Is(err, tc.want) {
+			t.Errorf("%s: verifySecondFactor = %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	totp, err := cfg.db.GetTOTP(alice.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if totp.RecoveryCodesLeft != recoveryCodeCount-2 {
+		t.Errorf("%d recovery codes left, want %d", totp.RecoveryCodesLeft, recoveryCodeCount-2)
+	}
+}
+
+func TestVerifySecondFactorLockout(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	secret, recoveryCodes := enableTOTP(t, cfg, alice.ID)
+
+	// A right code clears the wrong ones before it.
+	for range maxMFAFailures - 1 {
+		if err := verify(t, cfg, alice.ID, secondFactorParams{Code: "000000"}); !errors.Is(err, errMFACodeInvalid) {
+			t.Fatalf("wrong code: verifySecondFactor = %v, want errMFACodeInvalid", err)
+		}
+	}
+	if err := verify(t, cfg, alice.ID, secondFactorParams{RecoveryCode: recoveryCodes[0]}); err != nil {
+		t.Fatalf("recovery code: verifySecondFactor = %v", err)
+	}
+	if totp, _ := cfg.db.GetTOTP(alice.ID); totp.FailedAttempts != 0 {
+		t.Errorf("%d failed attempts after a right code, want 0", totp.FailedAttempts)
+	}
+
+	for range maxMFAFailures {
+		if err := verify(t, cfg, alice.ID, secondFactorParams{Code: "000000"}); !errors.Is(err, errMFACodeInvalid) {
+			t.Fatalf("wrong code: verifySecondFactor = %v, want errMFACodeInvalid", err)
+		}
+	}
+	for name, params := range map[string]secondFactorParams{
+		"right code":    {Code: totpCode(t, secret, time.Now())},
+		"recovery code": {RecoveryCode: recoveryCodes[1]},
+	} {
+		if err := verify(t, cfg, alice.ID, params); !errors.Is(err, errMFALocked) {
+			t.Errorf("%s while locked: verifySecondFactor = %v, want errMFALocked", name, err)
+		}
+	}
+
+	// Once the lockout has passed since the last wrong code, codes are
+	// accepted again.
+	bob := createTestUser(t, cfg, "bob@example.com")
+	bobSecret, _ := enableTOTP(t, cfg, bob.ID)
+	for range maxMFAFailures {
+		if _, err := cfg.db.RecordTOTPAttempt(bob.ID, time.Now().Add(-mfaLockout-time.Minute), maxMFAFailures, mfaLockout); err != nil {
+			t.Fatalf("RecordTOTPAttempt: %v", err)
+		}
+	}
+	if err := verify(t, cfg, bob.ID, secondFactorParams{Code: totpCode(t, bobSecret, time.Now())}); err != nil {
+		t.Errorf("right code after the lockout: verifySecondFactor = %v", err)
+	}
+	if totp, _ := cfg.db.GetTOTP(bob.ID); totp.FailedAttempts != 0 {
+		t.Errorf("%d failed attempts after a right code, want 0", totp.FailedAttempts)
+	}
+}
+
+// Wrong codes sent all at once mustn't each get past the lockout check
+// before any of them is counted.
+func TestVerifySecondFactorConcurrentLockout(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	enableTOTP(t, cfg, alice.ID)
+	totp, err := cfg.db.GetTOTP(alice.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+
+	const guesses = 50
+	results := make(chan error, guesses)
+	var start sync.WaitGroup
+	start.Add(1)
+	for i := range guesses {
+		go func() {
+			start.Wait()
+			results <- cfg.verifySecondFactor(totp, secondFactorParams{Code: fmt.Sprintf("%06d", i)})
+		}()
+	}
+	start.Done()
+
+	checked := 0
+	for range guesses {
+		err := <-results
+		switch {
+		case errors.Is(err, errMFACodeInvalid), err == nil:
+			checked++
+		case !errors.Is(err, errMFALocked):
+			t.Errorf("verifySecondFactor = %v", err)
+		}
+	}
+	if checked > maxMFAFailures {
+		t.Errorf("%d of %d concurrent guesses were checked, want at most %d", checked, guesses, maxMFAFailures)
+	}
+}
+
+func postJSON(path string, body any) *http.Request {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body, err)
+	}
+}
+
+func TestLoginMFA(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	secret, _ := enableTOTP(t, cfg, alice.ID)
+
+	rec := serve("POST /api/login", http.HandlerFunc(cfg.handlerLogin),
+		postJSON("/api/login", map[string]string{"email": alice.Email, "password": "password"}))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login = %d: %s", rec.Code, rec.Body)
+	}
+	var challenge struct {
+		mfaChallengeResponse
+		Token string `json:"token"`
+	}
+	decodeJSON(t, rec, &challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" || challenge.Token != "" {
+		t.Fatalf("login = %s, want an MFA challenge without tokens", rec.Body)
+	}
+	if _, err := auth.ValidateJWT(challenge.MFAToken, cfg.jwtKeys); err == nil {
+		t.Error("MFA token works as an access token")
+	}
+
+	loginMFA := func(mfaToken, code string) *httptest.ResponseRecorder {
+		return serve("POST /api/login/mfa", http.HandlerFunc(cfg.handlerLoginMFA),
+			postJSON("/api/login/mfa", map[string]string{"mfa_token": mfaToken, "code": code}))
+	}
+	if rec := loginMFA(challenge.MFAToken, "000000"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong code = %d, want 401: %s", rec.Code, rec.Body)
+	}
+	if rec := loginMFA(testAccessToken(t, cfg, alice.ID), totpCode(t, secret, time.Now())); rec.Code != http.StatusUnauthorized {
+		t.Errorf("access token as MFA token = %d, want 401: %s", rec.Code, rec.Body)
+	}
+
+	rec = loginMFA(challenge.MFAToken, totpCode(t, secret, time.Now()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("right code = %d: %s", rec.Code, rec.Body)
+	}
+	var session struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decodeJSON(t, rec, &session)
+	if claims, err := auth.ValidateJWT(session.Token, cfg.jwtKeys); err != nil || claims.UserID != alice.ID {
+		t.Errorf("access token after MFA = %+v, %v; want one for %s", claims, err, alice.ID)
+	}
+	if session.RefreshToken == "" {
+		t.Error("no refresh token after MFA")
+	}
+
+	for range maxMFAFailures {
+		loginMFA(challenge.MFAToken, "000000")
+	}
+	if rec := loginMFA(challenge.MFAToken, totpCode(t, secret, time.Now().Add(30*time.Second))); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("right code while locked = %d, want 429: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTOTPEnrollment(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	token := testAccessToken(t, cfg, alice.ID)
+	account := auth.ScopeAccount
+
+	rec := serve("POST /api/mfa/totp", cfg.requireAuth(account, cfg.handlerTOTPEnroll), withBearer(postJSON("/api/mfa/totp", nil), token))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("enroll = %d: %s", rec.Code, rec.Body)
+	}
+	var enrollment struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	decodeJSON(t, rec, &enrollment)
+	if !strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/") {
+		t.Errorf("otpauth_uri = %q", enrollment.OTPAuthURI)
+	}
+	if totp, _ := cfg.db.GetTOTP(alice.ID); totp.Enabled() {
+		t.Error("two-factor authentication is on before it was confirmed")
+	}
+
+	confirm := func(code string) *httptest.ResponseRecorder {
+		return serve("POST /api/mfa/totp/confirm", cfg.requireAuth(account, cfg.handlerTOTPConfirm),
+			withBearer(postJSON("/api/mfa/totp/confirm", map[string]string{"code": code}), token))
+	}
+	if rec := confirm("000000"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("confirm with a wrong code = %d, want 422: %s", rec.Code, rec.Body)
+	}
+	code := totpCode(t, enrollment.Secret, time.Now())
+	rec = confirm(code)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("confirm = %d: %s", rec.Code, rec.Body)
+	}
+	var codes recoveryCodesResponse
+	decodeJSON(t, rec, &codes)
+	if len(codes.RecoveryCodes) != recoveryCodeCount {
+		t.Errorf("got %d recovery codes, want %d", len(codes.RecoveryCodes), recoveryCodeCount)
+	}
+
+	totp, err := cfg.db.GetTOTP(alice.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if !totp.Enabled() || totp.RecoveryCodesLeft != recoveryCodeCount {
+		t.Errorf("TOTP after confirming = %+v, want it on with %d recovery codes", totp, recoveryCodeCount)
+	}
+	// The code that confirmed enrollment can't log in as well.
+	if err := verify(t, cfg, alice.ID, secondFactorParams{Code: code}); !errors.Is(err, errMFACodeInvalid) {
+		t.Errorf("confirmation code at login: verifySecondFactor = %v, want errMFACodeInvalid", err)
+	}
+	if err := verify(t, cfg, alice.ID, secondFactorParams{RecoveryCode: codes.RecoveryCodes[0]}); err != nil {
+		t.Errorf("new recovery code: verifySecondFactor = %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/google/uuid"
+)
+
+const (
+	oidcLoginCookie = "tubely_oidc_login"
+	// oidcLoginLifetime is how long a user has to log in at the identity
+	// provider and come back.
+	oidcLoginLifetime = 10 * time.Minute
+	// oidcLoginCodeLifetime is how long the web app has to trade the code
+	// it's redirected with for tokens.
+	oidcLoginCodeLifetime = time.Minute
+)
+
+var (
+	errOIDCNoVerifiedEmail = errors.New("identity provider didn't share a verified email address")
+	// errOIDCAccountUnverified means the email address belongs to a user
+	// who never verified it. Whoever signed up with it may not own it, so
+	// the account isn't handed to the provider's user either.
+	errOIDCAccountUnverified = errors.New("existing account's email address isn't verified")
+)
+
+// handlerOIDCLogin sends the user to the identity provider to log in. What's
+// needed to finish the login when they come back is kept in a cookie.
+func (cfg *apiConfig) handlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	var login auth.OIDCLogin
+	for _, s := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		var err error
+		if *s, err = oidc.RandomString(); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+			return
+		}
+	}
+
+	authURL, err := cfg.oidc.AuthCodeURL(r.Context(), login.State, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't reach the identity provider", err)
+		return
+	}
+	token, err := auth.MakeOIDCLoginToken(login, cfg.jwtKeys, oidcLoginLifetime)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't start login", err)
+		return
+	}
+
+	cfg.setOIDCLoginCookie(w, token, oidcLoginLifetime)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// setOIDCLoginCookie stores token for the callback, or clears it when
+// maxAge is zero. It's sent back on the provider's redirect, a top-level
+// navigation, so SameSite=Lax is as strict as it can be.
+func (cfg *apiConfig) setOIDCLoginCookie(w http.ResponseWriter, token string, maxAge time.Duration) {
+	cookie := &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    token,
+		Path:     "/api/oidc/",
+		MaxAge:   int(maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.oidc.RedirectURL(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge == 0 {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
+
+// handlerOIDCCallback is where the identity provider sends the user back.
+// It checks the login is the one this browser started and verifies the ID
+// token. Rather than answer the browser's navigation with tokens, it sends
+// the user on to the web app with a single-use code for
+// handlerOIDCToken.
+func (cfg *apiConfig) handlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	query := r.URL.Query()
+
+	cookie, err := r.Cookie(oidcLoginCookie)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login expired or was started in another browser; try again", err)
+		return
+	}
+	login, err := auth.ValidateOIDCLoginToken(cookie.Value, cfg.jwtKeys)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Login expired or was started in another browser; try again", err)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(login.State)) != 1 {
+		respondWithError(w, http.StatusBadRequest, "Login expired or was started in another browser; try again", nil)
+		return
+	}
+	cfg.setOIDCLoginCookie(w, "", 0)
+
+	if errCode := query.Get("error"); errCode != "" {
+		respondWithError(w, http.StatusUnauthorized, "The identity provider didn't log you in", errors.New(errCode+": "+query.Get("error_description")))
+		return
+	}
+
+	rawIDToken, err := cfg.oidc.Exchange(r.Context(), query.Get("code"), login.CodeVerifier)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, "Couldn't complete login with the identity provider", err)
+		return
+	}
+	idToken, err := cfg.oidc.VerifyIDToken(r.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "The identity provider's ID token is invalid", err)
+		return
+	}
+
+	user, err := cfg.getOIDCUser(idToken)
+	if errors.Is(err, errOIDCNoVerifiedEmail) {
+		respondWithError(w, http.StatusForbidden, "Your identity provider account has no verified email address", err)
+		return
+	}
+	if errors.Is(err, errOIDCAccountUnverified) {
+		respondWithError(w, http.StatusConflict, "An account with your email address exists but the address was never verified; reset its password from the email we send you, then log in again", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	code, err := auth.MakeLoginCode()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create login code", err)
+		return
+	}
+	err = cfg.db.CreateLoginCode(database.CreateLoginCodeParams{
+		UserID:    user.ID,
+		CodeHash:  auth.HashLoginCode(code),
+		ExpiresAt: time.Now().UTC().Add(oidcLoginCodeLifetime),
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save login code", err)
+		return
+	}
+
+	// The code goes in the fragment, which browsers don't send to servers
+	// or leak in the Referer header.
+	http.Redirect(w, r, cfg.appURL+"#"+url.Values{"oidc_code": {code}}.Encode(), http.StatusFound)
+}
+
+// handlerOIDCToken trades the code from handlerOIDCCallback's redirect for
+// a login, answering as handlerLogin does. Each code works once.
+func (cfg *apiConfig) handlerOIDCToken(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	userID, err := cfg.db.UseLoginCode(auth.HashLoginCode(params.Code))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check login code", err)
+		return
+	}
+	if userID == uuid.Nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login code; log in again", nil)
+		return
+	}
+	user, err := cfg.db.GetUser(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Invalid or expired login code; log in again", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, *user)
+}
+
+// getOIDCUser returns the user the identity provider account is linked to.
+// An account that isn't linked yet is linked to the user with the same
+// email address, or to a new user, but only if the provider vouches for
+// the address. An existing user must have verified the address too;
+// otherwise whoever signed up with it could log in to the account the
+// provider's user ends up in.
+func (cfg *apiConfig) getOIDCUser(idToken oidc.IDToken) (database.User, error) {
+	issuer := cfg.oidc.Issuer()
+	linked, err := cfg.db.GetUserByIdentity(issuer, idToken.Subject)
+	if err != nil {
+		return database.User{}, err
+	}
+	if linked != nil {
+		return *linked, nil
+	}
+	if idToken.Email == "" || !idToken.EmailVerified {
+		return database.User{}, errOIDCNoVerifiedEmail
+	}
+
+	user, err := cfg.db.GetUserByEmail(idToken.Email)
+	if err != nil {
+		return database.User{}, err
+	}
+	if user.ID != uuid.Nil && user.EmailVerifiedAt == nil {
+		return database.User{}, errOIDCAccountUnverified
+	}
+	if user.ID == uuid.Nil {
+		// Users from single sign-on have no password. They can set one
+		// with a password reset if they want to log in without it.
+		created, err := cfg.db.CreateUser(database.CreateUserParams{Email: idToken.Email})
+		if err != nil {
+			return database.User{}, err
+		}
+		user = *created
+		log.Printf("Created user %s for %s account %s", user.ID, issuer, idToken.Subject)
+	}
+
+	if err := cfg.db.LinkIdentity(user.ID, issuer, idToken.Subject); err != nil {
+		return database.User{}, err
+	}
+	if err := cfg.db.SetUserEmailVerified(user.ID); err != nil {
+		return database.User{}, err
+	}
+	verified, err := cfg.db.GetUser(user.ID)
+	if err != nil {
+		return database.User{}, err
+	}
+	if verified == nil {
+		return database.User{}, errors.New("user was deleted while logging in")
+	}
+	return *verified, nil
+}
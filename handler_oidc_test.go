@@ -0,0 +1,311 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+	"github.com/google/uuid"
+)
+
+func newOIDCTestConfig(t *testing.T) (*apiConfig, *oidctest.Server) {
+	t.Helper()
+	srv := oidctest.NewServer("tubely", "s3cret")
+	t.Cleanup(srv.Close)
+	cfg := newTestConfig(t)
+	cfg.oidc = oidc.NewProvider(oidc.Config{
+		Issuer:       srv.URL,
+		ClientID:     "tubely",
+		ClientSecret: "s3cret",
+		RedirectURL:  "http://localhost:8091/api/oidc/callback",
+	})
+	return cfg, srv
+}
+
+// startOIDCLogin starts a login and follows the provider's login page. It
+// returns the request the provider sends the browser back with, carrying
+// the login cookie.
+func startOIDCLogin(t *testing.T, cfg *apiConfig) *http.Request {
+	t.Helper()
+	rec := serve("GET /api/oidc/login", http.HandlerFunc(cfg.handlerOIDCLogin), httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login = %d: %s", rec.Code, rec.Body)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("GET authorize: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %s", resp.Status)
+	}
+
+	callback := httptest.NewRequest(http.MethodGet, resp.Header.Get("Location"), nil)
+	for _, c := range rec.Result().Cookies() {
+		callback.AddCookie(c)
+	}
+	return callback
+}
+
+func oidcCallback(cfg *apiConfig, req *http.Request) *httptest.ResponseRecorder {
+	return serve("GET /api/oidc/callback", http.HandlerFunc(cfg.handlerOIDCCallback), req)
+}
+
+// oidcLoginCode runs a whole login and returns the code the web app is
+// sent back with.
+func oidcLoginCode(t *testing.T, cfg *apiConfig) string {
+	t.Helper()
+	rec := oidcCallback(cfg, startOIDCLogin(t, cfg))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback = %d: %s", rec.Code, rec.Body)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+	if location.Scheme+"://"+location.Host+location.Path != cfg.appURL || location.RawQuery != "" {
+		t.Errorf("callback redirected to %s, want the app at %s", location, cfg.appURL)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil || fragment.Get("oidc_code") == "" {
+		t.Fatalf("redirect %s has no oidc_code", location)
+	}
+	return fragment.Get("oidc_code")
+}
+
+func oidcToken(cfg *apiConfig, code string) *httptest.ResponseRecorder {
+	return serve("POST /api/oidc/token", http.HandlerFunc(cfg.handlerOIDCToken), postJSON("/api/oidc/token", map[string]string{"code": code}))
+}
+
+// oidcLogin runs a whole login and returns the user it logged in.
+func oidcLogin(t *testing.T, cfg *apiConfig) uuid.UUID {
+	t.Helper()
+	rec := oidcToken(cfg, oidcLoginCode(t, cfg))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("token = %d: %s", rec.Code, rec.Body)
+	}
+	var session struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decodeJSON(t, rec, &session)
+	claims, err := auth.ValidateJWT(session.Token, cfg.jwtKeys)
+	if err != nil {
+		t.Fatalf("access token: %v", err)
+	}
+	if session.RefreshToken == "" {
+		t.Error("no refresh token")
+	}
+	return claims.UserID
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	cfg, srv := newOIDCTestConfig(t)
+	srv.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+
+	userID := oidcLogin(t, cfg)
+	user, err := cfg.db.GetUser(userID)
+	if err != nil || user == nil {
+		t.Fatalf("GetUser = %v, %v", user, err)
+	}
+	if user.Email != "alice@example.com" || user.Password != "" || user.EmailVerifiedAt == nil {
+		t.Errorf("created user = %+v, want a verified alice@example.com without a password", user)
+	}
+	if linked, _ := cfg.db.GetUserByIdentity(srv.URL, "alice-sub"); linked == nil || linked.ID != userID {
+		t.Errorf("identity is linked to %v, want %s", linked, userID)
+	}
+
+	if again := oidcLogin(t, cfg); again != userID {
+		t.Errorf("second login is user %s, want %s", again, userID)
+	}
+}
+
+func TestOIDCLoginLinksByVerifiedEmail(t *testing.T) {
+	cfg, srv := newOIDCTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	if err := cfg.db.SetUserEmailVerified(alice.ID); err != nil {
+		t.Fatalf("SetUserEmailVerified: %v", err)
+	}
+	srv.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+
+	if got := oidcLogin(t, cfg); got != alice.ID {
+		t.Fatalf("login is user %s, want existing user %s", got, alice.ID)
+	}
+	user, _ := cfg.db.GetUser(alice.ID)
+	if user.Password != alice.Password {
+		t.Error("linking changed the password")
+	}
+
+	// Once linked, the account is known by its subject, whatever its
+	// email address becomes.
+	srv.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@elsewhere.example", EmailVerified: false})
+	if got := oidcLogin(t, cfg); got != alice.ID {
+		t.Errorf("login after the email changed is user %s, want %s", got, alice.ID)
+	}
+}
+
+// Someone may sign up with another person's address before that person
+// first logs in with single sign-on. Their account isn't linked, as its
+// password is theirs.
+func TestOIDCLoginRefusesUnverifiedAccount(t *testing.T) {
+	cfg, srv := newOIDCTestConfig(t)
+	squatter := createTestUser(t, cfg, "alice@example.com")
+	srv.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+
+	rec := oidcCallback(cfg, startOIDCLogin(t, cfg))
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("callback = %d, want 409: %s", rec.Code, rec.Body)
+	}
+	if linked, _ := cfg.db.GetUserByIdentity(srv.URL, "alice-sub"); linked != nil {
+		t.Errorf("identity was linked to %s", linked.ID)
+	}
+	if user, _ := cfg.db.GetUser(squatter.ID); user.EmailVerifiedAt != nil {
+		t.Error("refused login verified the account's email address")
+	}
+
+	// Once the address's owner has proved it, as a password reset does,
+	// the account is theirs to link.
+	if err := cfg.db.SetUserEmailVerified(squatter.ID); err != nil {
+		t.Fatalf("SetUserEmailVerified: %v", err)
+	}
+	if got := oidcLogin(t, cfg); got != squatter.ID {
+		t.Errorf("login after verifying is user %s, want %s", got, squatter.ID)
+	}
+}
+
+func TestOIDCLoginRefusesUnverifiedEmail(t *testing.T) {
+	cfg, srv := newOIDCTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+
+	for name, user := range map[string]oidctest.User{
+		"unverified email": {Subject: "mallory-sub", Email: "alice@example.com", EmailVerified: false},
+		"no email":         {Subject: "nobody-sub"},
+	} {
+		srv.SetUser(user)
+		rec := oidcCallback(cfg, startOIDCLogin(t, cfg))
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("%s: callback = %d, want 403: %s", name, rec.Code, rec.Body)
+		}
+		if linked, _ := cfg.db.GetUserByIdentity(srv.URL, user.Subject); linked != nil {
+			t.Errorf("%s: identity was linked to %s", name, linked.ID)
+		}
+	}
+	if users, _ := cfg.db.GetUsers(); len(users) != 1 || users[0].ID != alice.ID {
+		t.Errorf("users = %+v, want only alice", users)
+	}
+}
+
+func TestOIDCLoginState(t *testing.T) {
+	cfg, srv := newOIDCTestConfig(t)
+	srv.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+
+	withoutCookie := startOIDCLogin(t, cfg)
+	withoutCookie.Header.Del("Cookie")
+
+	wrongState := startOIDCLogin(t, cfg)
+	query := wrongState.URL.Query()
+	query.Set("state", "forged")
+	wrongState.URL.RawQuery = query.Encode()
+
+	// A cookie from another login in the same browser doesn't match
+	// this login's state.
+	otherLogin, thisLogin := startOIDCLogin(t, cfg), startOIDCLogin(t, cfg)
+	otherLogin.URL.RawQuery = thisLogin.URL.RawQuery
+
+	forgedCookie := startOIDCLogin(t, cfg)
+	forgedToken, err := auth.MakeOIDCLoginToken(auth.OIDCLogin{State: forgedCookie.URL.Query().Get("state")}, auth.NewSecretKeySet("another-secret"), time.Minute)
+	if err != nil {
+		t.Fatalf("MakeOIDCLoginToken: %v", err)
+	}
+	forgedCookie.Header.Del("Cookie")
+	forgedCookie.AddCookie(&http.Cookie{Name: oidcLoginCookie, Value: forgedToken})
+
+	for name, req := range map[string]*http.Request{
+		"no login cookie":                withoutCookie,
+		"state that isn't the cookie's":  wrongState,
+		"another login's cookie":         otherLogin,
+		"cookie signed with another key": forgedCookie,
+	} {
+		if rec := oidcCallback(cfg, req); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: callback = %d, want 400: %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	rec := oidcCallback(cfg, startOIDCLogin(t, cfg))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback = %d: %s", rec.Code, rec.Body)
+	}
+	cleared := false
+	for _, c := range rec.Result().Cookies() {
+		cleared = cleared || (c.Name == oidcLoginCookie && c.MaxAge < 0)
+	}
+	if !cleared {
+		t.Error("callback didn't clear the login cookie")
+	}
+}
+
+func TestOIDCToken(t *testing.T) {
+	cfg, srv := newOIDCTestConfig(t)
+	srv.SetUser(oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true})
+
+	code := oidcLoginCode(t, cfg)
+	if rec := oidcToken(cfg, code); rec.Code != http.StatusOK {
+		t.Fatalf("token = %d: %s", rec.Code, rec.Body)
+	}
+	if rec := oidcToken(cfg, code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("token with a used code = %d, want 401: %s", rec.Code, rec.Body)
+	}
+	if rec := oidcToken(cfg, "made-up"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("token with a made-up code = %d, want 401: %s", rec.Code, rec.Body)
+	}
+
+	user, _ := cfg.db.GetUserByEmail("alice@example.com")
+	expired := "expired-code"
+	err := cfg.db.CreateLoginCode(database.CreateLoginCodeParams{
+		UserID:    user.ID,
+		CodeHash:  auth.HashLoginCode(expired),
+		ExpiresAt: time.Now().Add(-time.Second),
+	})
+	if err != nil {
+		t.Fatalf("CreateLoginCode: %v", err)
+	}
+	if rec := oidcToken(cfg, expired); rec.Code != http.StatusUnauthorized {
+		t.Errorf("token with an expired code = %d, want 401: %s", rec.Code, rec.Body)
+	}
+
+	// Users with two-factor authentication get the same challenge as
+	// from a password login.
+	enableTOTP(t, cfg, user.ID)
+	rec := oidcToken(cfg, oidcLoginCode(t, cfg))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("token with MFA = %d: %s", rec.Code, rec.Body)
+	}
+	var challenge struct {
+		mfaChallengeResponse
+		Token string `json:"token"`
+	}
+	decodeJSON(t, rec, &challenge)
+	if !challenge.MFARequired || challenge.MFAToken == "" || challenge.Token != "" {
+		t.Errorf("token with MFA = %s, want an MFA challenge without tokens", rec.Body)
+	}
+
+	// A user disabled between the callback and the exchange isn't let in.
+	code = oidcLoginCode(t, cfg)
+	if err := cfg.db.SetUserDisabled(user.ID, true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	if rec := oidcToken(cfg, code); rec.Code != http.StatusForbidden {
+		t.Errorf("token for a disabled user = %d, want 403: %s", rec.Code, rec.Body)
+	}
+	if rec := oidcCallback(cfg, startOIDCLogin(t, cfg)); rec.Code != http.StatusForbidden {
+		t.Errorf("callback for a disabled user = %d, want 403: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+type playlistResponse struct {
+	database.Playlist
+	Items []database.PlaylistItem `json:"items"`
+}
+
+// playQueueEntry is one playable video in a playlist's play queue.
+type playQueueEntry struct {
+	Position     int       `json:"position"`
+	VideoID      uuid.UUID `json:"video_id"`
+	Title        string    `json:"title"`
+	VideoURL     string    `json:"video_url"`
+	ThumbnailURL *string   `json:"thumbnail_url"`
+	Duration     float64   `json:"duration"`
+}
+
+type playQueue struct {
+	PlaylistID    uuid.UUID        `json:"playlist_id"`
+	Title         string           `json:"title"`
+	TotalDuration float64          `json:"total_duration"`
+	Items         []playQueueEntry `json:"items"`
+}
+
+func validatePlaylistTitle(title string) error {
+	if strings.TrimSpace(title) == "" {
+		return errors.New("title is required")
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return fmt.Errorf("title can't be longer than %d characters", maxTitleLength)
+	}
+	return nil
+}
+
+// visiblePlaylistItems drops the items whose videos viewerID isn't allowed
+// to see.
+func (cfg *apiConfig) visiblePlaylistItems(playlistID, viewerID uuid.UUID) ([]database.PlaylistItem, error) {
+	items, err := cfg.db.GetPlaylistItems(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	visible := []database.PlaylistItem{}
+	for _, item := range items {
+		if item.Video.VisibleTo(viewerID) {
+			visible = append(visible, item)
+		}
+	}
+	return visible, nil
+}
+
+// getOwnedPlaylist loads the playlist named in the path for a request that
+// has to come from its owner. It responds with the error itself and
+// returns false if it can't.
+func (cfg *apiConfig) getOwnedPlaylist(w http.ResponseWriter, r *http.Request) (database.Playlist, bool) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return database.Playlist{}, false
+	}
+
+	userID := requestUserID(r)
+
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return database.Playlist{}, false
+	}
+	if playlist.ID == uuid.Nil || !playlist.VisibleTo(userID) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return database.Playlist{}, false
+	}
+	if playlist.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't change this playlist", nil)
+		return database.Playlist{}, false
+	}
+	return playlist, true
+}
+
+// respondWithPlaylist sends a playlist with the items its owner can see.
+func (cfg *apiConfig) respondWithPlaylist(w http.ResponseWriter, code int, playlistID uuid.UUID) {
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	items, err := cfg.visiblePlaylistItems(playlist.ID, playlist.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist items", err)
+		return
+	}
+	respondWithJSON(w, code, playlistResponse{Playlist: playlist, Items: items})
+}
+
+func (cfg *apiConfig) handlerPlaylistCreate(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	var params struct {
+		Title      string              `json:"title"`
+		Visibility database.Visibility `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validatePlaylistTitle(params.Title); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+	if params.Visibility != "" && !params.Visibility.Valid() {
+		respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+		return
+	}
+
+	playlist, err := cfg.db.CreatePlaylist(database.CreatePlaylistParams{
+		UserID:     userID,
+		Title:      params.Title,
+		Visibility: params.Visibility,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create playlist", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, playlistResponse{Playlist: playlist, Items: []database.PlaylistItem{}})
+}
+
+func (cfg *apiConfig) handlerPlaylistsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	playlists, err := cfg.db.ListPlaylists(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve playlists", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Items []database.Playlist `json:"items"`
+	}{
+		Items: playlists,
+	})
+}
+
+func (cfg *apiConfig) handlerPlaylistGet(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return
+	}
+
+	viewerID := requestUserID(r)
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	if playlist.ID == uuid.Nil || !playlist.VisibleTo(viewerID) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+
+	items, err := cfg.visiblePlaylistItems(playlist.ID, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist items", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, playlistResponse{Playlist: playlist, Items: items})
+}
+
+// handlerPlaylistQueue returns the playable videos of a playlist in order,
+// for the app to autoplay through.
+func (cfg *apiConfig) handlerPlaylistQueue(w http.ResponseWriter, r *http.Request) {
+	playlistID, err := uuid.Parse(r.PathValue("playlistID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid playlist ID", err)
+		return
+	}
+
+	viewerID := requestUserID(r)
+	playlist, err := cfg.db.GetPlaylist(playlistID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist", err)
+		return
+	}
+	if playlist.ID == uuid.Nil || !playlist.VisibleTo(viewerID) {
+		respondWithError(w, http.StatusNotFound, "Playlist not found", nil)
+		return
+	}
+
+	items, err := cfg.visiblePlaylistItems(playlist.ID, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get playlist items", err)
+		return
+	}
+
+	queue := playQueue{
+		PlaylistID: playlist.ID,
+		Title:      playlist.Title,
+		Items:      []playQueueEntry{},
+	}
+	for _, item := range items {
+		// Drafts without an uploaded file have nothing to play.
+		if item.Video.VideoURL == nil {
+			continue
+		}
+		queue.Items = append(queue.Items, playQueueEntry{
+			Position:     item.Position,
+			VideoID:      item.Video.ID,
+			Title:        item.Video.Title,
+			VideoURL:     *item.Video.VideoURL,
+			ThumbnailURL: item.Video.ThumbnailURL,
+			Duration:     item.Video.Duration,
+		})
+		queue.TotalDuration += item.Video.Duration
+	}
+
+	respondWithJSON(w, http.StatusOK, queue)
+}
+
+func (cfg *apiConfig) handlerPlaylistUpdate(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		Title      *string              `json:"title"`
+		Visibility *database.Visibility `json:"visibility"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Title != nil {
+		if err := validatePlaylistTitle(*params.Title); err != nil {
+			respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+			return
+		}
+		playlist.Title = *params.Title
+	}
+	if params.Visibility != nil {
+		if !params.Visibility.Valid() {
+			respondWithError(w, http.StatusBadRequest, "Visibility must be private, unlisted or public", nil)
+			return
+		}
+		playlist.Visibility = *params.Visibility
+	}
+
+	if err := cfg.db.UpdatePlaylist(playlist); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update playlist", err)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, http.StatusOK, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistDelete(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DeletePlaylist(playlist.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete playlist", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerPlaylistItemAdd(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		VideoID  uuid.UUID `json:"video_id"`
+		Position *int      `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	position := -1
+	if params.Position != nil {
+		position = *params.Position
+	}
+
+	// Anything the owner can watch can go in their playlist; other
+	// viewers only get the videos they can see themselves.
+	video, err := cfg.db.GetVideo(params.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || !video.VisibleTo(playlist.UserID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	err = cfg.db.AddPlaylistItem(playlist.ID, video.ID, position)
+	if errors.Is(err, database.ErrPlaylistItemExists) {
+		respondWithError(w, http.StatusConflict, "Video is already in the playlist", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't add video to playlist", err)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, http.StatusOK, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistItemMove(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	var params struct {
+		Position *int `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Position == nil || *params.Position < 0 {
+		respondWithError(w, http.StatusUnprocessableEntity, "position must be a non-negative integer", nil)
+		return
+	}
+
+	err = cfg.db.MovePlaylistItem(playlist.ID, videoID, *params.Position)
+	if errors.Is(err, database.ErrPlaylistItemNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video is not in the playlist", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't move video", err)
+		return
+	}
+
+	cfg.respondWithPlaylist(w, http.StatusOK, playlist.ID)
+}
+
+func (cfg *apiConfig) handlerPlaylistItemRemove(w http.ResponseWriter, r *http.Request) {
+	playlist, ok := cfg.getOwnedPlaylist(w, r)
+	if !ok {
+		return
+	}
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	err = cfg.db.RemovePlaylistItem(playlist.ID, videoID)
+	if errors.Is(err, database.ErrPlaylistItemNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video is not in the playlist", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove video", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 500
+	maxAvatarSize        = 5 << 20
+)
+
+// Handles are checked after database.NormalizeHandle, so they're already in
+// lower case.
+var handlePattern = regexp.MustCompile(`^[a-z0-9_]{3,30}$`)
+
+type channelResponse struct {
+	Channel     database.Profile  `json:"channel"`
+	Subscribers int               `json:"subscribers"`
+	Subscribed  bool              `json:"subscribed"`
+	Videos      videoPageResponse `json:"videos"`
+}
+
+func validateProfileFields(handle, displayName, bio string) error {
+	if !handlePattern.MatchString(database.NormalizeHandle(handle)) {
+		return errors.New("handle must be 3 to 30 letters, digits or underscores")
+	}
+	if utf8.RuneCountInString(displayName) > maxDisplayNameLength {
+		return fmt.Errorf("display name can't be longer than %d characters", maxDisplayNameLength)
+	}
+	if utf8.RuneCountInString(bio) > maxBioLength {
+		return fmt.Errorf("bio can't be longer than %d characters", maxBioLength)
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerProfileGet(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	profile, err := cfg.db.GetProfile(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	if profile.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Profile not found", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+func (cfg *apiConfig) handlerProfileUpdate(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	var params struct {
+		Handle      string `json:"handle"`
+		DisplayName string `json:"display_name"`
+		Bio         string `json:"bio"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateProfileFields(params.Handle, params.DisplayName, params.Bio); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+
+	profile, err := cfg.db.GetProfile(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	profile.UserID = userID
+	profile.Handle = params.Handle
+	profile.DisplayName = params.DisplayName
+	profile.Bio = params.Bio
+
+	profile, err = cfg.db.SaveProfile(profile)
+	if errors.Is(err, database.ErrHandleTaken) {
+		respondWithError(w, http.StatusConflict, "That handle is already taken", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save profile", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+func (cfg *apiConfig) handlerProfileAvatarUpload(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	profile, err := cfg.db.GetProfile(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get profile", err)
+		return
+	}
+	if profile.UserID == uuid.Nil {
+		respondWithError(w, http.StatusConflict, "Choose a handle before uploading an avatar", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize)
+	file, header, err := r.FormFile("avatar")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't get avatar", err)
+		return
+	}
+	defer file.Close()
+
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "image/jpeg" && mediaType != "image/png") {
+		respondWithError(w, http.StatusBadRequest, "Avatar must be a JPEG or PNG image", err)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't read avatar", err)
+		return
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save avatar", err)
+		return
+	}
+	key := fmt.Sprintf("%s%s.%s", avatarKeyPrefix, base64.RawURLEncoding.EncodeToString(randomBytes), getExtensionFromMediaType(mediaType))
+	err = cfg.thumbnailStorage.Put(r.Context(), key, bytes.NewReader(data), mediaType)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save avatar", err)
+		return
+	}
+
+	oldAvatarURL := profile.AvatarURL
+	avatarURL := cfg.thumbnailStorage.URL(key)
+	profile.AvatarURL = &avatarURL
+	profile, err = cfg.db.SaveProfile(profile)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save profile", err)
+		return
+	}
+
+	// Avatars aren't tied to a video, so they don't go through the
+	// storage deletion queue. A leftover file is harmless.
+	if oldAvatarURL != nil {
+		if oldKey, ok := cfg.thumbnailStorage.KeyForURL(*oldAvatarURL); ok {
+			if err := cfg.thumbnailStorage.Delete(r.Context(), oldKey); err != nil {
+				log.Printf("Couldn't remove old avatar %s: %v", oldKey, err)
+			}
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, profile)
+}
+
+// handlerChannelGet shows a channel's profile and the first page of its
+// public videos. Later pages are fetched with ?cursor like any listing.
+func (cfg *apiConfig) handlerChannelGet(w http.ResponseWriter, r *http.Request) {
+	profile, ok := cfg.getChannel(w, r)
+	if !ok {
+		return
+	}
+
+	params, err := parseListVideosParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.UserID = profile.UserID
+	params.Visibility = database.VisibilityPublic
+
+	page, err := cfg.db.ListVideos(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
+		return
+	}
+
+	viewerID := requestUserID(r)
+	items, err := cfg.withReactions(page.Items, viewerID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve reactions", err)
+		return
+	}
+
+	subscribers, err := cfg.db.CountSubscribers(profile.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count subscribers", err)
+		return
+	}
+	subscribed := false
+	if viewerID != uuid.Nil {
+		subscribed, err = cfg.db.IsSubscribed(viewerID, profile.UserID)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't get subscription", err)
+			return
+		}
+	}
+
+	respondWithJSON(w, http.StatusOK, channelResponse{
+		Channel:     profile,
+		Subscribers: subscribers,
+		Subscribed:  subscribed,
+		Videos:      videoPageResponse{Items: items, NextCursor: page.NextCursor},
+	})
+}
+
+// getChannel looks up the profile named by the {handle} path value. It
+// responds with the error itself and returns false if there isn't one.
+func (cfg *apiConfig) getChannel(w http.ResponseWriter, r *http.Request) (database.Profile, bool) {
+	profile, err := cfg.db.GetProfileByHandle(r.PathValue("handle"))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get channel", err)
+		return database.Profile{}, false
+	}
+	if profile.UserID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Channel not found", nil)
+		return database.Profile{}, false
+	}
+	return profile, true
+}
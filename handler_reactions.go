@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoResponse is a video as returned to a viewer, with the reactions to
+// it.
+type videoResponse struct {
+	database.Video
+	Reactions database.ReactionSummary `json:"reactions"`
+}
+
+type videoPageResponse struct {
+	Items      []videoResponse `json:"items"`
+	NextCursor *string         `json:"next_cursor"`
+}
+
+// withReactions adds reaction summaries to videos, as seen by viewerID,
+// with a single query.
+func (cfg *apiConfig) withReactions(videos []database.Video, viewerID uuid.UUID) ([]videoResponse, error) {
+	ids := make([]uuid.UUID, len(videos))
+	for i, video := range videos {
+		ids[i] = video.ID
+	}
+	summaries, err := cfg.db.GetReactionSummaries(ids, viewerID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]videoResponse, len(videos))
+	for i, video := range videos {
+		resp[i] = videoResponse{Video: video, Reactions: summaries[video.ID]}
+	}
+	return resp, nil
+}
+
+func (cfg *apiConfig) respondWithReactionSummary(w http.ResponseWriter, videoID, userID uuid.UUID) {
+	summaries, err := cfg.db.GetReactionSummaries([]uuid.UUID{videoID}, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get reactions", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, summaries[videoID])
+}
+
+// handlerReactionSet records the caller's reaction to a video. Repeating it
+// changes nothing, and a different reaction replaces the earlier one.
+func (cfg *apiConfig) handlerReactionSet(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		Reaction database.Reaction `json:"reaction"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if !params.Reaction.Valid() {
+		respondWithError(w, http.StatusUnprocessableEntity, "reaction must be like or dislike", nil)
+		return
+	}
+
+	if err := cfg.db.SetReaction(userID, video.ID, params.Reaction); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save reaction", err)
+		return
+	}
+
+	cfg.respondWithReactionSummary(w, video.ID, userID)
+}
+
+func (cfg *apiConfig) handlerReactionDelete(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if err := cfg.db.DeleteReaction(userID, video.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't remove reaction", err)
+		return
+	}
+
+	cfg.respondWithReactionSummary(w, video.ID, userID)
+}
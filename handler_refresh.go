@@ -1,15 +1,31 @@
 package main
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 )
 
+// accessTokenLifetime is how long an access token lasts. Access tokens
+// aren't checked against their session, so this is how long one keeps
+// working after the session is revoked or the password reset.
+const accessTokenLifetime = time.Hour
+
+// refreshTokenLifetime is how long a refresh token lasts unused. Each
+// refresh replaces it with a new one that lasts as long again.
+const refreshTokenLifetime = 60 * 24 * time.Hour
+
+// handlerRefresh trades a refresh token for a new access token and a new
+// refresh token. The old refresh token stops working, and presenting it
+// again logs out every session descended from the same login.
 func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(r.Header)
@@ -18,24 +34,55 @@ func (cfg *apiConfig) handlerRefresh(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := cfg.db.GetUserByRefreshToken(refreshToken)
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create refresh token", err)
+		return
+	}
+
+	rt, err := cfg.db.RotateRefreshToken(refreshToken, newRefreshToken, time.Now().UTC().Add(refreshTokenLifetime), sessionClient(r))
+	if errors.Is(err, database.ErrRefreshTokenReused) {
+		log.Printf("Refresh token reused; revoked its family")
+		respondWithError(w, http.StatusUnauthorized, "Refresh token was already used; log in again", err)
+		return
+	}
+	if errors.Is(err, database.ErrRefreshTokenInvalid) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid refresh token", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't refresh session", err)
+		return
+	}
+
+	user, err := cfg.db.GetUser(rt.UserID)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get user for refresh token", err)
+		return
+	}
+	if user == nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't get user for refresh token", nil)
+		return
+	}
+	if user.DisabledAt != nil {
+		respondWithError(w, http.StatusForbidden, "Account is disabled", nil)
 		return
 	}
 
 	accessToken, err := auth.MakeJWT(
 		user.ID,
-		cfg.jwtSecret,
-		time.Hour,
+		string(user.Role),
+		cfg.jwtKeys,
+		accessTokenLifetime,
 	)
 	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate token", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create access JWT", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, response{
-		Token: accessToken,
+		Token:        accessToken,
+		RefreshToken: rt.Token,
 	})
 }
 
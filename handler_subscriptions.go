@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+type subscriptionResponse struct {
+	Subscribed  bool `json:"subscribed"`
+	Subscribers int  `json:"subscribers"`
+}
+
+func (cfg *apiConfig) handlerSubscribe(w http.ResponseWriter, r *http.Request) {
+	cfg.updateSubscription(w, r, true)
+}
+
+func (cfg *apiConfig) handlerUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	cfg.updateSubscription(w, r, false)
+}
+
+// updateSubscription backs both PUT and DELETE on a channel's
+// subscription. Either can be repeated safely.
+func (cfg *apiConfig) updateSubscription(w http.ResponseWriter, r *http.Request, subscribe bool) {
+	userID := requestUserID(r)
+
+	channel, ok := cfg.getChannel(w, r)
+	if !ok {
+		return
+	}
+	if channel.UserID == userID {
+		respondWithError(w, http.StatusUnprocessableEntity, "You can't subscribe to your own channel", nil)
+		return
+	}
+
+	var err error
+	if subscribe {
+		err = cfg.db.Subscribe(userID, channel.UserID)
+	} else {
+		err = cfg.db.Unsubscribe(userID, channel.UserID)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update subscription", err)
+		return
+	}
+
+	subscribers, err := cfg.db.CountSubscribers(channel.UserID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't count subscribers", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, subscriptionResponse{Subscribed: subscribe, Subscribers: subscribers})
+}
+
+func (cfg *apiConfig) handlerSubscriptionsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	channels, err := cfg.db.ListSubscriptions(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve subscriptions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Items []database.Profile `json:"items"`
+	}{Items: channels})
+}
+
+// handlerFeedRetrieve lists the public videos of the channels the caller
+// subscribes to, newest first unless the query asks otherwise.
+func (cfg *apiConfig) handlerFeedRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	params, err := parseListVideosParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.SubscriberID = userID
+	params.Visibility = database.VisibilityPublic
+
+	page, err := cfg.db.ListVideos(params)
+	if errors.Is(err, database.ErrInvalidCursor) {
+		respondWithError(w, http.StatusBadRequest, "Invalid cursor", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve feed", err)
+		return
+	}
+
+	items, err := cfg.withReactions(page.Items, userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve reactions", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videoPageResponse{Items: items, NextCursor: page.NextCursor})
+}
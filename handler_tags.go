@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	maxTagsPerVideo = 20
+	maxTagLength    = 50
+)
+
+type videoTagsResponse struct {
+	Tags []string `json:"tags"`
+}
+
+func validateTags(tags []string) error {
+	if len(tags) > maxTagsPerVideo {
+		return fmt.Errorf("a video can't have more than %d tags", maxTagsPerVideo)
+	}
+	for _, tag := range tags {
+		if utf8.RuneCountInString(database.NormalizeTag(tag)) > maxTagLength {
+			return fmt.Errorf("tags can't be longer than %d characters", maxTagLength)
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) handlerVideoTagsUpdate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if err := validateTags(params.Tags); err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+
+	tags, err := cfg.db.SetVideoTags(video.ID, params.Tags)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update tags", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videoTagsResponse{Tags: tags})
+}
+
+func (cfg *apiConfig) handlerVideoTagsGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getWatchableVideo(w, r, requestUserID(r))
+	if !ok {
+		return
+	}
+
+	tags, err := cfg.db.GetVideoTags(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get tags", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videoTagsResponse{Tags: tags})
+}
+
+func (cfg *apiConfig) handlerTagsRetrieve(w http.ResponseWriter, r *http.Request) {
+	userID := requestUserID(r)
+
+	counts, err := cfg.db.ListTagCounts(userID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve tags", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, struct {
+		Items []database.TagCount `json:"items"`
+	}{
+		Items: counts,
+	})
+}
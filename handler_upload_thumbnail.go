@@ -1,36 +1,18 @@
 package main
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
 
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
-	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) saveThumbnailToFile(filePath string, thumb []byte) error {
-	// Ensure the directory exists
-	dir := filepath.Join("assets")
-	fmt.Printf("Creating directory: %s\n", dir)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
-	}
-
-	// Write the thumbnail data to file
-	if err := os.WriteFile(filepath.Join(dir, filepath.Base(filePath)), thumb, 0644); err != nil {
-		return fmt.Errorf("failed to write thumbnail file: %w", err)
-	}
-
-	return nil
-}
-
 func getExtensionFromMediaType(mediaType string) string {
 	switch mediaType {
 	case "image/jpeg":
@@ -45,28 +27,11 @@ func getExtensionFromMediaType(mediaType string) string {
 }
 
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
-
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
-
-	// TODO: implement the upload here
 	const maxMemory = 10 << 20
 	r.ParseMultipartForm(maxMemory)
 
@@ -85,19 +50,7 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
-		return
-	}
-
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not your video", nil)
-		return
-	}
-
 	mediaType := header.Header.Get("Content-Type")
-	fmt.Printf("Media type: %s\n", mediaType)
 
 	fileType, _, err := mime.ParseMediaType(mediaType)
 
@@ -106,54 +59,41 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	fmt.Printf("File type: %s\n", fileType)
 	if fileType != "image/jpeg" && fileType != "image/png" {
 		respondWithError(w, http.StatusBadRequest, "Invalid media type", err)
 		return
 	}
 
-	videoThumbnails[videoID] = thumbnail{
-		data:      thumbnailBytes,
-		mediaType: mediaType,
-	}
-
-	bytes := make([]byte, 32)
-	_, err = rand.Read(bytes)
+	randomBytes := make([]byte, 32)
+	_, err = rand.Read(randomBytes)
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Failed to save thumbnail", err)
 		return
 	}
 
-	fileName := base64.RawURLEncoding.EncodeToString(bytes)
+	fileName := base64.RawURLEncoding.EncodeToString(randomBytes)
 
-	filePath := fmt.Sprintf("/assets/%s.%s", fileName, getExtensionFromMediaType(mediaType))
-	err = cfg.saveThumbnailToFile(filePath, thumbnailBytes)
+	key := fmt.Sprintf("%s.%s", fileName, getExtensionFromMediaType(fileType))
+	err = cfg.thumbnailStorage.Put(r.Context(), key, bytes.NewReader(thumbnailBytes), fileType)
 
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't save thumbnail", err)
 		return
 	}
 
-	thumbnailURL := fmt.Sprintf("http://localhost:8091%s", filePath)
+	thumbnailURL := cfg.thumbnailStorage.URL(key)
 	video.ThumbnailURL = &thumbnailURL
 
 	err = cfg.db.UpdateVideo(video)
+	if errors.Is(err, database.ErrVideoNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, database.Video{
-		ID:           video.ID,
-		ThumbnailURL: video.ThumbnailURL,
-		VideoURL:     video.VideoURL,
-		CreatedAt:    video.CreatedAt,
-		UpdatedAt:    video.UpdatedAt,
-		CreateVideoParams: database.CreateVideoParams{
-			Title:       video.Title,
-			Description: video.Description,
-			UserID:      video.UserID,
-		},
-	})
+	respondWithJSON(w, http.StatusOK, video)
 }
@@ -1,20 +1,17 @@
 package main
 
 import (
-	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"os"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	videoUtils "github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/video"
-	"github.com/google/uuid"
 )
 
 func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
@@ -23,35 +20,8 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	defer r.Body.Close()
 	defer reader.Close()
 
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-		return
-	}
-
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
-
-	fmt.Println("uploading VIDEO", videoID, "by user", userID)
-
-	videoData, err := cfg.db.GetVideo(videoID)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
-		return
-	}
-
-	if videoData.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not authorized", nil)
+	videoData, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
 		return
 	}
 
@@ -109,7 +79,11 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	fmt.Println("Aspect ratio:", aspectRatio)
+	duration, err := videoUtils.GetDuration(processedFilePath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get duration", err)
+		return
+	}
 
 	bytes := make([]byte, 32)
 	_, err = rand.Read(bytes)
@@ -122,25 +96,21 @@ func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request)
 	fileName := base64.RawURLEncoding.EncodeToString(bytes)
 	key := fmt.Sprintf("%s/%s.mp4", aspectRatio, fileName)
 
-	_, err = cfg.s3Client.PutObject(
-		context.TODO(),
-		&s3.PutObjectInput{
-			Bucket:      aws.String(cfg.s3Bucket),
-			Key:         aws.String(key),
-			Body:        processedFile,
-			ContentType: aws.String(mediaType),
-		})
-
+	err = cfg.videoStorage.Put(r.Context(), key, processedFile, mediaType)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't upload video", err)
 		return
 	}
 
-	videoUrl := fmt.Sprintf("%s/%s", cfg.s3CfDistribution, key)
+	videoUrl := cfg.videoStorage.URL(key)
 	videoData.VideoURL = &videoUrl
+	videoData.Duration = duration
 
 	err = cfg.db.UpdateVideo(videoData)
-
+	if errors.Is(err, database.ErrVideoNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
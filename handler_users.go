@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
@@ -42,5 +43,11 @@ func (cfg *apiConfig) handlerUsersCreate(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// The account works without a verified address, so failing to send
+	// the email shouldn't fail the signup; the user can ask for another.
+	if err := cfg.sendEmailToken(*user, database.EmailTokenVerifyEmail); err != nil {
+		log.Printf("Couldn't send verification email to user %s: %v", user.ID, err)
+	}
+
 	respondWithJSON(w, http.StatusCreated, user)
 }
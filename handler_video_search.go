@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func (cfg *apiConfig) handlerVideosSearch(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Items []database.VideoSearchResult `json:"items"`
+	}
+
+	userID := requestUserID(r)
+
+	params := database.SearchVideosParams{
+		UserID: userID,
+		Query:  r.URL.Query().Get("q"),
+	}
+	for name, dest := range map[string]*int{
+		"limit":  &params.Limit,
+		"offset": &params.Offset,
+	} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			respondWithError(w, http.StatusBadRequest, name+" must be a non-negative integer", err)
+			return
+		}
+		*dest = n
+	}
+
+	results, err := cfg.db.SearchVideos(params)
+	if errors.Is(err, database.ErrEmptySearchQuery) {
+		respondWithError(w, http.StatusBadRequest, "Search query q is required", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't search videos", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		Items: results,
+	})
+}
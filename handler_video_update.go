@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 5000
+)
+
+func validateVideoFields(title, description string) error {
+	if strings.TrimSpace(title) == "" {
+		return errors.New("title is required")
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return fmt.Errorf("title can't be longer than %d characters", maxTitleLength)
+	}
+	if utf8.RuneCountInString(description) > maxDescriptionLength {
+		return fmt.Errorf("description can't be longer than %d characters", maxDescriptionLength)
+	}
+	return nil
+}
+
+// videoETag identifies a version of a video. UpdateVideo bumps updated_at
+// on every write, so it changes whenever the video does.
+func videoETag(video database.Video) string {
+	return fmt.Sprintf(`"%x"`, video.UpdatedAt.UnixMicro())
+}
+
+// etagMatches reports whether an If-Match header, a list of entity tags or
+// *, matches etag. If-Match uses the strong comparison of RFC 9110, section
+// 8.8.3.2, so weak W/ tags never match. A malformed header matches nothing.
+func etagMatches(header, etag string) bool {
+	rest := header
+	for {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			return false
+		}
+		if rest[0] == '*' {
+			return true
+		}
+		weak := strings.HasPrefix(rest, "W/")
+		if weak {
+			rest = rest[2:]
+		}
+		if rest == "" || rest[0] != '"' {
+			return false
+		}
+		end := strings.IndexByte(rest[1:], '"')
+		if end < 0 {
+			return false
+		}
+		tag := rest[:end+2]
+		rest = rest[end+2:]
+		if !weak && tag == etag {
+			return true
+		}
+	}
+}
+
+// applyVideoMergePatch applies a JSON merge patch (RFC 7396) to the editable
+// fields of video. A null description clears it; title and visibility can't
+// be removed.
+func applyVideoMergePatch(video *database.Video, patch map[string]json.RawMessage) error {
+	for field, raw := range patch {
+		isNull := string(raw) == "null"
+		switch field {
+		case "title":
+			if isNull {
+				return errors.New("title can't be removed")
+			}
+			if err := json.Unmarshal(raw, &video.Title); err != nil {
+				return errors.New("title must be a string")
+			}
+		case "description":
+			if isNull {
+				video.Description = ""
+				continue
+			}
+			if err := json.Unmarshal(raw, &video.Description); err != nil {
+				return errors.New("description must be a string")
+			}
+		case "visibility":
+			if isNull {
+				return errors.New("visibility can't be removed")
+			}
+			if err := json.Unmarshal(raw, &video.Visibility); err != nil || !video.Visibility.Valid() {
+				return errors.New("visibility must be private, unlisted or public")
+			}
+		default:
+			return fmt.Errorf("%s can't be changed", field)
+		}
+	}
+	return validateVideoFields(video.Title, video.Description)
+}
+
+func (cfg *apiConfig) handlerVideoUpdate(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+	videoID := video.ID
+
+	patch := map[string]json.RawMessage{}
+	decoder := json.NewDecoder(r.Body)
+	err := decoder.Decode(&patch)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Body must be a JSON merge patch object", err)
+		return
+	}
+
+	ifMatch := strings.Join(r.Header.Values("If-Match"), ",")
+	if ifMatch != "" && !etagMatches(ifMatch, videoETag(video)) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video has changed since it was fetched", nil)
+		return
+	}
+
+	lastUpdatedAt := video.UpdatedAt
+	err = applyVideoMergePatch(&video, patch)
+	if err != nil {
+		respondWithError(w, http.StatusUnprocessableEntity, err.Error(), err)
+		return
+	}
+
+	if ifMatch != "" {
+		err = cfg.db.UpdateVideoIfUnmodified(video, lastUpdatedAt)
+	} else {
+		err = cfg.db.UpdateVideo(video)
+	}
+	if errors.Is(err, database.ErrVideoNotFound) {
+		respondWithError(w, http.StatusNotFound, "Video not found", err)
+		return
+	}
+	if errors.Is(err, database.ErrVideoModified) {
+		respondWithError(w, http.StatusPreconditionFailed, "Video has changed since it was fetched", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	video, err = cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
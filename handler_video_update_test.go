@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestETagMatches(t *testing.T) {
+	const etag = `"5f3a"`
+	for _, tc := range []struct {
+		header string
+		want   bool
+	}{
+		{`"5f3a"`, true},
+		{`*`, true},
+		{`"1111", "5f3a"`, true},
+		{`"1111","5f3a"`, true},
+		{` "5f3a" `, true},
+		{`"a,b", "5f3a"`, true},
+		{`"1111"`, false},
+		{`W/"5f3a"`, false},
+		{`W/"5f3a", "5f3a"`, true},
+		{`5f3a`, false},
+		{`"5f3a`, false},
+		{``, false},
+	} {
+		if got := etagMatches(tc.header, etag); got != tc.want {
+			t.Errorf("etagMatches(%q) = %t, want %t", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestVideoVisibilityUpdateETag(t *testing.T) {
+	cfg := newTestConfig(t)
+	alice := createTestUser(t, cfg, "alice@example.com")
+	token := testAccessToken(t, cfg, alice.ID)
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "clip", UserID: alice.ID, Visibility: database.VisibilityPrivate})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/videos/"+video.ID.String()+"/visibility", strings.NewReader(`{"visibility":"public"}`))
+	rec := serve("PUT /api/videos/{videoID}/visibility", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoVisibilityUpdate), withBearer(req, token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("visibility update = %d: %s", rec.Code, rec.Body)
+	}
+	var got database.Video
+	decodeJSON(t, rec, &got)
+	stored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if !got.UpdatedAt.Equal(stored.UpdatedAt) {
+		t.Errorf("response updated_at = %v, want the stored %v", got.UpdatedAt, stored.UpdatedAt)
+	}
+	etag := rec.Header().Get("ETag")
+	if etag != videoETag(stored) {
+		t.Fatalf("ETag = %q, want %q", etag, videoETag(stored))
+	}
+
+	patch := func(ifMatch string) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPatch, "/api/videos/"+video.ID.String(), strings.NewReader(`{"title":"renamed"}`))
+		req.Header.Set("If-Match", ifMatch)
+		return serve("PATCH /api/videos/{videoID}", cfg.requireAuth(auth.ScopeVideosWrite, cfg.handlerVideoUpdate), withBearer(req, token)).Code
+	}
+	if code := patch(videoETag(video)); code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with the ETag from before the visibility update = %d, want 412", code)
+	}
+	if code := patch(`"stale", ` + etag); code != http.StatusOK {
+		t.Errorf("PATCH with a list holding the current ETag = %d, want 200", code)
+	}
+}
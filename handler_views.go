@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// viewDedupWindow is how long repeat beacons from the same viewer count
+	// as the same view.
+	viewDedupWindow       = 30 * time.Minute
+	viewHeartbeatInterval = 15 * time.Second
+	// maxHeartbeatGap caps the watch time a single heartbeat can add, so a
+	// player that was paused or suspended doesn't count as watching.
+	maxHeartbeatGap     = 2 * viewHeartbeatInterval
+	defaultStatsDays    = 30
+	maxStatsDays        = 365
+	maxViewerSessionLen = 128
+)
+
+// viewerKey identifies a viewer for deduplicating views: the user when
+// logged in, otherwise the player's session ID, otherwise the client's
+// address and user agent. It's hashed so no raw identifiers are stored.
+func viewerKey(r *http.Request, userID uuid.UUID, sessionID string) string {
+	var key string
+	switch {
+	case userID != uuid.Nil:
+		key = "user:" + userID.String()
+	case sessionID != "":
+		key = "session:" + sessionID
+	default:
+		key = "client:" + clientIP(r) + "|" + r.UserAgent()
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (cfg *apiConfig) handlerVideoViewRecord(w http.ResponseWriter, r *http.Request) {
+	viewerID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, viewerID)
+	if !ok {
+		return
+	}
+
+	var params struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil && !errors.Is(err, io.EOF) {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if len(params.SessionID) > maxViewerSessionLen {
+		respondWithError(w, http.StatusBadRequest, "Session ID is too long", nil)
+		return
+	}
+
+	view, counted, err := cfg.db.RecordView(video.ID, viewerKey(r, viewerID, params.SessionID), viewDedupWindow)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record view", err)
+		return
+	}
+
+	status := http.StatusOK
+	if counted {
+		status = http.StatusCreated
+	}
+	respondWithJSON(w, status, struct {
+		ViewID            uuid.UUID `json:"view_id"`
+		Counted           bool      `json:"counted"`
+		HeartbeatInterval int       `json:"heartbeat_interval"`
+	}{
+		ViewID:            view.ID,
+		Counted:           counted,
+		HeartbeatInterval: int(viewHeartbeatInterval.Seconds()),
+	})
+}
+
+func (cfg *apiConfig) handlerVideoViewHeartbeat(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getWatchableVideo(w, r, requestUserID(r))
+	if !ok {
+		return
+	}
+	viewID, err := uuid.Parse(r.PathValue("viewID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid view ID", err)
+		return
+	}
+
+	var params struct {
+		Position float64 `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	view, err := cfg.db.GetView(viewID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get view", err)
+		return
+	}
+	if view.ID == uuid.Nil || view.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "View not found", nil)
+		return
+	}
+
+	position := max(params.Position, 0)
+	if video.Duration > 0 {
+		position = min(position, video.Duration)
+	}
+	if err := cfg.db.RecordViewHeartbeat(view.ID, position, maxHeartbeatGap); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't record heartbeat", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type dailyStatsResponse struct {
+	Day           string  `json:"day"`
+	Views         int     `json:"views"`
+	UniqueViewers int     `json:"unique_viewers"`
+	WatchSeconds  float64 `json:"watch_seconds"`
+}
+
+// retentionPoint is how many views got at least Percent of the way through
+// the video, and what fraction of all views that is.
+type retentionPoint struct {
+	Percent  int     `json:"percent"`
+	Views    int     `json:"views"`
+	Fraction float64 `json:"fraction"`
+}
+
+type videoStatsResponse struct {
+	VideoID uuid.UUID `json:"video_id"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Views   int       `json:"views"`
+	// UniqueViewers counts each viewer once over the whole range, even if
+	// they watched on several days.
+	UniqueViewers       int                  `json:"unique_viewers"`
+	WatchSeconds        float64              `json:"watch_seconds"`
+	AverageWatchSeconds float64              `json:"average_watch_seconds"`
+	Retention           []retentionPoint     `json:"retention"`
+	Daily               []dailyStatsResponse `json:"daily"`
+}
+
+func (cfg *apiConfig) handlerVideoStatsGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.getOwnedVideo(w, r)
+	if !ok {
+		return
+	}
+
+	days := defaultStatsDays
+	if s := r.URL.Query().Get("days"); s != "" {
+		var err error
+		days, err = strconv.Atoi(s)
+		if err != nil || days < 1 || days > maxStatsDays {
+			respondWithError(w, http.StatusBadRequest, "days must be between 1 and 365", err)
+			return
+		}
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -(days - 1))
+	stats, err := cfg.db.GetVideoStats(video.ID, from, to)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get stats", err)
+		return
+	}
+
+	resp := videoStatsResponse{
+		VideoID:       video.ID,
+		From:          from.Format(time.DateOnly),
+		To:            to.Format(time.DateOnly),
+		UniqueViewers: stats.UniqueViewers,
+		Retention:     []retentionPoint{},
+		Daily:         []dailyStatsResponse{},
+	}
+	for _, day := range stats.Daily {
+		resp.Views += day.Views
+		resp.WatchSeconds += day.WatchSeconds
+		resp.Daily = append(resp.Daily, dailyStatsResponse{
+			Day:           day.Day.Format(time.DateOnly),
+			Views:         day.Views,
+			UniqueViewers: day.UniqueViewers,
+			WatchSeconds:  day.WatchSeconds,
+		})
+	}
+	if resp.Views > 0 {
+		resp.AverageWatchSeconds = resp.WatchSeconds / float64(resp.Views)
+	}
+	for b, views := range stats.Retention {
+		point := retentionPoint{Percent: b * 100 / database.RetentionBuckets, Views: views}
+		if resp.Views > 0 {
+			point.Fraction = float64(views) / float64(resp.Views)
+		}
+		resp.Retention = append(resp.Retention, point)
+	}
+
+	respondWithJSON(w, http.StatusOK, resp)
+}
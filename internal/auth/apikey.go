@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Scope is a permission an API key can be granted. Access tokens from
+// logging in carry every scope.
+type Scope string
+
+const (
+	ScopeVideosRead  Scope = "videos:read"
+	ScopeVideosWrite Scope = "videos:write"
+	ScopeUploads     Scope = "uploads"
+	// ScopeAccount covers everything else a user does with their account.
+	// API keys can't be granted it, so only access tokens have it.
+	ScopeAccount Scope = "account"
+)
+
+// Scopes lists every scope an API key can be granted.
+var Scopes = []Scope{ScopeVideosRead, ScopeVideosWrite, ScopeUploads}
+
+func (s Scope) Valid() bool {
+	switch s {
+	case ScopeVideosRead, ScopeVideosWrite, ScopeUploads:
+		return true
+	}
+	return false
+}
+
+const apiKeyMarker = "tubely_"
+
+// MakeAPIKey generates a new API key along with its prefix. The prefix is
+// stored in the clear to look the key up; the rest is only kept hashed.
+func MakeAPIKey() (key, prefix string, err error) {
+	b := make([]byte, 6+32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	prefix = hex.EncodeToString(b[:6])
+	return apiKeyMarker + prefix + "_" + hex.EncodeToString(b[6:]), prefix, nil
+}
+
+// APIKeyPrefix returns the lookup prefix of key, or false if key isn't
+// shaped like one made by MakeAPIKey.
+func APIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyMarker)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// HashAPIKey hashes key for storage. Keys are long and random, so a fast
+// hash is enough; there's nothing to brute-force.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -18,6 +18,12 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// TokenTypeMFA proves a user got their password right and still has to
+	// enter a second factor. It's no good as an access token.
+	TokenTypeMFA TokenType = "tubely-mfa"
+	// TokenTypeOIDCLogin remembers an OpenID Connect login in progress
+	// while the user is away at the identity provider.
+	TokenTypeOIDCLogin TokenType = "tubely-oidc-login"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -34,33 +40,78 @@ func CheckPasswordHash(password, hash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 }
 
+// Claims is what an access token says about its user. Role is a hint for
+// other services; the API itself checks the role stored on the user.
+type Claims struct {
+	UserID uuid.UUID
+	Role   string
+}
+
+type accessClaims struct {
+	Role string `json:"role,omitempty"`
+	jwt.RegisteredClaims
+}
+
 func MakeJWT(
 	userID uuid.UUID,
-	tokenSecret string,
+	role string,
+	keys *KeySet,
 	expiresIn time.Duration,
 ) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   userID.String(),
+	return keys.sign(accessClaims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeAccess),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
 	})
-	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+func ValidateJWT(tokenString string, keys *KeySet) (Claims, error) {
+	claimsStruct := accessClaims{}
 	token, err := jwt.ParseWithClaims(
 		tokenString,
 		&claimsStruct,
-		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+		keys.verificationKey,
 	)
 	if err != nil {
-		return uuid.Nil, err
+		return Claims{}, err
 	}
 
 	userIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return Claims{}, err
+	}
+
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		return Claims{}, err
+	}
+	if issuer != string(TokenTypeAccess) {
+		return Claims{}, errors.New("invalid issuer")
+	}
+
+	id, err := uuid.Parse(userIDString)
+	if err != nil {
+		return Claims{}, fmt.Errorf("invalid user ID: %w", err)
+	}
+	return Claims{UserID: id, Role: claimsStruct.Role}, nil
+}
+
+// MakeMFAToken returns a token for the second step of a login.
+func MakeMFAToken(userID uuid.UUID, keys *KeySet, expiresIn time.Duration) (string, error) {
+	return keys.sign(jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeMFA),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   userID.String(),
+	})
+}
+
+func ValidateMFAToken(tokenString string, keys *KeySet) (uuid.UUID, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, keys.verificationKey)
 	if err != nil {
 		return uuid.Nil, err
 	}
@@ -69,10 +120,14 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	if err != nil {
 		return uuid.Nil, err
 	}
-	if issuer != string(TokenTypeAccess) {
+	if issuer != string(TokenTypeMFA) {
 		return uuid.Nil, errors.New("invalid issuer")
 	}
 
+	userIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return uuid.Nil, err
+	}
 	id, err := uuid.Parse(userIDString)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("invalid user ID: %w", err)
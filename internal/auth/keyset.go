@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// legacyKeyID names the HS256 shared secret. Tokens signed with it carry no
+// kid header, as they did before key sets.
+const legacyKeyID = ""
+
+// minRSAKeyBits rejects RSA keys too small to be safe for signing.
+const minRSAKeyBits = 2048
+
+// KeySet holds the keys access tokens are signed and verified with. One key
+// signs; the others only verify, so tokens signed before a rotation keep
+// working until they expire.
+type KeySet struct {
+	signingID string
+	keys      map[string]signingKey
+}
+
+type signingKey struct {
+	method jwt.SigningMethod
+	// private signs tokens and, for HS256, verifies them too.
+	private any
+	// public verifies tokens. It's nil for HS256, which is never published.
+	public crypto.PublicKey
+}
+
+// NewSecretKeySet returns a key set with just an HS256 shared secret, the
+// way tokens were signed before key rotation was supported.
+func NewSecretKeySet(secret string) *KeySet {
+	return &KeySet{
+		signingID: legacyKeyID,
+		keys: map[string]signingKey{
+			legacyKeyID: {method: jwt.SigningMethodHS256, private: []byte(secret)},
+		},
+	}
+}
+
+// LoadKeySet reads the PEM private keys in dir, each named <kid>.pem, and
+// signs with the one named signingID. RSA keys sign with RS256 and Ed25519
+// keys with EdDSA. If legacySecret isn't empty, tokens signed with it
+// before the switch to key files are still accepted.
+func LoadKeySet(dir, signingID, legacySecret string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	ks := &KeySet{signingID: signingID, keys: map[string]signingKey{}}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := readSigningKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load JWT key %s: %w", kid, err)
+		}
+		ks.keys[kid] = key
+	}
+	if _, ok := ks.keys[signingID]; !ok || signingID == legacyKeyID {
+		return nil, fmt.Errorf("no JWT key named %q in %s", signingID, dir)
+	}
+	if legacySecret != "" {
+		ks.keys[legacyKeyID] = signingKey{method: jwt.SigningMethodHS256, private: []byte(legacySecret)}
+	}
+	return ks, nil
+}
+
+func readSigningKey(path string) (signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return signingKey{}, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return signingKey{}, errors.New("no PEM data found")
+	}
+
+	var private any
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return signingKey{}, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return signingKey{}, err
+	}
+
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		if k.N.BitLen() < minRSAKeyBits {
+			return signingKey{}, fmt.Errorf("RSA key must be at least %d bits", minRSAKeyBits)
+		}
+		return signingKey{method: jwt.SigningMethodRS256, private: k, public: k.Public()}, nil
+	case ed25519.PrivateKey:
+		return signingKey{method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	default:
+		return signingKey{}, fmt.Errorf("unsupported key type %T; use RSA or Ed25519", private)
+	}
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	key := ks.keys[ks.signingID]
+	token := jwt.NewWithClaims(key.method, claims)
+	if ks.signingID != legacyKeyID {
+		token.Header["kid"] = ks.signingID
+	}
+	return token.SignedString(key.private)
+}
+
+// verificationKey is the jwt.Keyfunc for tokens signed by the set. The
+// token's algorithm must match its key's, so a public key can't be passed
+// off as an HMAC secret.
+func (ks *KeySet) verificationKey(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("key %q doesn't sign with %s", kid, token.Method.Alg())
+	}
+	if key.public != nil {
+		return key.public, nil
+	}
+	return key.private, nil
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// N and E are set for RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Curve and X are set for Ed25519 keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS lists the public keys of the set, sorted by key ID, for other
+// services to verify tokens with. Shared secrets are left out.
+func (ks *KeySet) JWKS() []JWK {
+	jwks := []JWK{}
+	for kid, key := range ks.keys {
+		jwk := JWK{KeyID: kid, Use: "sig", Algorithm: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	sort.Slice(jwks, func(i, j int) bool { return jwks[i].KeyID < jwks[j].KeyID })
+	return jwks
+}
@@ -0,0 +1,300 @@
+package auth_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func newRSAKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+func newEd25519Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+// writeKey saves key in dir as <kid>.pem. RSA keys are written in PKCS #1
+// form and others in PKCS #8, so both are read.
+func writeKey(t *testing.T, dir, kid string, key any) {
+	t.Helper()
+	var block *pem.Block
+	if k, ok := key.(*rsa.PrivateKey); ok {
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadKeySet(t *testing.T, dir, signingID, legacySecret string) *auth.KeySet {
+	t.Helper()
+	ks, err := auth.LoadKeySet(dir, signingID, legacySecret)
+	if err != nil {
+		t.Fatalf("LoadKeySet(%q): %v", signingID, err)
+	}
+	return ks
+}
+
+func makeJWT(t *testing.T, userID uuid.UUID, ks *auth.KeySet) string {
+	t.Helper()
+	token, err := auth.MakeJWT(userID, "admin", ks, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	return token
+}
+
+func TestKeySetRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		key  any
+		alg  string
+	}{
+		{"RSA", newRSAKey(t, 2048), "RS256"},
+		{"Ed25519", newEd25519Key(t), "EdDSA"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeKey(t, dir, "key-1", tc.key)
+			ks := loadKeySet(t, dir, "key-1", "")
+
+			userID := uuid.New()
+			token := makeJWT(t, userID, ks)
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+			if err != nil {
+				t.Fatalf("ParseUnverified: %v", err)
+			}
+			if parsed.Header["alg"] != tc.alg || parsed.Header["kid"] != "key-1" {
+				t.Errorf("header = %v, want alg %s and kid key-1", parsed.Header, tc.alg)
+			}
+
+			claims, err := auth.ValidateJWT(token, ks)
+			if err != nil {
+				t.Fatalf("ValidateJWT: %v", err)
+			}
+			if claims.UserID != userID || claims.Role != "admin" {
+				t.Errorf("claims = %+v, want %s as admin", claims, userID)
+			}
+		})
+	}
+}
+
+// An HS256 token keyed with the RSA public key must not verify: that's
+// the classic algorithm confusion attack, as the public key is no secret.
+func TestKeySetRejectsAlgorithmConfusion(t *testing.T) {
+	dir := t.TempDir()
+	key := newRSAKey(t, 2048)
+	writeKey(t, dir, "key-1", key)
+	ks := loadKeySet(t, dir, "key-1", "")
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	for name, secret := range map[string][]byte{"PEM": publicPEM, "DER": der} {
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+			Issuer:    string(auth.TokenTypeAccess),
+			Subject:   uuid.NewString(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		})
+		forged.Header["kid"] = "key-1"
+		token, err := forged.SignedString(secret)
+		if err != nil {
+			t.Fatalf("SignedString: %v", err)
+		}
+		if _, err := auth.ValidateJWT(token, ks); err == nil {
+			t.Errorf("HS256 token keyed with the %s public key was accepted", name)
+		}
+	}
+}
+
+func TestKeySetRejectsUnknownKeyID(t *testing.T) {
+	dir, otherDir := t.TempDir(), t.TempDir()
+	writeKey(t, dir, "key-1", newEd25519Key(t))
+	writeKey(t, otherDir, "key-2", newEd25519Key(t))
+	ks := loadKeySet(t, dir, "key-1", "")
+
+	token := makeJWT(t, uuid.New(), loadKeySet(t, otherDir, "key-2", ""))
+	if _, err := auth.ValidateJWT(token, ks); err == nil {
+		t.Error("token signed with an unknown key was accepted")
+	}
+
+	// Nor does a token with no kid fall back to a shared secret that
+	// isn't configured.
+	token = makeJWT(t, uuid.New(), auth.NewSecretKeySet("secret"))
+	if _, err := auth.ValidateJWT(token, ks); err == nil {
+		t.Error("HS256 token was accepted without a legacy secret")
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "old", newRSAKey(t, 2048))
+	before := loadKeySet(t, dir, "old", "")
+	oldToken := makeJWT(t, uuid.New(), before)
+
+	writeKey(t, dir, "new", newEd25519Key(t))
+	after := loadKeySet(t, dir, "new", "")
+	newToken := makeJWT(t, uuid.New(), after)
+
+	if _, err := auth.ValidateJWT(oldToken, after); err != nil {
+		t.Errorf("token signed before the rotation: %v", err)
+	}
+	if _, err := auth.ValidateJWT(newToken, after); err != nil {
+		t.Errorf("token signed after the rotation: %v", err)
+	}
+	if _, err := auth.ValidateJWT(newToken, before); err == nil {
+		t.Error("token signed with the new key was accepted by a set without it")
+	}
+
+	if err := os.Remove(filepath.Join(dir, "old.pem")); err != nil {
+		t.Fatal(err)
+	}
+	retired := loadKeySet(t, dir, "new", "")
+	if _, err := auth.ValidateJWT(oldToken, retired); err == nil {
+		t.Error("token signed with a retired key was accepted")
+	}
+}
+
+func TestKeySetLegacySecret(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "key-1", newEd25519Key(t))
+	userID := uuid.New()
+	legacyToken := makeJWT(t, userID, auth.NewSecretKeySet("secret"))
+
+	claims, err := auth.ValidateJWT(legacyToken, loadKeySet(t, dir, "key-1", "secret"))
+	if err != nil {
+		t.Fatalf("ValidateJWT of a legacy token: %v", err)
+	}
+	if claims.UserID != userID {
+		t.Errorf("legacy token's user = %s, want %s", claims.UserID, userID)
+	}
+	if _, err := auth.ValidateJWT(legacyToken, loadKeySet(t, dir, "key-1", "another-secret")); err == nil {
+		t.Error("legacy token was accepted with the wrong secret")
+	}
+
+	// New tokens are signed with the key file, not the secret.
+	token := makeJWT(t, userID, loadKeySet(t, dir, "key-1", "secret"))
+	if _, err := auth.ValidateJWT(token, auth.NewSecretKeySet("secret")); err == nil {
+		t.Error("token from a key set was verified by its legacy secret alone")
+	}
+}
+
+func TestLoadKeySetErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		setup     func(t *testing.T, dir string)
+		signingID string
+	}{
+		{"missing signing key", func(t *testing.T, dir string) { writeKey(t, dir, "key-1", newEd25519Key(t)) }, "key-2"},
+		{"empty signing key ID", func(t *testing.T, dir string) { writeKey(t, dir, "key-1", newEd25519Key(t)) }, ""},
+		{"RSA key too small", func(t *testing.T, dir string) { writeKey(t, dir, "key-1", newRSAKey(t, 1024)) }, "key-1"},
+		{"not PEM", func(t *testing.T, dir string) {
+			if err := os.WriteFile(filepath.Join(dir, "key-1.pem"), []byte("not a key"), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}, "key-1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tc.setup(t, dir)
+			if _, err := auth.LoadKeySet(dir, tc.signingID, ""); err == nil {
+				t.Error("LoadKeySet succeeded")
+			}
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, edKey := newRSAKey(t, 2048), newEd25519Key(t)
+	writeKey(t, dir, "b-rsa", rsaKey)
+	writeKey(t, dir, "a-ed25519", edKey)
+	ks := loadKeySet(t, dir, "b-rsa", "secret")
+
+	jwks := ks.JWKS()
+	if len(jwks) != 2 {
+		t.Fatalf("JWKS = %+v, want the two public keys without the secret", jwks)
+	}
+	ed, rs := jwks[0], jwks[1]
+
+	if ed.KeyID != "a-ed25519" || ed.KeyType != "OKP" || ed.Curve != "Ed25519" || ed.Algorithm != "EdDSA" || ed.Use != "sig" {
+		t.Errorf("Ed25519 JWK = %+v", ed)
+	}
+	if x, err := base64.RawURLEncoding.DecodeString(ed.X); err != nil || !bytes.Equal(x, edKey.Public().(ed25519.PublicKey)) {
+		t.Errorf("Ed25519 JWK x = %q doesn't decode to the public key", ed.X)
+	}
+
+	if rs.KeyID != "b-rsa" || rs.KeyType != "RSA" || rs.Algorithm != "RS256" || rs.Use != "sig" {
+		t.Errorf("RSA JWK = %+v", rs)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(rs.N)
+	if err != nil || new(big.Int).SetBytes(n).Cmp(rsaKey.N) != 0 {
+		t.Errorf("RSA JWK n doesn't decode to the modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(rs.E)
+	if err != nil || new(big.Int).SetBytes(e).Int64() != int64(rsaKey.E) {
+		t.Errorf("RSA JWK e = %q, want %d", rs.E, rsaKey.E)
+	}
+
+	// Each key type only has its own members.
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var encoded []map[string]string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	want := []map[string]string{
+		{"kty": "OKP", "kid": "a-ed25519", "use": "sig", "alg": "EdDSA", "crv": "Ed25519", "x": ed.X},
+		{"kty": "RSA", "kid": "b-rsa", "use": "sig", "alg": "RS256", "n": rs.N, "e": "AQAB"},
+	}
+	for i := range want {
+		if len(encoded[i]) != len(want[i]) {
+			t.Errorf("JWK %d = %v, want %v", i, encoded[i], want[i])
+			continue
+		}
+		for k, v := range want[i] {
+			if encoded[i][k] != v {
+				t.Errorf("JWK %d %s = %q, want %q", i, k, encoded[i][k], v)
+			}
+		}
+	}
+
+	if secretOnly := auth.NewSecretKeySet("secret").JWKS(); len(secretOnly) != 0 {
+		t.Errorf("JWKS of a shared secret = %+v, want none", secretOnly)
+	}
+}
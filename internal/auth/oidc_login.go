@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDCLogin is what the server needs to finish an OpenID Connect login
+// when the user comes back from the identity provider.
+type OIDCLogin struct {
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+type oidcLoginClaims struct {
+	OIDCLogin
+	jwt.RegisteredClaims
+}
+
+// MakeOIDCLoginToken signs login so it can be kept in a cookie in the
+// user's browser rather than on the server.
+func MakeOIDCLoginToken(login OIDCLogin, keys *KeySet, expiresIn time.Duration) (string, error) {
+	return keys.sign(oidcLoginClaims{
+		OIDCLogin: login,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    string(TokenTypeOIDCLogin),
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		},
+	})
+}
+
+func ValidateOIDCLoginToken(tokenString string, keys *KeySet) (OIDCLogin, error) {
+	var claims oidcLoginClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, keys.verificationKey)
+	if err != nil {
+		return OIDCLogin{}, err
+	}
+	if claims.Issuer != string(TokenTypeOIDCLogin) {
+		return OIDCLogin{}, errors.New("invalid issuer")
+	}
+	return claims.OIDCLogin, nil
+}
+
+// MakeLoginCode returns a random single-use code that stands in for a
+// login's tokens in a redirect to the web app.
+func MakeLoginCode() (string, error) {
+	code := make([]byte, 32)
+	if _, err := rand.Read(code); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(code), nil
+}
+
+// HashLoginCode hashes a login code for storage.
+func HashLoginCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
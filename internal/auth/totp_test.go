@@ -0,0 +1,148 @@
+package auth_test
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+)
+
+// rfc6238Secret is the SHA-1 key of RFC 6238's test vectors,
+// "12345678901234567890", in base32.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfc6238Vectors are the SHA-1 test vectors of RFC 6238, appendix B. The
+// RFC's codes have eight digits; these are their last six.
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestValidateTOTPVectors(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		step, ok := auth.ValidateTOTP(rfc6238Secret, v.code, time.Unix(v.unix, 0))
+		if !ok {
+			t.Errorf("code %s at %d was rejected", v.code, v.unix)
+			continue
+		}
+		if want := v.unix / 30; step != want {
+			t.Errorf("code %s at %d is from step %d, want %d", v.code, v.unix, step, want)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	v := rfc6238Vectors[1]
+	at := time.Unix(v.unix, 0)
+	for _, tc := range []struct {
+		offset time.Duration
+		want   bool
+	}{
+		{-60 * time.Second, false},
+		{-30 * time.Second, true},
+		{30 * time.Second, true},
+		{60 * time.Second, false},
+	} {
+		step, ok := auth.ValidateTOTP(rfc6238Secret, v.code, at.Add(tc.offset))
+		if ok != tc.want {
+			t.Errorf("code checked %s off = %t, want %t", tc.offset, ok, tc.want)
+		}
+		if ok && step != v.unix/30 {
+			t.Errorf("code checked %s off is from step %d, want %d", tc.offset, step, v.unix/30)
+		}
+	}
+}
+
+func TestValidateTOTPInput(t *testing.T) {
+	v := rfc6238Vectors[3]
+	at := time.Unix(v.unix, 0)
+	for _, tc := range []struct {
+		name, secret, code string
+		want               bool
+	}{
+		{"spaces in the code", rfc6238Secret, v.code[:3] + " " + v.code[3:], true},
+		{"lowercase secret", strings.ToLower(rfc6238Secret), v.code, true},
+		{"wrong code", rfc6238Secret, "123456", false},
+		{"short code", rfc6238Secret, v.code[:5], false},
+		{"eight-digit code", rfc6238Secret, "89005924", false},
+		{"empty code", rfc6238Secret, "", false},
+		{"secret that isn't base32", "not base32!", v.code, false},
+	} {
+		if _, ok := auth.ValidateTOTP(tc.secret, tc.code, at); ok != tc.want {
+			t.Errorf("%s: ValidateTOTP = %t, want %t", tc.name, ok, tc.want)
+		}
+	}
+}
+
+func TestMakeTOTPSecret(t *testing.T) {
+	secret, err := auth.MakeTOTPSecret()
+	if err != nil {
+		t.Fatalf("MakeTOTPSecret: %v", err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("secret %q has %d characters, want 32 for 160 bits", secret, len(secret))
+	}
+	other, _ := auth.MakeTOTPSecret()
+	if secret == other {
+		t.Error("MakeTOTPSecret returned the same secret twice")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(auth.TOTPURI("Tubely", "alice@example.com", rfc6238Secret))
+	if err != nil {
+		t.Fatalf("parse URI: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Tubely:alice@example.com" {
+		t.Errorf("URI = %s, want otpauth://totp/Tubely:alice@example.com", uri)
+	}
+	query := uri.Query()
+	for k, want := range map[string]string{"secret": rfc6238Secret, "issuer": "Tubely", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if got := query.Get(k); got != want {
+			t.Errorf("URI %s = %q, want %q", k, got, want)
+		}
+	}
+}
+
+func TestMakeRecoveryCodes(t *testing.T) {
+	codes, err := auth.MakeRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("MakeRecoveryCodes: %v", err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("got %d codes, want 10", len(codes))
+	}
+	format := regexp.MustCompile(`^[A-Z2-7]{4}(-[A-Z2-7]{4}){5}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("code %q isn't six groups of four base32 characters", code)
+		}
+		if seen[code] {
+			t.Errorf("code %q was made twice", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestHashRecoveryCode(t *testing.T) {
+	hash := auth.HashRecoveryCode("ABCD-EFGH-IJKL-MNOP-QRST-UVWX")
+	for _, typed := range []string{"abcd-efgh-ijkl-mnop-qrst-uvwx", "ABCDEFGHIJKLMNOPQRSTUVWX", "abcd efgh ijkl mnop qrst uvwx"} {
+		if got := auth.HashRecoveryCode(typed); got != hash {
+			t.Errorf("HashRecoveryCode(%q) differs from the code as shown", typed)
+		}
+	}
+	if auth.HashRecoveryCode("ABCD-EFGH-IJKL-MNOP-QRST-UVWY") == hash {
+		t.Error("different codes have the same hash")
+	}
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKey is a long-lived credential a user can hand to scripts. The key
+// itself is only shown once, when it's created.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type CreateAPIKeyParams struct {
+	UserID  uuid.UUID
+	Name    string
+	Prefix  string
+	KeyHash string
+	Scopes  []string
+}
+
+const apiKeyColumns = `
+	id,
+	created_at,
+	user_id,
+	name,
+	prefix,
+	key_hash,
+	scopes,
+	last_used_at,
+	revoked_at
+`
+
+func scanAPIKey(row rowScanner) (APIKey, error) {
+	var k APIKey
+	var scopes string
+	err := row.Scan(&k.ID, &k.CreatedAt, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &k.LastUsedAt, &k.RevokedAt)
+	k.Scopes = strings.Fields(scopes)
+	return k, err
+}
+
+func (c Client) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO api_keys (id, created_at, user_id, name, prefix, key_hash, scopes)
+	VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
+	`
+	_, err := c.exec(query, id, params.UserID, params.Name, params.Prefix, params.KeyHash, strings.Join(params.Scopes, " "))
+	if err != nil {
+		return APIKey{}, err
+	}
+	return c.GetAPIKey(id)
+}
+
+func (c Client) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	return c.getAPIKey("id = ?", id)
+}
+
+// GetAPIKeyByPrefix finds a key by its prefix, whether or not it has been
+// revoked.
+func (c Client) GetAPIKeyByPrefix(prefix string) (APIKey, error) {
+	return c.getAPIKey("prefix = ?", prefix)
+}
+
+func (c Client) getAPIKey(condition string, arg any) (APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE ` + condition
+	k, err := scanAPIKey(c.queryRow(query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return APIKey{}, nil
+		}
+		return APIKey{}, err
+	}
+	return k, nil
+}
+
+// ListAPIKeys returns all of a user's keys, including revoked ones, newest
+// first.
+func (c Client) ListAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	query := `
+	SELECT` + apiKeyColumns + `
+	FROM api_keys
+	WHERE user_id = ?
+	ORDER BY created_at DESC, id
+	`
+	rows, err := c.query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (c Client) RenameAPIKey(id uuid.UUID, name string) error {
+	_, err := c.exec("UPDATE api_keys SET name = ? WHERE id = ?", name, id)
+	return err
+}
+
+// RevokeAPIKey stops a key from working. Revoking it again keeps the
+// original revocation time.
+func (c Client) RevokeAPIKey(id uuid.UUID) error {
+	_, err := c.exec("UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND revoked_at IS NULL", id)
+	return err
+}
+
+func (c Client) MarkAPIKeyUsed(id uuid.UUID, at time.Time) error {
+	_, err := c.exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", c.dialect.timeArg(at), id)
+	return err
+}
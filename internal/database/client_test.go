@@ -0,0 +1,42 @@
+package database_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database/storetest"
+)
+
+func TestClientConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		c, err := database.NewClient(filepath.Join(t.TempDir(), "tubely.db"))
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	})
+}
+
+// TestPostgresConformance runs the same suite against a real Postgres
+// server. It's skipped unless TEST_POSTGRES_URL names a database the tests
+// may migrate and empty, e.g. postgres://localhost/tubely_test?sslmode=disable.
+func TestPostgresConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_URL isn't set")
+	}
+	storetest.Run(t, func(t *testing.T) database.Store {
+		c, err := database.NewClient(dsn)
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+		if err := c.Reset(); err != nil {
+			t.Fatalf("Reset: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	})
+}
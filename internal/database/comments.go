@@ -0,0 +1,311 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultCommentPageSize = 20
+	MaxCommentPageSize     = 100
+)
+
+type Comment struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	VideoID   uuid.UUID  `json:"video_id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	ParentID  *uuid.UUID `json:"parent_id"`
+	Body      string     `json:"body"`
+	Hidden    bool       `json:"hidden"`
+	Pinned    bool       `json:"pinned"`
+	// ReplyCount counts the replies that aren't hidden.
+	ReplyCount int `json:"reply_count"`
+	// Seq numbers comments in the order they were made.
+	Seq int64 `json:"-"`
+}
+
+type CreateCommentParams struct {
+	VideoID  uuid.UUID
+	UserID   uuid.UUID
+	ParentID *uuid.UUID
+	Body     string
+}
+
+type ListCommentsParams struct {
+	VideoID uuid.UUID
+	// ParentID lists the replies to a comment, oldest first. uuid.Nil
+	// lists the top-level comments newest first, leaving out the pinned
+	// one.
+	ParentID uuid.UUID
+	// ViewerID also gets their own hidden comments.
+	ViewerID uuid.UUID
+	// IncludeHidden lists everyone's hidden comments, for the video's
+	// owner.
+	IncludeHidden bool
+	// Limit defaults to DefaultCommentPageSize and is capped at
+	// MaxCommentPageSize.
+	Limit  int
+	Cursor string
+}
+
+type CommentPage struct {
+	Items      []Comment `json:"items"`
+	NextCursor *string   `json:"next_cursor"`
+}
+
+type commentCursor struct {
+	Seq int64 `json:"s"`
+}
+
+func encodeCommentCursor(last Comment) string {
+	dat, _ := json.Marshal(commentCursor{Seq: last.Seq})
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+func decodeCommentCursor(cursor string) (commentCursor, error) {
+	var cur commentCursor
+	dat, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return cur, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(dat, &cur); err != nil || cur.Seq <= 0 {
+		return cur, ErrInvalidCursor
+	}
+	return cur, nil
+}
+
+func (p ListCommentsParams) normalize() ListCommentsParams {
+	if p.Limit <= 0 {
+		p.Limit = DefaultCommentPageSize
+	}
+	if p.Limit > MaxCommentPageSize {
+		p.Limit = MaxCommentPageSize
+	}
+	return p
+}
+
+// newCommentPage trims comments, which may hold one more than the limit, to
+// a page and sets its cursor.
+func newCommentPage(params ListCommentsParams, comments []Comment) CommentPage {
+	page := CommentPage{Items: comments}
+	if len(comments) > params.Limit {
+		page.Items = comments[:params.Limit]
+		cursor := encodeCommentCursor(page.Items[len(page.Items)-1])
+		page.NextCursor = &cursor
+	}
+	return page
+}
+
+const commentColumns = `
+	c.id,
+	c.created_at,
+	c.updated_at,
+	c.video_id,
+	c.user_id,
+	c.parent_id,
+	c.body,
+	c.hidden,
+	c.pinned,
+	c.seq,
+	(SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id AND NOT r.hidden)
+`
+
+func scanComment(row rowScanner) (Comment, error) {
+	var c Comment
+	err := row.Scan(
+		&c.ID,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+		&c.VideoID,
+		&c.UserID,
+		&c.ParentID,
+		&c.Body,
+		&c.Hidden,
+		&c.Pinned,
+		&c.Seq,
+		&c.ReplyCount,
+	)
+	return c, err
+}
+
+func (c Client) CreateComment(params CreateCommentParams) (Comment, error) {
+	id := uuid.New()
+	now := c.dialect.timeArg(roundTripNow())
+	// Postgres takes seq from a sequence. SQLite has none, but runs one
+	// write at a time, so the next number can be read from the table.
+	seqColumn, seqValue := "", ""
+	if c.dialect == dialectSQLite {
+		seqColumn, seqValue = ", seq", ", (SELECT COALESCE(MAX(seq), 0) + 1 FROM comments)"
+	}
+	_, err := c.exec(`
+	INSERT INTO comments (id, created_at, updated_at, video_id, user_id, parent_id, body`+seqColumn+`)
+	VALUES (?, ?, ?, ?, ?, ?, ?`+seqValue+`)
+	`, id, now, now, params.VideoID, params.UserID, params.ParentID, params.Body)
+	if err != nil {
+		return Comment{}, err
+	}
+	return c.GetComment(id)
+}
+
+func (c Client) GetComment(id uuid.UUID) (Comment, error) {
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments c
+	WHERE c.id = ?
+	`
+	comment, err := scanComment(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, nil
+		}
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+// GetPinnedComment returns the comment pinned to the top of a video, if
+// any.
+func (c Client) GetPinnedComment(videoID uuid.UUID) (Comment, error) {
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments c
+	WHERE c.video_id = ? AND c.pinned
+	`
+	comment, err := scanComment(c.queryRow(query, videoID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Comment{}, nil
+		}
+		return Comment{}, err
+	}
+	return comment, nil
+}
+
+// ListComments returns one page of a video's comments or of the replies to
+// one of them.
+func (c Client) ListComments(params ListCommentsParams) (CommentPage, error) {
+	params = params.normalize()
+
+	conditions := []string{"c.video_id = ?"}
+	args := []any{params.VideoID}
+	if params.ParentID == uuid.Nil {
+		conditions = append(conditions, "c.parent_id IS NULL", "NOT c.pinned")
+	} else {
+		conditions = append(conditions, "c.parent_id = ?")
+		args = append(args, params.ParentID)
+	}
+	if !params.IncludeHidden {
+		conditions = append(conditions, "(NOT c.hidden OR c.user_id = ?)")
+		args = append(args, params.ViewerID)
+	}
+
+	direction, op := "DESC", "<"
+	if params.ParentID != uuid.Nil {
+		direction, op = "ASC", ">"
+	}
+	if params.Cursor != "" {
+		after, err := decodeCommentCursor(params.Cursor)
+		if err != nil {
+			return CommentPage{}, err
+		}
+		conditions = append(conditions, "c.seq "+op+" ?")
+		args = append(args, after.Seq)
+	}
+
+	query := `
+	SELECT` + commentColumns + `
+	FROM comments c
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY c.seq ` + direction + `
+	LIMIT ?
+	`
+	args = append(args, params.Limit+1)
+
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return CommentPage{}, err
+	}
+	defer rows.Close()
+
+	comments := []Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return CommentPage{}, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return CommentPage{}, err
+	}
+	return newCommentPage(params, comments), nil
+}
+
+// UpdateCommentBody edits a comment and bumps its updated_at.
+func (c Client) UpdateCommentBody(id uuid.UUID, body string) error {
+	_, err := c.exec(
+		"UPDATE comments SET body = ?, updated_at = ? WHERE id = ?",
+		body, c.dialect.timeArg(roundTripNow()), id,
+	)
+	return err
+}
+
+// DeleteComment deletes a comment together with its replies.
+func (c Client) DeleteComment(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(c.dialect.rebind("DELETE FROM comments WHERE parent_id = ?"), id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind("DELETE FROM comments WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) SetCommentHidden(id uuid.UUID, hidden bool) error {
+	_, err := c.exec("UPDATE comments SET hidden = ? WHERE id = ?", hidden, id)
+	return err
+}
+
+// PinComment pins a comment to the top of its video, unpinning whichever
+// was pinned before. uuid.Nil just unpins.
+func (c Client) PinComment(videoID, commentID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(c.dialect.rebind("UPDATE comments SET pinned = ? WHERE video_id = ? AND pinned"), false, videoID)
+	if err != nil {
+		return err
+	}
+	if commentID != uuid.Nil {
+		_, err = tx.Exec(c.dialect.rebind("UPDATE comments SET pinned = ? WHERE id = ? AND video_id = ?"), true, commentID, videoID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteVideoComments removes every comment on a video.
+func (c Client) deleteVideoComments(tx *sql.Tx, videoID uuid.UUID) error {
+	_, err := tx.Exec(c.dialect.rebind("DELETE FROM comments WHERE video_id = ?"), videoID)
+	return err
+}
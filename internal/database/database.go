@@ -4,20 +4,30 @@ import (
 	"database/sql"
 	"fmt"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Client struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+	// fts5 is set when SQLite's FTS5 search index is available.
+	fts5 bool
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
+// NewClient opens the database described by dsn and applies any pending
+// migrations. Postgres URLs (postgres://...) use lib/pq, everything else is
+// opened as a SQLite file.
+func NewClient(dsn string) (Client, error) {
+	c, err := Open(dsn)
 	if err != nil {
 		return Client{}, err
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	_, err = c.MigrateUp()
+	if err != nil {
+		return Client{}, err
+	}
+	c.fts5, err = c.ensureSearchIndex()
 	if err != nil {
 		return Client{}, err
 	}
@@ -25,65 +35,84 @@ func NewClient(pathToDB string) (Client, error) {
 
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
-	if err != nil {
-		return err
-	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
+// Open connects to the database without touching its schema.
+func Open(dsn string) (Client, error) {
+	d, source := parseDSN(dsn)
+	db, err := sql.Open(d.driverName(), source)
 	if err != nil {
-		return err
+		return Client{}, err
 	}
-
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
-	if err != nil {
-		return err
+	if err := db.Ping(); err != nil {
+		return Client{}, fmt.Errorf("couldn't reach %s database: %w", d, err)
 	}
-	return nil
+	return Client{db: db, dialect: d}, nil
+}
+
+func (c Client) exec(query string, args ...any) (sql.Result, error) {
+	return c.db.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c Client) query(query string, args ...any) (*sql.Rows, error) {
+	return c.db.Query(c.dialect.rebind(query), args...)
+}
+
+func (c Client) queryRow(query string, args ...any) *sql.Row {
+	return c.db.QueryRow(c.dialect.rebind(query), args...)
 }
 
 func (c Client) Reset() error {
-	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
+	if _, err := c.exec("DELETE FROM storage_deletions"); err != nil {
+		return fmt.Errorf("failed to reset table storage_deletions: %w", err)
+	}
+	for _, table := range []string{"user_identities", "email_tokens", "login_codes", "recovery_codes", "user_totp"} {
+		if _, err := c.exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to reset table %s: %w", table, err)
+		}
+	}
+	if _, err := c.exec("DELETE FROM api_keys"); err != nil {
+		return fmt.Errorf("failed to reset table api_keys: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
-		return fmt.Errorf("failed to reset table users: %w", err)
+	if _, err := c.exec("DELETE FROM subscriptions"); err != nil {
+		return fmt.Errorf("failed to reset table subscriptions: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM reactions"); err != nil {
+		return fmt.Errorf("failed to reset table reactions: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM comments"); err != nil {
+		return fmt.Errorf("failed to reset table comments: %w", err)
+	}
+	for _, table := range []string{"video_daily_retention", "video_daily_stats", "video_views"} {
+		if _, err := c.exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("failed to reset table %s: %w", table, err)
+		}
+	}
+	if _, err := c.exec("DELETE FROM playlist_items"); err != nil {
+		return fmt.Errorf("failed to reset table playlist_items: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM playlists"); err != nil {
+		return fmt.Errorf("failed to reset table playlists: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
+	if _, err := c.exec("DELETE FROM video_tags"); err != nil {
+		return fmt.Errorf("failed to reset table video_tags: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM tags"); err != nil {
+		return fmt.Errorf("failed to reset table tags: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM videos"); err != nil {
 		return fmt.Errorf("failed to reset table videos: %w", err)
 	}
+	if _, err := c.exec("DELETE FROM profiles"); err != nil {
+		return fmt.Errorf("failed to reset table profiles: %w", err)
+	}
+	if _, err := c.exec("DELETE FROM users"); err != nil {
+		return fmt.Errorf("failed to reset table users: %w", err)
+	}
 	return nil
 }
+
+func (c Client) Close() error {
+	return c.db.Close()
+}
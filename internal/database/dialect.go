@@ -0,0 +1,133 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+type dialect int
+
+const (
+	dialectSQLite dialect = iota
+	dialectPostgres
+)
+
+// parseDSN picks the driver from the connection string's scheme. Anything
+// without a postgres:// or postgresql:// scheme is treated as a SQLite path,
+// optionally prefixed with sqlite:// or sqlite3://.
+func parseDSN(dsn string) (dialect, string) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return dialectPostgres, dsn
+	case strings.HasPrefix(dsn, "sqlite3://"):
+		return dialectSQLite, strings.TrimPrefix(dsn, "sqlite3://")
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return dialectSQLite, strings.TrimPrefix(dsn, "sqlite://")
+	default:
+		return dialectSQLite, dsn
+	}
+}
+
+func (d dialect) driverName() string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite3"
+}
+
+func (d dialect) String() string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite"
+}
+
+// rebind rewrites the ? placeholders used throughout this package into the
+// $1, $2, ... form Postgres expects. A ? inside a 'string literal', a
+// "quoted identifier" or a -- or /* */ comment is left alone. Postgres's ?,
+// ?| and ?& JSON operators can't be told apart from placeholders, so queries
+// that need them must call jsonb_exists, jsonb_exists_any or jsonb_exists_all
+// instead; dollar-quoted strings aren't recognised either.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	for i := 0; i < len(query); i++ {
+		// end is where the quoted section or comment starting at i stops,
+		// or -1 if there isn't one.
+		end := -1
+		switch {
+		case query[i] == '\'' || query[i] == '"':
+			// A doubled quote inside the section closes it and opens it
+			// again straight away, which copies the same bytes.
+			if j := strings.IndexByte(query[i+1:], query[i]); j >= 0 {
+				end = i + 1 + j + 1
+			} else {
+				end = len(query)
+			}
+		case strings.HasPrefix(query[i:], "--"):
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				end = i + j + 1
+			} else {
+				end = len(query)
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				end = i + 2 + j + 2
+			} else {
+				end = len(query)
+			}
+		}
+		if end >= 0 {
+			b.WriteString(query[i:end])
+			i = end - 1
+			continue
+		}
+		if query[i] == '?' {
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(query[i])
+	}
+	return b.String()
+}
+
+// sqliteTimeFormat matches what CURRENT_TIMESTAMP writes, with fractional
+// seconds only when there are any, so that timestamps written from Go and by
+// SQLite itself compare correctly as text.
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999"
+
+// timeArg converts t into a query argument that compares correctly against
+// timestamp columns.
+func (d dialect) timeArg(t time.Time) any {
+	if d == dialectPostgres {
+		return t.UTC()
+	}
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// isUniqueViolation reports whether err is a driver error for a UNIQUE or
+// PRIMARY KEY constraint, on either dialect.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return false
+}
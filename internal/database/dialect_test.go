@@ -0,0 +1,26 @@
+package database
+
+import "testing"
+
+func TestRebind(t *testing.T) {
+	for _, tc := range []struct {
+		name, query, want string
+	}{
+		{"placeholders", "SELECT * FROM videos WHERE id = ? AND user_id = ?", "SELECT * FROM videos WHERE id = $1 AND user_id = $2"},
+		{"no placeholders", "SELECT 1", "SELECT 1"},
+		{"string literal", "SELECT 'why?' WHERE id = ?", "SELECT 'why?' WHERE id = $1"},
+		{"doubled quote", "SELECT 'it''s ?' WHERE id = ?", "SELECT 'it''s ?' WHERE id = $1"},
+		{"quoted identifier", `SELECT "odd?name" FROM t WHERE id = ?`, `SELECT "odd?name" FROM t WHERE id = $1`},
+		{"line comment", "SELECT ? -- really?\nFROM t WHERE id = ?", "SELECT $1 -- really?\nFROM t WHERE id = $2"},
+		{"block comment", "SELECT /* ? */ ? FROM t", "SELECT /* ? */ $1 FROM t"},
+		{"unterminated literal", "SELECT ? WHERE name = 'x?", "SELECT $1 WHERE name = 'x?"},
+		{"non-ASCII", "SELECT 'héllo?', ?", "SELECT 'héllo?', $1"},
+	} {
+		if got := dialectPostgres.rebind(tc.query); got != tc.want {
+			t.Errorf("%s: rebind(%q) = %q, want %q", tc.name, tc.query, got, tc.want)
+		}
+	}
+	if q := "SELECT ? FROM t"; dialectSQLite.rebind(q) != q {
+		t.Errorf("SQLite rebind changed %q", q)
+	}
+}
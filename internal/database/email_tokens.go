@@ -0,0 +1,101 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailTokenPurpose is what an emailed token lets its holder do. A token
+// only works for the purpose it was made for.
+type EmailTokenPurpose string
+
+const (
+	EmailTokenVerifyEmail   EmailTokenPurpose = "verify_email"
+	EmailTokenResetPassword EmailTokenPurpose = "reset_password"
+)
+
+type CreateEmailTokenParams struct {
+	UserID    uuid.UUID
+	Purpose   EmailTokenPurpose
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+// CreateEmailToken saves a new token. The user's unused tokens for the same
+// purpose stop working, so only the latest email's link does.
+func (c Client) CreateEmailToken(params CreateEmailTokenParams) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(c.dialect.rebind(`
+		DELETE FROM email_tokens
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL
+	`), params.UserID, params.Purpose)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind(`
+		INSERT INTO email_tokens (token_hash, user_id, purpose, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`), params.TokenHash, params.UserID, params.Purpose,
+		c.dialect.timeArg(roundTripNow()), c.dialect.timeArg(params.ExpiresAt))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetEmailTokenUser returns the user the token with the given hash was made
+// for, without using it up. Like UseEmailToken it returns uuid.Nil if
+// there's no such token for purpose, or it was already used or has expired.
+func (c Client) GetEmailTokenUser(purpose EmailTokenPurpose, tokenHash string) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := c.queryRow(`
+		SELECT user_id FROM email_tokens
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+	`, tokenHash, purpose, c.dialect.timeArg(roundTripNow())).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, nil
+	}
+	return userID, err
+}
+
+// UseEmailToken marks the token with the given hash as used and returns
+// the user it was made for. It returns uuid.Nil if there's no such token
+// for purpose, or it was already used or has expired.
+func (c Client) UseEmailToken(purpose EmailTokenPurpose, tokenHash string) (uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	now := roundTripNow()
+	result, err := tx.Exec(c.dialect.rebind(`
+		UPDATE email_tokens
+		SET used_at = ?
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?
+	`), c.dialect.timeArg(now), tokenHash, purpose, c.dialect.timeArg(now))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return uuid.Nil, err
+	}
+
+	var userID uuid.UUID
+	err = tx.QueryRow(c.dialect.rebind(`
+		SELECT user_id FROM email_tokens WHERE token_hash = ?
+	`), tokenHash).Scan(&userID)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return userID, tx.Commit()
+}
@@ -0,0 +1,37 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// GetUserByIdentity returns the user linked to the account subject at the
+// OpenID Connect provider issuer, or nil if none is.
+func (c Client) GetUserByIdentity(issuer, subject string) (*User, error) {
+	query := `
+		SELECT` + userColumns + `
+		FROM users u
+		JOIN user_identities ui ON u.id = ui.user_id
+		WHERE ui.issuer = ? AND ui.subject = ?
+	`
+	user, err := scanUser(c.queryRow(query, issuer, subject))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// LinkIdentity lets the user log in with the account subject at issuer.
+func (c Client) LinkIdentity(userID uuid.UUID, issuer, subject string) error {
+	query := `
+		INSERT INTO user_identities (issuer, subject, user_id, created_at)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err := c.exec(query, issuer, subject, userID, c.dialect.timeArg(roundTripNow()))
+	return err
+}
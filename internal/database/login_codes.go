@@ -0,0 +1,82 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreateLoginCodeParams struct {
+	UserID    uuid.UUID
+	CodeHash  string
+	ExpiresAt time.Time
+}
+
+// CreateLoginCode saves a new login code. Expired codes are cleared out
+// on the way.
+func (c Client) CreateLoginCode(params CreateLoginCodeParams) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := roundTripNow()
+	_, err = tx.Exec(c.dialect.rebind(`
+		DELETE FROM login_codes WHERE expires_at <= ?
+	`), c.dialect.timeArg(now))
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind(`
+		INSERT INTO login_codes (code_hash, user_id, created_at, expires_at)
+		VALUES (?, ?, ?, ?)
+	`), params.CodeHash, params.UserID, c.dialect.timeArg(now), c.dialect.timeArg(params.ExpiresAt))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseLoginCode deletes the code with the given hash and returns the user it
+// was made for. It returns uuid.Nil if there's no such code, or it has
+// expired.
+func (c Client) UseLoginCode(codeHash string) (uuid.UUID, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	var userID uuid.UUID
+	var expiresAt time.Time
+	err = tx.QueryRow(c.dialect.rebind(`
+		SELECT user_id, expires_at FROM login_codes WHERE code_hash = ?
+	`), codeHash).Scan(&userID, &expiresAt)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, nil
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// Only the request that deletes the code gets to use it.
+	result, err := tx.Exec(c.dialect.rebind(`
+		DELETE FROM login_codes WHERE code_hash = ?
+	`), codeHash)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return uuid.Nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return uuid.Nil, err
+	}
+	if !expiresAt.After(time.Now()) {
+		return uuid.Nil, nil
+	}
+	return userID, nil
+}
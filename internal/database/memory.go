@@ -0,0 +1,1607 @@
+package database
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryStore is a Store that keeps everything in maps. It's meant for
+// handler tests and mirrors Client's behaviour, including its quirks around
+// missing rows, closely enough to pass the storetest suite.
+type MemoryStore struct {
+	mu            sync.Mutex
+	users         map[uuid.UUID]User
+	profiles      map[uuid.UUID]Profile
+	videos        map[uuid.UUID]Video
+	refreshTokens map[string]RefreshToken
+	apiKeys       map[uuid.UUID]APIKey
+	deletions     map[uuid.UUID]StorageDeletion
+	videoTags     map[uuid.UUID][]string
+	playlists     map[uuid.UUID]Playlist
+	// playlistItems holds each playlist's entries in position order.
+	playlistItems map[uuid.UUID][]memoryPlaylistEntry
+	views         map[uuid.UUID]View
+	// viewRollups is keyed by video and then by the day's Unix time.
+	viewRollups map[uuid.UUID]map[int64]viewRollup
+	comments    map[uuid.UUID]Comment
+	// commentSeq is the Seq of the last comment made.
+	commentSeq int64
+	// reactions is keyed by video and then by user.
+	reactions map[uuid.UUID]map[uuid.UUID]Reaction
+	// subscriptions is keyed by subscriber and then by channel.
+	subscriptions map[uuid.UUID]map[uuid.UUID]time.Time
+	totp          map[uuid.UUID]TOTP
+	// recoveryCodes is keyed by user and then by code hash, and records
+	// whether each code was used.
+	recoveryCodes map[uuid.UUID]map[string]bool
+	// emailTokens is keyed by token hash.
+	emailTokens map[string]memoryEmailToken
+	// loginCodes is keyed by code hash.
+	loginCodes map[string]CreateLoginCodeParams
+	// identities maps OpenID Connect accounts to users.
+	identities map[memoryIdentity]uuid.UUID
+}
+
+type memoryIdentity struct {
+	issuer, subject string
+}
+
+type memoryEmailToken struct {
+	CreateEmailTokenParams
+	used bool
+}
+
+type memoryPlaylistEntry struct {
+	videoID uuid.UUID
+	addedAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{}
+	s.Reset()
+	return s
+}
+
+func (s *MemoryStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = map[uuid.UUID]User{}
+	s.profiles = map[uuid.UUID]Profile{}
+	s.videos = map[uuid.UUID]Video{}
+	s.refreshTokens = map[string]RefreshToken{}
+	s.apiKeys = map[uuid.UUID]APIKey{}
+	s.deletions = map[uuid.UUID]StorageDeletion{}
+	s.videoTags = map[uuid.UUID][]string{}
+	s.playlists = map[uuid.UUID]Playlist{}
+	s.playlistItems = map[uuid.UUID][]memoryPlaylistEntry{}
+	s.views = map[uuid.UUID]View{}
+	s.viewRollups = map[uuid.UUID]map[int64]viewRollup{}
+	s.comments = map[uuid.UUID]Comment{}
+	s.reactions = map[uuid.UUID]map[uuid.UUID]Reaction{}
+	s.subscriptions = map[uuid.UUID]map[uuid.UUID]time.Time{}
+	s.totp = map[uuid.UUID]TOTP{}
+	s.recoveryCodes = map[uuid.UUID]map[string]bool{}
+	s.emailTokens = map[string]memoryEmailToken{}
+	s.loginCodes = map[string]CreateLoginCodeParams{}
+	s.identities = map[memoryIdentity]uuid.UUID{}
+	return nil
+}
+
+func memoryNow() time.Time {
+	return time.Now().UTC()
+}
+
+func (s *MemoryStore) GetUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := []User{}
+	for _, user := range s.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !users[i].CreatedAt.Equal(users[j].CreatedAt) {
+			return users[i].CreatedAt.Before(users[j].CreatedAt)
+		}
+		return users[i].Email < users[j].Email
+	})
+	return users, nil
+}
+
+func (s *MemoryStore) GetUser(id uuid.UUID) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, nil
+}
+
+func (s *MemoryStore) CreateUser(params CreateUserParams) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Email == params.Email {
+			return nil, errors.New("email already in use")
+		}
+	}
+	now := memoryNow()
+	user := User{
+		ID:               uuid.New(),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		Role:             RoleCreator,
+		CreateUserParams: params,
+	}
+	s.users[user.ID] = user
+	return &user, nil
+}
+
+func (s *MemoryStore) SetUserRole(id uuid.UUID, role Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	user.Role = role
+	user.UpdatedAt = memoryNow()
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) SetUserDisabled(id uuid.UUID, disabled bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	now := memoryNow()
+	if !disabled {
+		user.DisabledAt = nil
+	} else if user.DisabledAt == nil {
+		user.DisabledAt = &now
+	}
+	user.UpdatedAt = now
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) SetUserEmailVerified(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	now := memoryNow()
+	if user.EmailVerifiedAt == nil {
+		user.EmailVerifiedAt = &now
+	}
+	user.UpdatedAt = now
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) SetUserPassword(id uuid.UUID, hashedPassword string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil
+	}
+	user.Password = hashedPassword
+	user.UpdatedAt = memoryNow()
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryStore) GetUserByIdentity(issuer, subject string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[s.identities[memoryIdentity{issuer, subject}]]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) LinkIdentity(userID uuid.UUID, issuer, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := memoryIdentity{issuer, subject}
+	if _, ok := s.identities[key]; ok {
+		return errors.New("identity is already linked")
+	}
+	s.identities[key] = userID
+	return nil
+}
+
+func (s *MemoryStore) DeleteUser(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, id)
+	return nil
+}
+
+func (s *MemoryStore) GetProfile(userID uuid.UUID) (Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.profiles[userID], nil
+}
+
+func (s *MemoryStore) GetProfileByHandle(handle string) (Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	handle = NormalizeHandle(handle)
+	for _, p := range s.profiles {
+		if p.Handle == handle {
+			return p, nil
+		}
+	}
+	return Profile{}, nil
+}
+
+func (s *MemoryStore) SaveProfile(p Profile) (Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.Handle = NormalizeHandle(p.Handle)
+	for _, other := range s.profiles {
+		if other.Handle == p.Handle && other.UserID != p.UserID {
+			return Profile{}, ErrHandleTaken
+		}
+	}
+	now := memoryNow()
+	p.CreatedAt = now
+	if existing, ok := s.profiles[p.UserID]; ok {
+		p.CreatedAt = existing.CreatedAt
+	}
+	p.UpdatedAt = now
+	s.profiles[p.UserID] = p
+	return p, nil
+}
+
+func (s *MemoryStore) GetVideos(userID uuid.UUID) ([]Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos := []Video{}
+	for _, video := range s.videos {
+		if video.UserID == userID && video.DeletedAt == nil {
+			videos = append(videos, video)
+		}
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].CreatedAt.After(videos[j].CreatedAt)
+	})
+	return videos, nil
+}
+
+func (s *MemoryStore) ListVideos(params ListVideosParams) (VideoPage, error) {
+	params, err := params.normalize()
+	if err != nil {
+		return VideoPage{}, err
+	}
+	var after *Video
+	if params.Cursor != "" {
+		cur, err := decodeVideoCursor(params)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		after = &cur
+	}
+
+	// order returns a positive number when a comes later in the listing
+	// than b.
+	order := func(a, b Video) int {
+		c := compareVideos(a, b, params.Sort)
+		if params.Ascending {
+			return c
+		}
+		return -c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos := []Video{}
+	for _, video := range s.videos {
+		if video.DeletedAt != nil {
+			continue
+		}
+		if params.UserID != uuid.Nil && video.UserID != params.UserID {
+			continue
+		}
+		if params.Visibility != "" && video.Visibility != params.Visibility {
+			continue
+		}
+		if params.Tag != "" && !slices.Contains(s.videoTags[video.ID], params.Tag) {
+			continue
+		}
+		if params.SubscriberID != uuid.Nil {
+			if _, ok := s.subscriptions[params.SubscriberID][video.UserID]; !ok {
+				continue
+			}
+		}
+		if params.HasVideo != nil && (video.VideoURL != nil) != *params.HasVideo {
+			continue
+		}
+		if params.HasThumbnail != nil && (video.ThumbnailURL != nil) != *params.HasThumbnail {
+			continue
+		}
+		if params.CreatedAfter != nil && !video.CreatedAt.After(*params.CreatedAfter) {
+			continue
+		}
+		if after != nil && order(video, *after) <= 0 {
+			continue
+		}
+		videos = append(videos, video)
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		return order(videos[i], videos[j]) < 0
+	})
+	if len(videos) > params.Limit+1 {
+		videos = videos[:params.Limit+1]
+	}
+	return newVideoPage(params, videos), nil
+}
+
+func (s *MemoryStore) SearchVideos(params SearchVideosParams) ([]VideoSearchResult, error) {
+	params, terms, err := params.normalize()
+	if err != nil {
+		return nil, err
+	}
+	videos, err := s.GetVideos(params.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return searchVideosInMemory(videos, params, terms), nil
+}
+
+func (s *MemoryStore) GetVideo(id uuid.UUID) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video := s.videos[id]
+	if video.DeletedAt != nil {
+		return Video{}, nil
+	}
+	return video, nil
+}
+
+func (s *MemoryStore) GetVideoByThumbnailURL(url string) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, video := range s.videos {
+		if video.ThumbnailURL != nil && *video.ThumbnailURL == url && video.DeletedAt == nil {
+			return video, nil
+		}
+	}
+	return Video{}, nil
+}
+
+func (s *MemoryStore) CreateVideo(params CreateVideoParams) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if params.Visibility == "" {
+		params.Visibility = VisibilityPrivate
+	}
+	now := memoryNow()
+	video := Video{
+		ID:                uuid.New(),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		CreateVideoParams: params,
+	}
+	s.videos[video.ID] = video
+	return video, nil
+}
+
+func (s *MemoryStore) UpdateVideo(video Video) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.updateVideo(video) {
+		return ErrVideoNotFound
+	}
+	return nil
+}
+
+func (s *MemoryStore) UpdateVideoIfUnmodified(video Video, lastUpdatedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.videos[video.ID]
+	if !ok || existing.DeletedAt != nil {
+		return ErrVideoNotFound
+	}
+	if !existing.UpdatedAt.Equal(lastUpdatedAt) {
+		return ErrVideoModified
+	}
+	s.updateVideo(video)
+	return nil
+}
+
+func (s *MemoryStore) updateVideo(video Video) bool {
+	existing, ok := s.videos[video.ID]
+	if !ok || existing.DeletedAt != nil {
+		return false
+	}
+	existing.Title = video.Title
+	existing.Description = video.Description
+	existing.ThumbnailURL = video.ThumbnailURL
+	existing.VideoURL = video.VideoURL
+	existing.Duration = video.Duration
+	existing.UserID = video.UserID
+	existing.Visibility = video.Visibility
+	existing.UpdatedAt = roundTripNow()
+	s.videos[video.ID] = existing
+	return true
+}
+
+func (s *MemoryStore) DeleteVideo(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.videos, id)
+	delete(s.videoTags, id)
+	s.deletePlaylistEntries(id)
+	s.deleteVideoViews(id)
+	s.deleteVideoComments(id)
+	delete(s.reactions, id)
+	return nil
+}
+
+func (s *MemoryStore) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.refreshTokens[params.Token]; ok {
+		return RefreshToken{}, errors.New("refresh token already exists")
+	}
+	now := memoryNow()
+	rt := RefreshToken{
+		CreateRefreshTokenParams: params,
+		CreatedAt:                now,
+		UpdatedAt:                now,
+		FamilyID:                 uuid.New(),
+	}
+	s.refreshTokens[params.Token] = rt
+	return rt, nil
+}
+
+func (s *MemoryStore) RotateRefreshToken(oldToken, newToken string, expiresAt time.Time, client SessionClient) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.refreshTokens[oldToken]
+	if !ok {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+	now := memoryNow()
+	if old.RotatedAt != nil {
+		for token, rt := range s.refreshTokens {
+			if rt.FamilyID == old.FamilyID && rt.RevokedAt == nil {
+				rt.RevokedAt = &now
+				rt.UpdatedAt = now
+				s.refreshTokens[token] = rt
+			}
+		}
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+	if old.RevokedAt != nil || !old.ExpiresAt.After(now) {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+	if _, ok := s.refreshTokens[newToken]; ok {
+		return RefreshToken{}, errors.New("refresh token already exists")
+	}
+
+	old.RevokedAt = &now
+	old.RotatedAt = &now
+	old.UpdatedAt = now
+	s.refreshTokens[oldToken] = old
+	rt := RefreshToken{
+		CreateRefreshTokenParams: CreateRefreshTokenParams{
+			Token:         newToken,
+			UserID:        old.UserID,
+			ExpiresAt:     expiresAt,
+			SessionClient: client,
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+		FamilyID:  old.FamilyID,
+	}
+	s.refreshTokens[newToken] = rt
+	return rt, nil
+}
+
+func (s *MemoryStore) GetRefreshToken(token string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshTokens[token], nil
+}
+
+func (s *MemoryStore) GetUserByRefreshToken(token string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.refreshTokens[token]
+	if !ok || rt.RevokedAt != nil || !rt.ExpiresAt.After(memoryNow()) {
+		return nil, nil
+	}
+	user, ok := s.users[rt.UserID]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) RevokeRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rt, ok := s.refreshTokens[token]
+	if !ok {
+		return nil
+	}
+	now := memoryNow()
+	rt.RevokedAt = &now
+	s.refreshTokens[token] = rt
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := memoryNow()
+	for token, rt := range s.refreshTokens {
+		if rt.UserID == userID && rt.RevokedAt == nil {
+			rt.RevokedAt = &now
+			s.refreshTokens[token] = rt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListSessions(userID uuid.UUID) ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := memoryNow()
+	sessions := []Session{}
+	for _, rt := range s.refreshTokens {
+		if rt.UserID != userID || rt.RevokedAt != nil || !rt.ExpiresAt.After(now) {
+			continue
+		}
+		sessions = append(sessions, Session{
+			ID:            rt.FamilyID,
+			LastUsedAt:    rt.CreatedAt,
+			ExpiresAt:     rt.ExpiresAt,
+			SessionClient: rt.SessionClient,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].LastUsedAt.Equal(sessions[j].LastUsedAt) {
+			return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+		}
+		return sessions[i].ID.String() < sessions[j].ID.String()
+	})
+	return sessions, nil
+}
+
+func (s *MemoryStore) RevokeSession(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := memoryNow()
+	for token, rt := range s.refreshTokens {
+		if rt.FamilyID == id && rt.RevokedAt == nil {
+			rt.RevokedAt = &now
+			s.refreshTokens[token] = rt
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.refreshTokens, token)
+	return nil
+}
+
+func (s *MemoryStore) DeleteVideoWithObjects(videoID uuid.UUID, objects []StorageObject) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := memoryNow()
+	for _, object := range objects {
+		d := StorageDeletion{
+			ID:            uuid.New(),
+			CreatedAt:     now,
+			VideoID:       videoID,
+			StorageObject: object,
+			NextAttemptAt: now,
+		}
+		s.deletions[d.ID] = d
+	}
+	delete(s.videos, videoID)
+	delete(s.videoTags, videoID)
+	s.deletePlaylistEntries(videoID)
+	s.deleteVideoViews(videoID)
+	s.deleteVideoComments(videoID)
+	delete(s.reactions, videoID)
+	return nil
+}
+
+func (s *MemoryStore) ClaimStorageDeletions(limit int, lease time.Duration) ([]StorageDeletion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := memoryNow()
+	due := []StorageDeletion{}
+	for _, d := range s.deletions {
+		if !d.NextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttemptAt.Before(due[j].NextAttemptAt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].NextAttemptAt = now.Add(lease)
+		s.deletions[due[i].ID] = due[i]
+	}
+	return due, nil
+}
+
+func (s *MemoryStore) CompleteStorageDeletion(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.deletions, id)
+	return nil
+}
+
+func (s *MemoryStore) FailStorageDeletion(id uuid.UUID, lastError string, retryAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deletions[id]
+	if !ok {
+		return nil
+	}
+	d.Attempts++
+	d.LastError = &lastError
+	d.NextAttemptAt = retryAt
+	s.deletions[id] = d
+	return nil
+}
+
+func (s *MemoryStore) TrashVideo(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video, ok := s.videos[id]
+	if !ok || video.DeletedAt != nil {
+		return nil
+	}
+	now := memoryNow()
+	video.DeletedAt = &now
+	s.videos[id] = video
+	return nil
+}
+
+func (s *MemoryStore) RestoreVideo(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video, ok := s.videos[id]
+	if !ok || video.DeletedAt == nil {
+		return nil
+	}
+	video.DeletedAt = nil
+	video.UpdatedAt = roundTripNow()
+	s.videos[id] = video
+	return nil
+}
+
+func (s *MemoryStore) GetTrashedVideo(id uuid.UUID) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video := s.videos[id]
+	if video.DeletedAt == nil {
+		return Video{}, nil
+	}
+	return video, nil
+}
+
+func (s *MemoryStore) ListTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos := []Video{}
+	for _, video := range s.videos {
+		if video.UserID == userID && video.DeletedAt != nil {
+			videos = append(videos, video)
+		}
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].DeletedAt.After(*videos[j].DeletedAt)
+	})
+	return videos, nil
+}
+
+func (s *MemoryStore) ListVideosTrashedBefore(cutoff time.Time, limit int) ([]Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	videos := []Video{}
+	for _, video := range s.videos {
+		if video.DeletedAt != nil && video.DeletedAt.Before(cutoff) {
+			videos = append(videos, video)
+		}
+	}
+	sort.Slice(videos, func(i, j int) bool {
+		return videos[i].DeletedAt.Before(*videos[j].DeletedAt)
+	})
+	if len(videos) > limit {
+		videos = videos[:limit]
+	}
+	return videos, nil
+}
+
+func (s *MemoryStore) SetVideoTags(videoID uuid.UUID, names []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := normalizeTags(names)
+	slices.Sort(tags)
+	s.videoTags[videoID] = tags
+	return append([]string{}, tags...), nil
+}
+
+func (s *MemoryStore) GetVideoTags(videoID uuid.UUID) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.videoTags[videoID]...), nil
+}
+
+func (s *MemoryStore) ListTagCounts(userID uuid.UUID) ([]TagCount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byName := map[string]int{}
+	for videoID, tags := range s.videoTags {
+		video, ok := s.videos[videoID]
+		if !ok || video.UserID != userID || video.DeletedAt != nil {
+			continue
+		}
+		for _, tag := range tags {
+			byName[tag]++
+		}
+	}
+	counts := []TagCount{}
+	for name, count := range byName {
+		counts = append(counts, TagCount{Name: name, Count: count})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts, nil
+}
+
+func (s *MemoryStore) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if params.Visibility == "" {
+		params.Visibility = VisibilityPrivate
+	}
+	now := memoryNow()
+	p := Playlist{
+		ID:         uuid.New(),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		UserID:     params.UserID,
+		Title:      params.Title,
+		Visibility: params.Visibility,
+	}
+	s.playlists[p.ID] = p
+	return p, nil
+}
+
+func (s *MemoryStore) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.playlists[id], nil
+}
+
+func (s *MemoryStore) ListPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	playlists := []Playlist{}
+	for _, p := range s.playlists {
+		if p.UserID == userID {
+			playlists = append(playlists, p)
+		}
+	}
+	sort.Slice(playlists, func(i, j int) bool {
+		a, b := playlists[i], playlists[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID.String() > b.ID.String()
+	})
+	return playlists, nil
+}
+
+func (s *MemoryStore) UpdatePlaylist(p Playlist) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	existing, ok := s.playlists[p.ID]
+	if !ok {
+		return nil
+	}
+	existing.Title = p.Title
+	existing.Visibility = p.Visibility
+	existing.UpdatedAt = roundTripNow()
+	s.playlists[p.ID] = existing
+	return nil
+}
+
+func (s *MemoryStore) DeletePlaylist(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.playlists, id)
+	delete(s.playlistItems, id)
+	return nil
+}
+
+func (s *MemoryStore) GetPlaylistItems(playlistID uuid.UUID) ([]PlaylistItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := []PlaylistItem{}
+	for position, entry := range s.playlistItems[playlistID] {
+		video, ok := s.videos[entry.videoID]
+		if !ok || video.DeletedAt != nil {
+			continue
+		}
+		items = append(items, PlaylistItem{Position: position, AddedAt: entry.addedAt, Video: video})
+	}
+	return items, nil
+}
+
+// touchPlaylist bumps a playlist's updated_at like Client's
+// beginPlaylistChange. Callers must hold s.mu.
+func (s *MemoryStore) touchPlaylist(playlistID uuid.UUID) error {
+	p, ok := s.playlists[playlistID]
+	if !ok {
+		return ErrPlaylistNotFound
+	}
+	p.UpdatedAt = roundTripNow()
+	s.playlists[playlistID] = p
+	return nil
+}
+
+func (s *MemoryStore) playlistPosition(playlistID, videoID uuid.UUID) int {
+	return slices.IndexFunc(s.playlistItems[playlistID], func(e memoryPlaylistEntry) bool {
+		return e.videoID == videoID
+	})
+}
+
+func (s *MemoryStore) AddPlaylistItem(playlistID, videoID uuid.UUID, position int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.touchPlaylist(playlistID); err != nil {
+		return err
+	}
+	if s.playlistPosition(playlistID, videoID) >= 0 {
+		return ErrPlaylistItemExists
+	}
+	entries := s.playlistItems[playlistID]
+	position = clampPosition(position, len(entries))
+	s.playlistItems[playlistID] = slices.Insert(entries, position, memoryPlaylistEntry{
+		videoID: videoID,
+		addedAt: memoryNow(),
+	})
+	return nil
+}
+
+func (s *MemoryStore) MovePlaylistItem(playlistID, videoID uuid.UUID, position int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.touchPlaylist(playlistID); err != nil {
+		return err
+	}
+	from := s.playlistPosition(playlistID, videoID)
+	if from < 0 {
+		return ErrPlaylistItemNotFound
+	}
+	entries := s.playlistItems[playlistID]
+	entry := entries[from]
+	entries = slices.Delete(entries, from, from+1)
+	position = clampPosition(position, len(entries))
+	s.playlistItems[playlistID] = slices.Insert(entries, position, entry)
+	return nil
+}
+
+func (s *MemoryStore) RemovePlaylistItem(playlistID, videoID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.touchPlaylist(playlistID); err != nil {
+		return err
+	}
+	position := s.playlistPosition(playlistID, videoID)
+	if position < 0 {
+		return ErrPlaylistItemNotFound
+	}
+	s.playlistItems[playlistID] = slices.Delete(s.playlistItems[playlistID], position, position+1)
+	return nil
+}
+
+// deletePlaylistEntries takes a deleted video out of every playlist.
+// Callers must hold s.mu.
+func (s *MemoryStore) deletePlaylistEntries(videoID uuid.UUID) {
+	for playlistID, entries := range s.playlistItems {
+		s.playlistItems[playlistID] = slices.DeleteFunc(entries, func(e memoryPlaylistEntry) bool {
+			return e.videoID == videoID
+		})
+	}
+}
+
+func (s *MemoryStore) RecordView(videoID uuid.UUID, viewerKey string, window time.Duration) (View, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := memoryNow()
+	var latest *View
+	for _, view := range s.views {
+		if view.VideoID != videoID || view.ViewerKey != viewerKey || !view.CreatedAt.After(now.Add(-window)) {
+			continue
+		}
+		if latest == nil || view.CreatedAt.After(latest.CreatedAt) {
+			latest = &view
+		}
+	}
+	if latest != nil {
+		return *latest, false, nil
+	}
+
+	view := View{
+		ID:         uuid.New(),
+		VideoID:    videoID,
+		ViewerKey:  viewerKey,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	s.views[view.ID] = view
+	return view, true, nil
+}
+
+func (s *MemoryStore) GetView(id uuid.UUID) (View, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.views[id], nil
+}
+
+func (s *MemoryStore) RecordViewHeartbeat(id uuid.UUID, position float64, maxGap time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	view, ok := s.views[id]
+	if !ok {
+		return nil
+	}
+	s.views[id] = view.heartbeat(memoryNow(), position, maxGap)
+	return nil
+}
+
+func (s *MemoryStore) RollupViewStats(since time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from := statsDay(since)
+	records := []viewRecord{}
+	for _, view := range s.views {
+		video, ok := s.videos[view.VideoID]
+		if !ok || view.CreatedAt.Before(from) {
+			continue
+		}
+		records = append(records, viewRecord{View: view, Duration: video.Duration})
+	}
+	for _, rollup := range rollupViews(records) {
+		if s.viewRollups[rollup.VideoID] == nil {
+			s.viewRollups[rollup.VideoID] = map[int64]viewRollup{}
+		}
+		s.viewRollups[rollup.VideoID][rollup.Stats.Day.Unix()] = rollup
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetVideoStats(videoID uuid.UUID, from, to time.Time) (VideoStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fromDay, toDay := statsDay(from), statsDay(to)
+	stats := VideoStats{Daily: []DailyVideoStats{}}
+	var buckets [RetentionBuckets + 1]int
+	for _, rollup := range s.viewRollups[videoID] {
+		if rollup.Stats.Day.Before(fromDay) || rollup.Stats.Day.After(toDay) {
+			continue
+		}
+		stats.Daily = append(stats.Daily, rollup.Stats)
+		for b, views := range rollup.Buckets {
+			buckets[b] += views
+		}
+	}
+	sortDailyStats(stats.Daily)
+	stats.Retention = cumulativeRetention(buckets)
+
+	viewers := map[string]bool{}
+	for _, view := range s.views {
+		if view.VideoID == videoID && !view.CreatedAt.Before(fromDay) && view.CreatedAt.Before(toDay.AddDate(0, 0, 1)) {
+			viewers[view.ViewerKey] = true
+		}
+	}
+	stats.UniqueViewers = len(viewers)
+	return stats, nil
+}
+
+// deleteVideoViews drops a deleted video's views and stats. Callers must
+// hold s.mu.
+func (s *MemoryStore) deleteVideoViews(videoID uuid.UUID) {
+	for id, view := range s.views {
+		if view.VideoID == videoID {
+			delete(s.views, id)
+		}
+	}
+	delete(s.viewRollups, videoID)
+}
+
+// withReplyCount fills in a comment's ReplyCount. Callers must hold s.mu.
+func (s *MemoryStore) withReplyCount(comment Comment) Comment {
+	comment.ReplyCount = 0
+	for _, reply := range s.comments {
+		if reply.ParentID != nil && *reply.ParentID == comment.ID && !reply.Hidden {
+			comment.ReplyCount++
+		}
+	}
+	return comment
+}
+
+func (s *MemoryStore) CreateComment(params CreateCommentParams) (Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := roundTripNow()
+	s.commentSeq++
+	comment := Comment{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		VideoID:   params.VideoID,
+		UserID:    params.UserID,
+		ParentID:  params.ParentID,
+		Body:      params.Body,
+		Seq:       s.commentSeq,
+	}
+	s.comments[comment.ID] = comment
+	return comment, nil
+}
+
+func (s *MemoryStore) GetComment(id uuid.UUID) (Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	comment, ok := s.comments[id]
+	if !ok {
+		return Comment{}, nil
+	}
+	return s.withReplyCount(comment), nil
+}
+
+func (s *MemoryStore) GetPinnedComment(videoID uuid.UUID) (Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, comment := range s.comments {
+		if comment.VideoID == videoID && comment.Pinned {
+			return s.withReplyCount(comment), nil
+		}
+	}
+	return Comment{}, nil
+}
+
+func (s *MemoryStore) ListComments(params ListCommentsParams) (CommentPage, error) {
+	params = params.normalize()
+	var after *commentCursor
+	if params.Cursor != "" {
+		cur, err := decodeCommentCursor(params.Cursor)
+		if err != nil {
+			return CommentPage{}, err
+		}
+		after = &cur
+	}
+
+	// order returns a negative number when a comes before b in the
+	// listing.
+	order := func(a, b int64) int {
+		c := cmp.Compare(a, b)
+		if params.ParentID == uuid.Nil {
+			return -c
+		}
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	comments := []Comment{}
+	for _, comment := range s.comments {
+		if comment.VideoID != params.VideoID {
+			continue
+		}
+		if params.ParentID == uuid.Nil && (comment.ParentID != nil || comment.Pinned) {
+			continue
+		}
+		if params.ParentID != uuid.Nil && (comment.ParentID == nil || *comment.ParentID != params.ParentID) {
+			continue
+		}
+		if comment.Hidden && !params.IncludeHidden && comment.UserID != params.ViewerID {
+			continue
+		}
+		if after != nil && order(comment.Seq, after.Seq) <= 0 {
+			continue
+		}
+		comments = append(comments, s.withReplyCount(comment))
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return order(comments[i].Seq, comments[j].Seq) < 0
+	})
+	if len(comments) > params.Limit+1 {
+		comments = comments[:params.Limit+1]
+	}
+	return newCommentPage(params, comments), nil
+}
+
+func (s *MemoryStore) UpdateCommentBody(id uuid.UUID, body string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	comment, ok := s.comments[id]
+	if !ok {
+		return nil
+	}
+	comment.Body = body
+	comment.UpdatedAt = roundTripNow()
+	s.comments[id] = comment
+	return nil
+}
+
+func (s *MemoryStore) DeleteComment(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for replyID, reply := range s.comments {
+		if reply.ParentID != nil && *reply.ParentID == id {
+			delete(s.comments, replyID)
+		}
+	}
+	delete(s.comments, id)
+	return nil
+}
+
+func (s *MemoryStore) SetCommentHidden(id uuid.UUID, hidden bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	comment, ok := s.comments[id]
+	if !ok {
+		return nil
+	}
+	comment.Hidden = hidden
+	s.comments[id] = comment
+	return nil
+}
+
+func (s *MemoryStore) PinComment(videoID, commentID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, comment := range s.comments {
+		if comment.VideoID != videoID {
+			continue
+		}
+		pinned := id == commentID
+		if comment.Pinned != pinned {
+			comment.Pinned = pinned
+			s.comments[id] = comment
+		}
+	}
+	return nil
+}
+
+// deleteVideoComments drops every comment on a deleted video. Callers must
+// hold s.mu.
+func (s *MemoryStore) deleteVideoComments(videoID uuid.UUID) {
+	for id, comment := range s.comments {
+		if comment.VideoID == videoID {
+			delete(s.comments, id)
+		}
+	}
+}
+
+func (s *MemoryStore) SetReaction(userID, videoID uuid.UUID, reaction Reaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reactions[videoID] == nil {
+		s.reactions[videoID] = map[uuid.UUID]Reaction{}
+	}
+	s.reactions[videoID][userID] = reaction
+	return nil
+}
+
+func (s *MemoryStore) DeleteReaction(userID, videoID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reactions[videoID], userID)
+	return nil
+}
+
+func (s *MemoryStore) GetReactionSummaries(videoIDs []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID]ReactionSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	summaries := make(map[uuid.UUID]ReactionSummary, len(videoIDs))
+	for _, videoID := range videoIDs {
+		summary := newReactionSummary()
+		for userID, reaction := range s.reactions[videoID] {
+			summary.Counts[reaction]++
+			if userID == viewerID && viewerID != uuid.Nil {
+				mine := reaction
+				summary.Mine = &mine
+			}
+		}
+		summaries[videoID] = summary
+	}
+	return summaries, nil
+}
+
+func (s *MemoryStore) Subscribe(subscriberID, channelID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscriptions[subscriberID] == nil {
+		s.subscriptions[subscriberID] = map[uuid.UUID]time.Time{}
+	}
+	if _, ok := s.subscriptions[subscriberID][channelID]; !ok {
+		s.subscriptions[subscriberID][channelID] = memoryNow()
+	}
+	return nil
+}
+
+func (s *MemoryStore) Unsubscribe(subscriberID, channelID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions[subscriberID], channelID)
+	return nil
+}
+
+func (s *MemoryStore) IsSubscribed(subscriberID, channelID uuid.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.subscriptions[subscriberID][channelID]
+	return ok, nil
+}
+
+func (s *MemoryStore) CountSubscribers(channelID uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, channels := range s.subscriptions {
+		if _, ok := channels[channelID]; ok {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *MemoryStore) ListSubscriptions(subscriberID uuid.UUID) ([]Profile, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	profiles := []Profile{}
+	for channelID := range s.subscriptions[subscriberID] {
+		if p, ok := s.profiles[channelID]; ok {
+			profiles = append(profiles, p)
+		}
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Handle < profiles[j].Handle
+	})
+	return profiles, nil
+}
+
+func (s *MemoryStore) CreateAPIKey(params CreateAPIKeyParams) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.apiKeys {
+		if k.Prefix == params.Prefix {
+			return APIKey{}, errors.New("duplicate API key prefix")
+		}
+	}
+	k := APIKey{
+		ID:        uuid.New(),
+		CreatedAt: memoryNow(),
+		UserID:    params.UserID,
+		Name:      params.Name,
+		Prefix:    params.Prefix,
+		KeyHash:   params.KeyHash,
+		Scopes:    slices.Clone(params.Scopes),
+	}
+	s.apiKeys[k.ID] = k
+	return k, nil
+}
+
+func (s *MemoryStore) GetAPIKey(id uuid.UUID) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apiKeys[id], nil
+}
+
+func (s *MemoryStore) GetAPIKeyByPrefix(prefix string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.apiKeys {
+		if k.Prefix == prefix {
+			return k, nil
+		}
+	}
+	return APIKey{}, nil
+}
+
+func (s *MemoryStore) ListAPIKeys(userID uuid.UUID) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []APIKey{}
+	for _, k := range s.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.After(keys[j].CreatedAt)
+		}
+		return keys[i].ID.String() < keys[j].ID.String()
+	})
+	return keys, nil
+}
+
+func (s *MemoryStore) RenameAPIKey(id uuid.UUID, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.apiKeys[id]; ok {
+		k.Name = name
+		s.apiKeys[id] = k
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeAPIKey(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.apiKeys[id]; ok && k.RevokedAt == nil {
+		now := memoryNow()
+		k.RevokedAt = &now
+		s.apiKeys[id] = k
+	}
+	return nil
+}
+
+func (s *MemoryStore) MarkAPIKeyUsed(id uuid.UUID, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if k, ok := s.apiKeys[id]; ok {
+		at = at.UTC()
+		k.LastUsedAt = &at
+		s.apiKeys[id] = k
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetSystemStats() (SystemStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := SystemStats{
+		Users:                   len(s.users),
+		Views:                   len(s.views),
+		Comments:                len(s.comments),
+		PendingStorageDeletions: len(s.deletions),
+	}
+	for _, user := range s.users {
+		if user.DisabledAt != nil {
+			stats.DisabledUsers++
+		}
+		if user.Role == RoleAdmin {
+			stats.Admins++
+		}
+	}
+	for _, video := range s.videos {
+		if video.DeletedAt != nil {
+			stats.TrashedVideos++
+		} else {
+			stats.Videos++
+		}
+	}
+	return stats, nil
+}
+
+func (s *MemoryStore) GetTOTP(userID uuid.UUID) (TOTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.totp[userID]
+	if !ok {
+		return TOTP{}, nil
+	}
+	for _, used := range s.recoveryCodes[userID] {
+		if !used {
+			t.RecoveryCodesLeft++
+		}
+	}
+	return t, nil
+}
+
+func (s *MemoryStore) StartTOTPEnrollment(userID uuid.UUID, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.totp[userID]; ok && t.ConfirmedAt != nil {
+		return nil
+	}
+	s.totp[userID] = TOTP{UserID: userID, CreatedAt: roundTripNow(), Secret: secret}
+	return nil
+}
+
+func (s *MemoryStore) ConfirmTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.totp[userID]
+	if !ok {
+		return nil
+	}
+	now := roundTripNow()
+	t.ConfirmedAt = &now
+	t.LastUsedStep = step
+	s.totp[userID] = t
+	s.replaceRecoveryCodes(userID, recoveryCodeHashes)
+	return nil
+}
+
+func (s *MemoryStore) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.totp[userID]
+	if !ok || t.LastUsedStep >= step {
+		return false, nil
+	}
+	t.LastUsedStep = step
+	s.totp[userID] = t
+	return true, nil
+}
+
+func (s *MemoryStore) ReplaceRecoveryCodes(userID uuid.UUID, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replaceRecoveryCodes(userID, hashes)
+	return nil
+}
+
+// replaceRecoveryCodes is ReplaceRecoveryCodes for callers that already
+// hold the lock.
+func (s *MemoryStore) replaceRecoveryCodes(userID uuid.UUID, hashes []string) {
+	codes := map[string]bool{}
+	for _, hash := range hashes {
+		codes[hash] = false
+	}
+	s.recoveryCodes[userID] = codes
+}
+
+func (s *MemoryStore) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	used, ok := s.recoveryCodes[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+	s.recoveryCodes[userID][codeHash] = true
+	return true, nil
+}
+
+func (s *MemoryStore) RecordTOTPAttempt(userID uuid.UUID, at time.Time, maxFailures int, lockout time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.totp[userID]
+	if !ok {
+		return false, nil
+	}
+	at = at.UTC().Truncate(time.Microsecond)
+	if t.FailedAttempts >= maxFailures {
+		if t.LastFailedAt != nil && t.LastFailedAt.After(at.Add(-lockout)) {
+			return false, nil
+		}
+		t.FailedAttempts = 0
+	}
+	t.FailedAttempts++
+	t.LastFailedAt = &at
+	s.totp[userID] = t
+	return true, nil
+}
+
+func (s *MemoryStore) ClearTOTPFailures(userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.totp[userID]
+	if !ok {
+		return nil
+	}
+	t.FailedAttempts = 0
+	t.LastFailedAt = nil
+	s.totp[userID] = t
+	return nil
+}
+
+func (s *MemoryStore) DeleteTOTP(userID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.totp, userID)
+	delete(s.recoveryCodes, userID)
+	return nil
+}
+
+func (s *MemoryStore) CreateEmailToken(params CreateEmailTokenParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range s.emailTokens {
+		if t.UserID == params.UserID && t.Purpose == params.Purpose && !t.used {
+			delete(s.emailTokens, hash)
+		}
+	}
+	s.emailTokens[params.TokenHash] = memoryEmailToken{CreateEmailTokenParams: params}
+	return nil
+}
+
+func (s *MemoryStore) GetEmailTokenUser(purpose EmailTokenPurpose, tokenHash string) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.emailTokens[tokenHash]
+	if !ok || t.Purpose != purpose || t.used || !t.ExpiresAt.After(time.Now()) {
+		return uuid.Nil, nil
+	}
+	return t.UserID, nil
+}
+
+func (s *MemoryStore) UseEmailToken(purpose EmailTokenPurpose, tokenHash string) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.emailTokens[tokenHash]
+	if !ok || t.Purpose != purpose || t.used || !t.ExpiresAt.After(time.Now()) {
+		return uuid.Nil, nil
+	}
+	t.used = true
+	s.emailTokens[tokenHash] = t
+	return t.UserID, nil
+}
+
+func (s *MemoryStore) CreateLoginCode(params CreateLoginCodeParams) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for hash, code := range s.loginCodes {
+		if !code.ExpiresAt.After(now) {
+			delete(s.loginCodes, hash)
+		}
+	}
+	s.loginCodes[params.CodeHash] = params
+	return nil
+}
+
+func (s *MemoryStore) UseLoginCode(codeHash string) (uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	code, ok := s.loginCodes[codeHash]
+	if !ok {
+		return uuid.Nil, nil
+	}
+	delete(s.loginCodes, codeHash)
+	if !code.ExpiresAt.After(time.Now()) {
+		return uuid.Nil, nil
+	}
+	return code.UserID, nil
+}
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS videos_user_id_idx;
+
+ALTER TABLE videos
+	ALTER COLUMN description DROP DEFAULT,
+	ALTER COLUMN description DROP NOT NULL,
+	ALTER COLUMN user_id DROP NOT NULL,
+	DROP CONSTRAINT IF EXISTS videos_user_id_fkey,
+	ADD CONSTRAINT videos_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
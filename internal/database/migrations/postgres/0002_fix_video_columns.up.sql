@@ -0,0 +1,13 @@
+-- Bring videos in line with the SQLite rebuild: every video has an owner,
+-- descriptions are never NULL and videos go away with their owner.
+DELETE FROM videos WHERE user_id IS NULL;
+UPDATE videos SET description = '' WHERE description IS NULL;
+
+ALTER TABLE videos
+	ALTER COLUMN description SET DEFAULT '',
+	ALTER COLUMN description SET NOT NULL,
+	ALTER COLUMN user_id SET NOT NULL,
+	DROP CONSTRAINT IF EXISTS videos_user_id_fkey,
+	ADD CONSTRAINT videos_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX videos_user_id_idx ON videos(user_id);
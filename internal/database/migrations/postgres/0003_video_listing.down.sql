@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS videos_user_duration_idx;
+DROP INDEX IF EXISTS videos_user_title_idx;
+DROP INDEX IF EXISTS videos_user_updated_idx;
+DROP INDEX IF EXISTS videos_user_created_idx;
+
+ALTER TABLE videos DROP COLUMN duration;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS videos_search_idx;
+ALTER TABLE videos DROP COLUMN search_vector;
@@ -0,0 +1,8 @@
+-- Titles outrank descriptions; the generated column keeps the vector in sync
+-- with every insert and update.
+ALTER TABLE videos ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('english', coalesce(description, '')), 'B')
+) STORED;
+
+CREATE INDEX videos_search_idx ON videos USING GIN (search_vector);
@@ -0,0 +1,14 @@
+-- Files to remove from storage once their video is gone. Rows are deleted
+-- when the file is; failures are retried from next_attempt_at.
+CREATE TABLE storage_deletions (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	video_id UUID NOT NULL,
+	backend TEXT NOT NULL,
+	object_key TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL,
+	last_error TEXT
+);
+
+CREATE INDEX storage_deletions_next_attempt_idx ON storage_deletions(next_attempt_at);
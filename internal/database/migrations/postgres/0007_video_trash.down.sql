@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS videos_deleted_at_idx;
+
+ALTER TABLE videos DROP COLUMN deleted_at;
@@ -0,0 +1,10 @@
+-- Each user has at most one reaction to a video.
+CREATE TABLE reactions (
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	reaction TEXT NOT NULL CHECK (reaction IN ('like', 'dislike')),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id, video_id)
+);
+
+CREATE INDEX reactions_video_idx ON reactions(video_id, reaction);
@@ -0,0 +1,10 @@
+-- Public channel details. Users without a row have no channel.
+CREATE TABLE profiles (
+	user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	handle TEXT NOT NULL UNIQUE,
+	display_name TEXT NOT NULL DEFAULT '',
+	bio TEXT NOT NULL DEFAULT '',
+	avatar_url TEXT
+);
@@ -0,0 +1,16 @@
+-- Only a hash of each key is stored. prefix is the part of the key kept in
+-- the clear to find it again.
+CREATE TABLE api_keys (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL UNIQUE,
+	key_hash TEXT NOT NULL,
+	-- Space-separated scope names.
+	scopes TEXT NOT NULL,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX api_keys_user_idx ON api_keys(user_id);
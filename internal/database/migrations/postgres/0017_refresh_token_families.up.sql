@@ -0,0 +1,9 @@
+-- Refreshing replaces a token with a new one in the same family. Existing
+-- tokens each start a family of their own.
+ALTER TABLE refresh_tokens ADD COLUMN family_id UUID;
+
+UPDATE refresh_tokens SET family_id = gen_random_uuid();
+
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens(family_id);
@@ -0,0 +1,21 @@
+-- A user's TOTP secret. It's unconfirmed, and not asked for at login, until
+-- the user proves their authenticator works. last_used_step stops a code
+-- from being used twice, and failed_attempts throttles guessing.
+CREATE TABLE user_totp (
+	user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL,
+	secret TEXT NOT NULL,
+	confirmed_at TIMESTAMPTZ,
+	last_used_step BIGINT NOT NULL DEFAULT 0,
+	failed_attempts INTEGER NOT NULL DEFAULT 0,
+	last_failed_at TIMESTAMPTZ
+);
+
+-- Only a hash of each recovery code is stored.
+CREATE TABLE recovery_codes (
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	code_hash TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	PRIMARY KEY (user_id, code_hash)
+);
@@ -0,0 +1,22 @@
+-- Comments are listed in the order they were made, which created_at can't
+-- tell apart within the same microsecond. seq numbers them instead, from
+-- a sequence.
+ALTER TABLE comments ADD COLUMN seq BIGINT;
+CREATE SEQUENCE comments_seq OWNED BY comments.seq;
+
+UPDATE comments SET seq = numbered.n
+FROM (
+	SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS n FROM comments
+) numbered
+WHERE numbered.id = comments.id;
+
+SELECT setval('comments_seq', COALESCE((SELECT MAX(seq) FROM comments), 0) + 1, false);
+ALTER TABLE comments
+	ALTER COLUMN seq SET DEFAULT nextval('comments_seq'),
+	ALTER COLUMN seq SET NOT NULL;
+
+CREATE UNIQUE INDEX comments_seq_idx ON comments(seq);
+DROP INDEX comments_video_idx;
+DROP INDEX comments_parent_idx;
+CREATE INDEX comments_video_idx ON comments(video_id, parent_id, seq);
+CREATE INDEX comments_parent_idx ON comments(parent_id, seq);
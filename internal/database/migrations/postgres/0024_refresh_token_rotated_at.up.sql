@@ -0,0 +1,12 @@
+-- A refresh token is revoked both when it's rotated and when its session
+-- is logged out; only presenting a rotated one again is reuse. A token was
+-- rotated if its family gained a token the moment it was revoked.
+ALTER TABLE refresh_tokens ADD COLUMN rotated_at TIMESTAMPTZ;
+
+UPDATE refresh_tokens SET rotated_at = revoked_at
+WHERE revoked_at IS NOT NULL AND EXISTS (
+	SELECT 1 FROM refresh_tokens successor
+	WHERE successor.family_id = refresh_tokens.family_id
+		AND successor.token <> refresh_tokens.token
+		AND successor.created_at = refresh_tokens.revoked_at
+);
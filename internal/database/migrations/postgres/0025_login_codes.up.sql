@@ -0,0 +1,8 @@
+-- Single-use codes the web app trades for tokens after single sign-on, so
+-- the tokens are never put in a URL. Only a hash of each code is stored.
+CREATE TABLE login_codes (
+	code_hash TEXT PRIMARY KEY,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
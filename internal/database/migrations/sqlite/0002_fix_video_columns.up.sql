@@ -0,0 +1,24 @@
+-- SQLite can't change a column's type in place, so rebuild the table with
+-- user_id as a TEXT UUID and without the duplicated video_url type. Videos
+-- with no owner were unreachable through the API and are dropped.
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT NOT NULL DEFAULT '',
+	thumbnail_url TEXT,
+	video_url TEXT,
+	user_id TEXT NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+INSERT INTO videos_new (id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id)
+SELECT id, created_at, updated_at, title, COALESCE(description, ''), thumbnail_url, video_url, CAST(user_id AS TEXT)
+FROM videos
+WHERE user_id IS NOT NULL;
+
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
+
+CREATE INDEX videos_user_id_idx ON videos(user_id);
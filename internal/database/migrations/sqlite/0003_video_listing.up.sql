@@ -0,0 +1,8 @@
+-- Duration in seconds, 0 until a video file has been uploaded and probed.
+ALTER TABLE videos ADD COLUMN duration REAL NOT NULL DEFAULT 0;
+
+-- One index per sortable column; id breaks ties so cursors are stable.
+CREATE INDEX videos_user_created_idx ON videos(user_id, created_at, id);
+CREATE INDEX videos_user_updated_idx ON videos(user_id, updated_at, id);
+CREATE INDEX videos_user_title_idx ON videos(user_id, title, id);
+CREATE INDEX videos_user_duration_idx ON videos(user_id, duration, id);
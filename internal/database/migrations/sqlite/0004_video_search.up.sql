@@ -0,0 +1,4 @@
+-- The SQLite search index is an FTS5 table, which only exists when the
+-- driver is built with the sqlite_fts5 tag, so it's created at startup by
+-- ensureSearchIndex instead of here. This keeps versions in step with
+-- Postgres.
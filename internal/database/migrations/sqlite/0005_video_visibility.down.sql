@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS videos_visibility_created_idx;
+
+ALTER TABLE videos DROP COLUMN visibility;
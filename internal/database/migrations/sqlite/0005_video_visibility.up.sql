@@ -0,0 +1,6 @@
+-- Existing videos were only ever listed to their owners, so they start out
+-- private.
+ALTER TABLE videos ADD COLUMN visibility TEXT NOT NULL DEFAULT 'private'
+	CHECK (visibility IN ('private', 'unlisted', 'public'));
+
+CREATE INDEX videos_visibility_created_idx ON videos(visibility, created_at, id);
@@ -0,0 +1,5 @@
+-- Deleted videos stay in the trash until deleted_at is older than the
+-- retention period.
+ALTER TABLE videos ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX videos_deleted_at_idx ON videos(deleted_at);
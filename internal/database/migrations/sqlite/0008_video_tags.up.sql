@@ -0,0 +1,12 @@
+CREATE TABLE tags (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE video_tags (
+	video_id TEXT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	tag_id TEXT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY (video_id, tag_id)
+);
+
+CREATE INDEX video_tags_tag_idx ON video_tags(tag_id, video_id);
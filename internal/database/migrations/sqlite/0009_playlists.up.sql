@@ -0,0 +1,24 @@
+CREATE TABLE playlists (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	title TEXT NOT NULL,
+	visibility TEXT NOT NULL DEFAULT 'private'
+		CHECK (visibility IN ('private', 'unlisted', 'public'))
+);
+
+CREATE INDEX playlists_user_idx ON playlists(user_id, created_at);
+
+-- Positions within a playlist run from 0 without gaps; they're kept that
+-- way by the code that adds, moves and removes items.
+CREATE TABLE playlist_items (
+	playlist_id TEXT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+	video_id TEXT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	added_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (playlist_id, video_id)
+);
+
+CREATE INDEX playlist_items_position_idx ON playlist_items(playlist_id, position);
+CREATE INDEX playlist_items_video_idx ON playlist_items(video_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS video_daily_retention;
+DROP TABLE IF EXISTS video_daily_stats;
+DROP TABLE IF EXISTS video_views;
@@ -0,0 +1,34 @@
+-- One row per counted view. Heartbeats from the player add to
+-- watch_seconds and push max_position forward.
+CREATE TABLE video_views (
+	id TEXT PRIMARY KEY,
+	video_id TEXT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	viewer_key TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	last_seen_at TIMESTAMP NOT NULL,
+	watch_seconds REAL NOT NULL DEFAULT 0,
+	max_position REAL NOT NULL DEFAULT 0
+);
+
+CREATE INDEX video_views_viewer_idx ON video_views(video_id, viewer_key, created_at);
+CREATE INDEX video_views_created_idx ON video_views(created_at);
+
+-- Daily rollups of video_views, rebuilt from it for recent days.
+CREATE TABLE video_daily_stats (
+	video_id TEXT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	day DATE NOT NULL,
+	views INTEGER NOT NULL,
+	unique_viewers INTEGER NOT NULL,
+	watch_seconds REAL NOT NULL,
+	PRIMARY KEY (video_id, day)
+);
+
+-- How many of a day's views got at most bucket tenths of the way through
+-- the video; bucket 10 means they reached the end.
+CREATE TABLE video_daily_retention (
+	video_id TEXT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	day DATE NOT NULL,
+	bucket INTEGER NOT NULL,
+	views INTEGER NOT NULL,
+	PRIMARY KEY (video_id, day, bucket)
+);
@@ -0,0 +1,16 @@
+-- Comments on videos. Replies point at a top-level comment through
+-- parent_id; replies to replies aren't allowed.
+CREATE TABLE comments (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	video_id TEXT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	parent_id TEXT REFERENCES comments(id) ON DELETE CASCADE,
+	body TEXT NOT NULL,
+	hidden BOOLEAN NOT NULL DEFAULT FALSE,
+	pinned BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX comments_video_idx ON comments(video_id, parent_id, created_at, id);
+CREATE INDEX comments_parent_idx ON comments(parent_id, created_at, id);
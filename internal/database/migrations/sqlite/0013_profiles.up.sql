@@ -0,0 +1,10 @@
+-- Public channel details. Users without a row have no channel.
+CREATE TABLE profiles (
+	user_id TEXT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	updated_at TIMESTAMP NOT NULL,
+	handle TEXT NOT NULL UNIQUE,
+	display_name TEXT NOT NULL DEFAULT '',
+	bio TEXT NOT NULL DEFAULT '',
+	avatar_url TEXT
+);
@@ -0,0 +1,9 @@
+-- subscriber_id follows the channel of channel_id.
+CREATE TABLE subscriptions (
+	subscriber_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	channel_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (subscriber_id, channel_id)
+);
+
+CREATE INDEX subscriptions_channel_idx ON subscriptions(channel_id);
@@ -0,0 +1,6 @@
+-- Everybody could upload before roles existed, so existing users become
+-- creators.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'creator'
+	CHECK (role IN ('viewer', 'creator', 'admin'));
+
+ALTER TABLE users ADD COLUMN disabled_at TIMESTAMP;
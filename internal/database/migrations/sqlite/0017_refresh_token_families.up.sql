@@ -0,0 +1,11 @@
+-- Refreshing replaces a token with a new one in the same family. Existing
+-- tokens each start a family of their own.
+ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT;
+
+UPDATE refresh_tokens
+SET family_id = lower(
+	hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-' || hex(randomblob(2)) || '-' ||
+	hex(randomblob(2)) || '-' || hex(randomblob(6))
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens(family_id);
@@ -0,0 +1,4 @@
+DROP INDEX refresh_tokens_user_idx;
+
+ALTER TABLE refresh_tokens DROP COLUMN ip_address;
+ALTER TABLE refresh_tokens DROP COLUMN user_agent;
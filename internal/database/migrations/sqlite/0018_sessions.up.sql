@@ -0,0 +1,6 @@
+-- A session is a refresh token family. These describe the client that
+-- last refreshed it; rotation copies them forward.
+ALTER TABLE refresh_tokens ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX refresh_tokens_user_idx ON refresh_tokens(user_id);
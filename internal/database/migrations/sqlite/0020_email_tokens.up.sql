@@ -0,0 +1,15 @@
+-- Existing users are left unverified: nobody has checked their addresses.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+
+-- Single-use tokens emailed to users to verify their address or reset
+-- their password. Only a hash of each token is stored.
+CREATE TABLE email_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	purpose TEXT NOT NULL CHECK (purpose IN ('verify_email', 'reset_password')),
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP
+);
+
+CREATE INDEX email_tokens_user_idx ON email_tokens (user_id, purpose);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS videos_thumbnail_url_idx;
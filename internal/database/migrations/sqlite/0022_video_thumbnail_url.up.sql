@@ -0,0 +1,3 @@
+-- Thumbnails are served only to those who may see their video, which is
+-- looked up by the thumbnail's URL.
+CREATE INDEX videos_thumbnail_url_idx ON videos (thumbnail_url);
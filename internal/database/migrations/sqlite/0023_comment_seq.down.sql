@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS comments_seq_idx;
+DROP INDEX IF EXISTS comments_video_idx;
+DROP INDEX IF EXISTS comments_parent_idx;
+CREATE INDEX comments_video_idx ON comments(video_id, parent_id, created_at, id);
+CREATE INDEX comments_parent_idx ON comments(parent_id, created_at, id);
+ALTER TABLE comments DROP COLUMN seq;
@@ -0,0 +1,18 @@
+-- Comments are listed in the order they were made, which created_at can't
+-- tell apart within the same microsecond. seq numbers them instead; SQLite
+-- runs one write at a time, so new comments take the next number when
+-- they're inserted.
+ALTER TABLE comments ADD COLUMN seq INTEGER;
+
+UPDATE comments SET seq = (
+	SELECT n FROM (
+		SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, id) AS n FROM comments
+	) numbered
+	WHERE numbered.id = comments.id
+);
+
+CREATE UNIQUE INDEX comments_seq_idx ON comments(seq);
+DROP INDEX comments_video_idx;
+DROP INDEX comments_parent_idx;
+CREATE INDEX comments_video_idx ON comments(video_id, parent_id, seq);
+CREATE INDEX comments_parent_idx ON comments(parent_id, seq);
@@ -0,0 +1,375 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPlaylistNotFound     = errors.New("playlist not found")
+	ErrPlaylistItemExists   = errors.New("video is already in the playlist")
+	ErrPlaylistItemNotFound = errors.New("video is not in the playlist")
+)
+
+type Playlist struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	UserID     uuid.UUID  `json:"user_id"`
+	Title      string     `json:"title"`
+	Visibility Visibility `json:"visibility"`
+}
+
+type CreatePlaylistParams struct {
+	UserID     uuid.UUID
+	Title      string
+	Visibility Visibility
+}
+
+// PlaylistItem is a video at its position in a playlist. Videos in the
+// trash keep their position but aren't returned, so positions may skip.
+type PlaylistItem struct {
+	Position int       `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Video    Video     `json:"video"`
+}
+
+// VisibleTo reports whether userID, which is uuid.Nil for anonymous
+// requests, may see the playlist. The videos in it have their own
+// visibility on top of this.
+func (p Playlist) VisibleTo(userID uuid.UUID) bool {
+	if p.Visibility != VisibilityPrivate {
+		return true
+	}
+	return userID != uuid.Nil && userID == p.UserID
+}
+
+const playlistColumns = `
+	id,
+	created_at,
+	updated_at,
+	user_id,
+	title,
+	visibility
+`
+
+func scanPlaylist(row rowScanner) (Playlist, error) {
+	var p Playlist
+	err := row.Scan(&p.ID, &p.CreatedAt, &p.UpdatedAt, &p.UserID, &p.Title, &p.Visibility)
+	return p, err
+}
+
+func (c Client) CreatePlaylist(params CreatePlaylistParams) (Playlist, error) {
+	id := uuid.New()
+	if params.Visibility == "" {
+		params.Visibility = VisibilityPrivate
+	}
+	query := `
+	INSERT INTO playlists (id, created_at, updated_at, user_id, title, visibility)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	`
+	_, err := c.exec(query, id, params.UserID, params.Title, params.Visibility)
+	if err != nil {
+		return Playlist{}, err
+	}
+	return c.GetPlaylist(id)
+}
+
+func (c Client) GetPlaylist(id uuid.UUID) (Playlist, error) {
+	query := `
+	SELECT` + playlistColumns + `
+	FROM playlists
+	WHERE id = ?
+	`
+	p, err := scanPlaylist(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Playlist{}, nil
+		}
+		return Playlist{}, err
+	}
+	return p, nil
+}
+
+// ListPlaylists returns a user's playlists, newest first.
+func (c Client) ListPlaylists(userID uuid.UUID) ([]Playlist, error) {
+	query := `
+	SELECT` + playlistColumns + `
+	FROM playlists
+	WHERE user_id = ?
+	ORDER BY created_at DESC, id DESC
+	`
+	rows, err := c.query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	playlists := []Playlist{}
+	for rows.Next() {
+		p, err := scanPlaylist(rows)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, p)
+	}
+	return playlists, rows.Err()
+}
+
+// UpdatePlaylist saves a playlist's title and visibility.
+func (c Client) UpdatePlaylist(p Playlist) error {
+	query := `
+	UPDATE playlists
+	SET title = ?, visibility = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.exec(query, p.Title, p.Visibility, c.dialect.timeArg(roundTripNow()), p.ID)
+	return err
+}
+
+func (c Client) DeletePlaylist(id uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(c.dialect.rebind("DELETE FROM playlist_items WHERE playlist_id = ?"), id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind("DELETE FROM playlists WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPlaylistItems returns the videos in a playlist in order.
+func (c Client) GetPlaylistItems(playlistID uuid.UUID) ([]PlaylistItem, error) {
+	query := `
+	SELECT pi.position, pi.added_at,` + prefixColumns("v", videoColumns) + `
+	FROM playlist_items pi
+	JOIN videos v ON v.id = pi.video_id
+	WHERE pi.playlist_id = ? AND v.deleted_at IS NULL
+	ORDER BY pi.position
+	`
+	rows, err := c.query(query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []PlaylistItem{}
+	for rows.Next() {
+		var item PlaylistItem
+		err := rows.Scan(append([]any{&item.Position, &item.AddedAt}, videoFields(&item.Video)...)...)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// beginPlaylistChange starts a transaction that changes a playlist's items.
+// Bumping updated_at first locks the playlist row, so concurrent changes to
+// the same playlist can't interleave their position updates.
+func (c Client) beginPlaylistChange(playlistID uuid.UUID) (*sql.Tx, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	result, err := tx.Exec(
+		c.dialect.rebind("UPDATE playlists SET updated_at = ? WHERE id = ?"),
+		c.dialect.timeArg(roundTripNow()), playlistID,
+	)
+	if err == nil {
+		var n int64
+		n, err = result.RowsAffected()
+		if err == nil && n == 0 {
+			err = ErrPlaylistNotFound
+		}
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (c Client) playlistLength(tx *sql.Tx, playlistID uuid.UUID) (int, error) {
+	var n int
+	err := tx.QueryRow(c.dialect.rebind("SELECT COUNT(*) FROM playlist_items WHERE playlist_id = ?"), playlistID).Scan(&n)
+	return n, err
+}
+
+// playlistPosition returns where a video sits in a playlist, or
+// ErrPlaylistItemNotFound.
+func (c Client) playlistPosition(tx *sql.Tx, playlistID, videoID uuid.UUID) (int, error) {
+	var position int
+	err := tx.QueryRow(
+		c.dialect.rebind("SELECT position FROM playlist_items WHERE playlist_id = ? AND video_id = ?"),
+		playlistID, videoID,
+	).Scan(&position)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrPlaylistItemNotFound
+	}
+	return position, err
+}
+
+// clampPosition limits position to [0, max]; negative positions mean max.
+func clampPosition(position, max int) int {
+	if position < 0 || position > max {
+		return max
+	}
+	return position
+}
+
+// AddPlaylistItem inserts a video at position, shifting later items down.
+// A negative or out of range position appends the video.
+func (c Client) AddPlaylistItem(playlistID, videoID uuid.UUID, position int) error {
+	tx, err := c.beginPlaylistChange(playlistID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = c.playlistPosition(tx, playlistID, videoID)
+	if err == nil {
+		return ErrPlaylistItemExists
+	}
+	if !errors.Is(err, ErrPlaylistItemNotFound) {
+		return err
+	}
+	n, err := c.playlistLength(tx, playlistID)
+	if err != nil {
+		return err
+	}
+	position = clampPosition(position, n)
+
+	_, err = tx.Exec(c.dialect.rebind(`
+	UPDATE playlist_items SET position = position + 1
+	WHERE playlist_id = ? AND position >= ?
+	`), playlistID, position)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind(`
+	INSERT INTO playlist_items (playlist_id, video_id, position, added_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`), playlistID, videoID, position)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MovePlaylistItem moves a video to position, shifting the items in between.
+// A negative or out of range position moves it to the end.
+func (c Client) MovePlaylistItem(playlistID, videoID uuid.UUID, position int) error {
+	tx, err := c.beginPlaylistChange(playlistID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	from, err := c.playlistPosition(tx, playlistID, videoID)
+	if err != nil {
+		return err
+	}
+	n, err := c.playlistLength(tx, playlistID)
+	if err != nil {
+		return err
+	}
+	to := clampPosition(position, n-1)
+
+	switch {
+	case to < from:
+		_, err = tx.Exec(c.dialect.rebind(`
+		UPDATE playlist_items SET position = position + 1
+		WHERE playlist_id = ? AND position >= ? AND position < ?
+		`), playlistID, to, from)
+	case to > from:
+		_, err = tx.Exec(c.dialect.rebind(`
+		UPDATE playlist_items SET position = position - 1
+		WHERE playlist_id = ? AND position > ? AND position <= ?
+		`), playlistID, from, to)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind(`
+	UPDATE playlist_items SET position = ?
+	WHERE playlist_id = ? AND video_id = ?
+	`), to, playlistID, videoID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemovePlaylistItem takes a video out of a playlist, closing the gap it
+// leaves.
+func (c Client) RemovePlaylistItem(playlistID, videoID uuid.UUID) error {
+	tx, err := c.beginPlaylistChange(playlistID)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := c.removePlaylistItem(tx, playlistID, videoID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) removePlaylistItem(tx *sql.Tx, playlistID, videoID uuid.UUID) error {
+	position, err := c.playlistPosition(tx, playlistID, videoID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind(`
+	DELETE FROM playlist_items WHERE playlist_id = ? AND video_id = ?
+	`), playlistID, videoID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind(`
+	UPDATE playlist_items SET position = position - 1
+	WHERE playlist_id = ? AND position > ?
+	`), playlistID, position)
+	return err
+}
+
+// deletePlaylistEntries takes a deleted video out of every playlist it's
+// in.
+func (c Client) deletePlaylistEntries(tx *sql.Tx, videoID uuid.UUID) error {
+	rows, err := tx.Query(c.dialect.rebind("SELECT playlist_id FROM playlist_items WHERE video_id = ?"), videoID)
+	if err != nil {
+		return err
+	}
+	playlistIDs := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		playlistIDs = append(playlistIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, playlistID := range playlistIDs {
+		if err := c.removePlaylistItem(tx, playlistID, videoID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrHandleTaken = errors.New("handle is already taken")
+
+// Profile is the public face of a user's channel. Users without one have no
+// channel.
+type Profile struct {
+	UserID      uuid.UUID `json:"user_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   *string   `json:"avatar_url"`
+}
+
+// NormalizeHandle is how handles are stored and looked up: trimmed, without
+// a leading @, and in lower case.
+func NormalizeHandle(handle string) string {
+	handle = strings.TrimSpace(handle)
+	handle = strings.TrimPrefix(handle, "@")
+	return strings.ToLower(handle)
+}
+
+const profileColumns = `
+	user_id,
+	created_at,
+	updated_at,
+	handle,
+	display_name,
+	bio,
+	avatar_url
+`
+
+func scanProfile(row rowScanner) (Profile, error) {
+	var p Profile
+	err := row.Scan(&p.UserID, &p.CreatedAt, &p.UpdatedAt, &p.Handle, &p.DisplayName, &p.Bio, &p.AvatarURL)
+	return p, err
+}
+
+func (c Client) GetProfile(userID uuid.UUID) (Profile, error) {
+	return c.getProfile("user_id = ?", userID)
+}
+
+func (c Client) GetProfileByHandle(handle string) (Profile, error) {
+	return c.getProfile("handle = ?", NormalizeHandle(handle))
+}
+
+func (c Client) getProfile(condition string, arg any) (Profile, error) {
+	query := `
+	SELECT` + profileColumns + `
+	FROM profiles
+	WHERE ` + condition
+	p, err := scanProfile(c.queryRow(query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Profile{}, nil
+		}
+		return Profile{}, err
+	}
+	return p, nil
+}
+
+// SaveProfile creates or replaces the profile of p.UserID and returns it as
+// stored. It returns ErrHandleTaken if another user already has the handle.
+func (c Client) SaveProfile(p Profile) (Profile, error) {
+	query := `
+	INSERT INTO profiles (user_id, created_at, updated_at, handle, display_name, bio, avatar_url)
+	VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE SET
+		updated_at = excluded.updated_at,
+		handle = excluded.handle,
+		display_name = excluded.display_name,
+		bio = excluded.bio,
+		avatar_url = excluded.avatar_url
+	`
+	_, err := c.exec(query, p.UserID, NormalizeHandle(p.Handle), p.DisplayName, p.Bio, p.AvatarURL)
+	if isUniqueViolation(err) {
+		return Profile{}, ErrHandleTaken
+	}
+	if err != nil {
+		return Profile{}, err
+	}
+	return c.GetProfile(p.UserID)
+}
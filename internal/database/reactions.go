@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+type Reaction string
+
+const (
+	ReactionLike    Reaction = "like"
+	ReactionDislike Reaction = "dislike"
+)
+
+// Reactions lists every kind of reaction, in the order clients show them.
+var Reactions = []Reaction{ReactionLike, ReactionDislike}
+
+func (r Reaction) Valid() bool {
+	switch r {
+	case ReactionLike, ReactionDislike:
+		return true
+	}
+	return false
+}
+
+// ReactionSummary is how a video has been reacted to, as seen by one
+// viewer.
+type ReactionSummary struct {
+	// Counts has an entry for every kind of reaction, zero or not.
+	Counts map[Reaction]int `json:"counts"`
+	// Mine is the viewer's own reaction, if they have one.
+	Mine *Reaction `json:"mine"`
+}
+
+func newReactionSummary() ReactionSummary {
+	counts := make(map[Reaction]int, len(Reactions))
+	for _, r := range Reactions {
+		counts[r] = 0
+	}
+	return ReactionSummary{Counts: counts}
+}
+
+// SetReaction records a user's reaction to a video, replacing any earlier
+// one.
+func (c Client) SetReaction(userID, videoID uuid.UUID, reaction Reaction) error {
+	_, err := c.exec(`
+	INSERT INTO reactions (user_id, video_id, reaction, created_at)
+	VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (user_id, video_id) DO UPDATE SET
+		reaction = excluded.reaction,
+		created_at = excluded.created_at
+	`, userID, videoID, reaction)
+	return err
+}
+
+func (c Client) DeleteReaction(userID, videoID uuid.UUID) error {
+	_, err := c.exec("DELETE FROM reactions WHERE user_id = ? AND video_id = ?", userID, videoID)
+	return err
+}
+
+// GetReactionSummaries summarizes the reactions to several videos in one
+// query. viewerID's own reactions are filled in unless it's uuid.Nil.
+// Every video gets a summary, even without reactions.
+func (c Client) GetReactionSummaries(videoIDs []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID]ReactionSummary, error) {
+	summaries := make(map[uuid.UUID]ReactionSummary, len(videoIDs))
+	if len(videoIDs) == 0 {
+		return summaries, nil
+	}
+
+	args := []any{viewerID}
+	placeholders := make([]string, len(videoIDs))
+	for i, id := range videoIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+		summaries[id] = newReactionSummary()
+	}
+	query := `
+	SELECT video_id, reaction, COUNT(*), MAX(CASE WHEN user_id = ? THEN 1 ELSE 0 END)
+	FROM reactions
+	WHERE video_id IN (` + strings.Join(placeholders, ", ") + `)
+	GROUP BY video_id, reaction
+	`
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			videoID  uuid.UUID
+			reaction Reaction
+			count    int
+			mine     int
+		)
+		if err := rows.Scan(&videoID, &reaction, &count, &mine); err != nil {
+			return nil, err
+		}
+		summary := summaries[videoID]
+		summary.Counts[reaction] = count
+		if mine == 1 && viewerID != uuid.Nil {
+			summary.Mine = &reaction
+		}
+		summaries[videoID] = summary
+	}
+	return summaries, rows.Err()
+}
+
+// deleteVideoReactions removes every reaction to a video.
+func (c Client) deleteVideoReactions(tx *sql.Tx, videoID uuid.UUID) error {
+	_, err := tx.Exec(c.dialect.rebind("DELETE FROM reactions WHERE video_id = ?"), videoID)
+	return err
+}
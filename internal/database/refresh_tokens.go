@@ -2,24 +2,70 @@ package database
 
 import (
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+var (
+	// ErrRefreshTokenInvalid means a refresh token doesn't exist, has
+	// expired or was revoked by logging out.
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	// ErrRefreshTokenReused means a refresh token that had already been
+	// rotated was presented again. Its whole family is revoked, since
+	// either the client or whoever copied the token is an impostor.
+	ErrRefreshTokenReused = errors.New("refresh token was already used")
+)
+
 type RefreshToken struct {
 	CreateRefreshTokenParams
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt time.Time  `json:"updated_at"`
 	RevokedAt *time.Time `json:"revoked_at"`
+	// RotatedAt is when the token was replaced by the next in its family.
+	// A rotated token is also revoked.
+	RotatedAt *time.Time `json:"rotated_at"`
+	// FamilyID is shared by a login's first refresh token and every token
+	// rotated from it.
+	FamilyID uuid.UUID `json:"family_id"`
 }
 
 type CreateRefreshTokenParams struct {
 	Token     string    `json:"token"`
 	UserID    uuid.UUID `json:"user_id"`
 	ExpiresAt time.Time `json:"expires_at"`
+	SessionClient
+}
+
+// SessionClient describes the client a refresh token was issued to. A
+// rotated token records the client that refreshed, not the one its
+// predecessor was issued to, so a session shows whoever last refreshed it.
+type SessionClient struct {
+	UserAgent string `json:"user_agent"`
+	IPAddress string `json:"ip_address"`
+}
+
+const refreshTokenColumns = `
+	token,
+	created_at,
+	updated_at,
+	user_id,
+	expires_at,
+	revoked_at,
+	rotated_at,
+	family_id,
+	user_agent,
+	ip_address
+`
+
+func scanRefreshToken(row rowScanner) (RefreshToken, error) {
+	var rt RefreshToken
+	err := row.Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &rt.UserID, &rt.ExpiresAt, &rt.RevokedAt, &rt.RotatedAt, &rt.FamilyID, &rt.UserAgent, &rt.IPAddress)
+	return rt, err
 }
 
+// CreateRefreshToken saves the first refresh token of a new family.
 func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
 	query := `
 		INSERT INTO refresh_tokens (
@@ -27,10 +73,16 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 			created_at,
 			updated_at,
 			user_id,
-			expires_at
-		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
+			expires_at,
+			family_id,
+			user_agent,
+			ip_address
+		) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, params.Token, params.UserID.String(), params.ExpiresAt)
+	_, err := c.exec(query,
+		params.Token, params.UserID.String(), c.dialect.timeArg(params.ExpiresAt), uuid.New(),
+		params.UserAgent, params.IPAddress,
+	)
 	if err != nil {
 		return RefreshToken{}, err
 	}
@@ -38,26 +90,106 @@ func (c Client) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToke
 	return c.GetRefreshToken(params.Token)
 }
 
+// RotateRefreshToken revokes oldToken and saves newToken in its place, in
+// the same family, issued to client. It returns ErrRefreshTokenReused,
+// after revoking the family, if oldToken had already been rotated, and
+// ErrRefreshTokenInvalid if it was revoked otherwise.
+func (c Client) RotateRefreshToken(oldToken, newToken string, expiresAt time.Time, client SessionClient) (RefreshToken, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	defer tx.Rollback()
+
+	now := c.dialect.timeArg(time.Now())
+	selectOld := c.dialect.rebind(`
+		SELECT` + refreshTokenColumns + `
+		FROM refresh_tokens
+		WHERE token = ?
+	`)
+	old, err := scanRefreshToken(tx.QueryRow(selectOld, oldToken))
+	if errors.Is(err, sql.ErrNoRows) {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return RefreshToken{}, err
+	}
+
+	reused := func() (RefreshToken, error) {
+		_, err := tx.Exec(c.dialect.rebind(`
+			UPDATE refresh_tokens
+			SET revoked_at = ?, updated_at = ?
+			WHERE family_id = ? AND revoked_at IS NULL
+		`), now, now, old.FamilyID)
+		if err != nil {
+			return RefreshToken{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return RefreshToken{}, err
+		}
+		return RefreshToken{}, ErrRefreshTokenReused
+	}
+	if old.RotatedAt != nil {
+		return reused()
+	}
+	if old.RevokedAt != nil || !old.ExpiresAt.After(time.Now()) {
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+
+	// The revoked_at check makes a concurrent rotation or revocation of
+	// the same token win; the token is then read again to tell which.
+	result, err := tx.Exec(c.dialect.rebind(`
+		UPDATE refresh_tokens
+		SET revoked_at = ?, rotated_at = ?, updated_at = ?
+		WHERE token = ? AND revoked_at IS NULL
+	`), now, now, now, oldToken)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return RefreshToken{}, err
+	} else if n == 0 {
+		old, err = scanRefreshToken(tx.QueryRow(selectOld, oldToken))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, err
+		}
+		if old.RotatedAt != nil {
+			return reused()
+		}
+		return RefreshToken{}, ErrRefreshTokenInvalid
+	}
+
+	_, err = tx.Exec(c.dialect.rebind(`
+		INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, family_id, user_agent, ip_address)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`), newToken, now, now, old.UserID, c.dialect.timeArg(expiresAt), old.FamilyID, client.UserAgent, client.IPAddress)
+	if err != nil {
+		return RefreshToken{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return RefreshToken{}, err
+	}
+
+	return c.GetRefreshToken(newToken)
+}
+
 func (c Client) RevokeRefreshToken(token string) error {
 	query := `
 		UPDATE refresh_tokens
 		SET revoked_at = CURRENT_TIMESTAMP
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.exec(query, token)
 	return err
 }
 
 func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 	query := `
-		SELECT token, created_at, updated_at, user_id, expires_at, revoked_at
+		SELECT` + refreshTokenColumns + `
 		FROM refresh_tokens
 		WHERE token = ?
 	`
-	var rt RefreshToken
-	var userID string
-	err := c.db.QueryRow(query, token).
-		Scan(&rt.Token, &rt.CreatedAt, &rt.UpdatedAt, &userID, &rt.ExpiresAt, &rt.RevokedAt)
+	rt, err := scanRefreshToken(c.queryRow(query, token))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return RefreshToken{}, nil
@@ -65,11 +197,6 @@ func (c Client) GetRefreshToken(token string) (RefreshToken, error) {
 		return RefreshToken{}, err
 	}
 
-	rt.UserID, err = uuid.Parse(userID)
-	if err != nil {
-		return RefreshToken{}, err
-	}
-
 	return rt, nil
 }
 
@@ -78,6 +205,18 @@ func (c Client) DeleteRefreshToken(token string) error {
 		DELETE FROM refresh_tokens
 		WHERE token = ?
 	`
-	_, err := c.db.Exec(query, token)
+	_, err := c.exec(query, token)
+	return err
+}
+
+// RevokeUserRefreshTokens revokes every refresh token the user still has,
+// logging them out once their access tokens expire.
+func (c Client) RevokeUserRefreshTokens(userID uuid.UUID) error {
+	query := `
+		UPDATE refresh_tokens
+		SET revoked_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.exec(query, userID.String())
 	return err
 }
@@ -0,0 +1,410 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+	maxSearchTerms     = 16
+
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+	snippetWords   = 12
+)
+
+var ErrEmptySearchQuery = errors.New("search query has no searchable terms")
+
+type SearchVideosParams struct {
+	UserID uuid.UUID
+	// Query is a list of words, all of which must match. "Quoted words"
+	// must appear as a phrase and a trailing * matches any word with that
+	// prefix.
+	Query  string
+	Limit  int
+	Offset int
+}
+
+type VideoSearchResult struct {
+	Video
+	// Snippet is an excerpt of the best matching field with the matches
+	// wrapped in <mark> tags. The rest of the text is not HTML-escaped.
+	Snippet string `json:"snippet"`
+	// Rank orders results, higher is better. It's only meaningful relative
+	// to the other results of the same search.
+	Rank float64 `json:"rank"`
+}
+
+type searchTerm struct {
+	// words holds more than one word only for phrases.
+	words  []string
+	prefix bool
+}
+
+// parseSearchQuery splits q into terms. Punctuation inside a term splits
+// it into separate words, so nothing from q reaches a MATCH expression
+// unescaped.
+func parseSearchQuery(q string) []searchTerm {
+	terms := []searchTerm{}
+	for i, part := range strings.Split(q, `"`) {
+		if i%2 == 1 {
+			if words := searchWords(part); len(words) > 0 {
+				terms = append(terms, searchTerm{words: words})
+			}
+			continue
+		}
+		for _, field := range strings.Fields(part) {
+			prefix := strings.HasSuffix(field, "*")
+			words := searchWords(field)
+			for j, word := range words {
+				terms = append(terms, searchTerm{
+					words:  []string{word},
+					prefix: prefix && j == len(words)-1,
+				})
+			}
+		}
+	}
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+	return terms
+}
+
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (p SearchVideosParams) normalize() (SearchVideosParams, []searchTerm, error) {
+	terms := parseSearchQuery(p.Query)
+	if len(terms) == 0 {
+		return p, nil, ErrEmptySearchQuery
+	}
+	if p.Limit <= 0 {
+		p.Limit = DefaultSearchLimit
+	}
+	if p.Limit > MaxSearchLimit {
+		p.Limit = MaxSearchLimit
+	}
+	if p.Offset < 0 {
+		p.Offset = 0
+	}
+	return p, terms, nil
+}
+
+// fts5Query renders terms as an FTS5 MATCH expression.
+func fts5Query(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		part := `"` + strings.Join(term.words, " ") + `"`
+		if term.prefix {
+			part += "*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+// tsQuery renders terms for Postgres' to_tsquery.
+func tsQuery(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if len(term.words) > 1 {
+			parts = append(parts, "("+strings.Join(term.words, " <-> ")+")")
+			continue
+		}
+		part := term.words[0]
+		if term.prefix {
+			part += ":*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " & ")
+}
+
+// ensureSearchIndex sets up the FTS5 index and the triggers that keep it in
+// step with videos, rebuilding it whenever the triggers are missing (a new
+// database, or a migration that recreated the videos table). It reports
+// whether FTS5 is available at all.
+func (c Client) ensureSearchIndex() (bool, error) {
+	if c.dialect != dialectSQLite {
+		return false, nil
+	}
+
+	var fts5 bool
+	if err := c.queryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&fts5); err != nil {
+		return false, err
+	}
+	if !fts5 {
+		return false, nil
+	}
+
+	var triggers int
+	err := c.queryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'videos_fts_%'").Scan(&triggers)
+	if err != nil {
+		return false, err
+	}
+	if triggers == 3 {
+		return true, nil
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts5(
+		title,
+		description,
+		content='videos',
+		content_rowid='rowid',
+		tokenize='porter unicode61'
+	);
+
+	DROP TRIGGER IF EXISTS videos_fts_ai;
+	DROP TRIGGER IF EXISTS videos_fts_ad;
+	DROP TRIGGER IF EXISTS videos_fts_au;
+
+	CREATE TRIGGER videos_fts_ai AFTER INSERT ON videos BEGIN
+		INSERT INTO videos_fts (rowid, title, description)
+		VALUES (new.rowid, new.title, new.description);
+	END;
+
+	CREATE TRIGGER videos_fts_ad AFTER DELETE ON videos BEGIN
+		INSERT INTO videos_fts (videos_fts, rowid, title, description)
+		VALUES ('delete', old.rowid, old.title, old.description);
+	END;
+
+	CREATE TRIGGER videos_fts_au AFTER UPDATE OF title, description ON videos BEGIN
+		INSERT INTO videos_fts (videos_fts, rowid, title, description)
+		VALUES ('delete', old.rowid, old.title, old.description);
+		INSERT INTO videos_fts (rowid, title, description)
+		VALUES (new.rowid, new.title, new.description);
+	END;
+
+	INSERT INTO videos_fts (videos_fts) VALUES ('rebuild');
+	`)
+	if err != nil {
+		return false, fmt.Errorf("couldn't create search index: %w", err)
+	}
+	return true, tx.Commit()
+}
+
+// SearchVideos finds a user's videos matching params.Query, best match
+// first. SQLite builds without FTS5 fall back to matching in Go, which is
+// fine for local development but reads every video the user has.
+func (c Client) SearchVideos(params SearchVideosParams) ([]VideoSearchResult, error) {
+	params, terms, err := params.normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c.dialect == dialectPostgres:
+		return c.searchVideosPostgres(params, terms)
+	case c.fts5:
+		return c.searchVideosFTS5(params, terms)
+	}
+
+	videos, err := c.GetVideos(params.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return searchVideosInMemory(videos, params, terms), nil
+}
+
+func (c Client) searchVideosFTS5(params SearchVideosParams, terms []searchTerm) ([]VideoSearchResult, error) {
+	query := `
+	SELECT` + prefixColumns("v", videoColumns) + `,
+		snippet(videos_fts, -1, ?, ?, '…', ?),
+		-bm25(videos_fts, 10.0, 1.0) AS rank
+	FROM videos_fts
+	JOIN videos v ON v.rowid = videos_fts.rowid
+	WHERE videos_fts MATCH ? AND v.user_id = ? AND v.deleted_at IS NULL
+	ORDER BY rank DESC
+	LIMIT ? OFFSET ?
+	`
+	rows, err := c.query(query,
+		highlightStart, highlightEnd, snippetWords,
+		fts5Query(terms), params.UserID,
+		params.Limit, params.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanSearchResults(rows)
+}
+
+func (c Client) searchVideosPostgres(params SearchVideosParams, terms []searchTerm) ([]VideoSearchResult, error) {
+	query := `
+	SELECT` + prefixColumns("v", videoColumns) + `,
+		ts_headline('english', v.title || ' ' || v.description, q,
+			'StartSel=` + highlightStart + `, StopSel=` + highlightEnd + `, MaxWords=` + fmt.Sprint(snippetWords) + `, MinWords=4'),
+		ts_rank(v.search_vector, q) AS rank
+	FROM videos v, to_tsquery('english', ?) q
+	WHERE v.search_vector @@ q AND v.user_id = ? AND v.deleted_at IS NULL
+	ORDER BY rank DESC, v.created_at DESC
+	LIMIT ? OFFSET ?
+	`
+	rows, err := c.query(query, tsQuery(terms), params.UserID, params.Limit, params.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanSearchResults(rows)
+}
+
+func scanSearchResults(rows *sql.Rows) ([]VideoSearchResult, error) {
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		err := rows.Scan(append(videoFields(&result.Video), &result.Snippet, &result.Rank)...)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// prefixColumns qualifies a column list such as videoColumns with a table
+// alias.
+func prefixColumns(alias, columns string) string {
+	fields := strings.Split(columns, ",")
+	for i, field := range fields {
+		fields[i] = strings.Replace(field, strings.TrimSpace(field), alias+"."+strings.TrimSpace(field), 1)
+	}
+	return strings.Join(fields, ",")
+}
+
+// searchVideosInMemory is the search used by MemoryStore and by SQLite
+// without FTS5. Titles count ten times as much as descriptions, like the
+// bm25 weights above.
+func searchVideosInMemory(videos []Video, params SearchVideosParams, terms []searchTerm) []VideoSearchResult {
+	results := []VideoSearchResult{}
+	for _, video := range videos {
+		titleWords := searchWords(video.Title)
+		descriptionWords := searchWords(video.Description)
+
+		rank := 0.0
+		matched := true
+		for _, term := range terms {
+			inTitle := len(matchTerm(titleWords, term))
+			inDescription := len(matchTerm(descriptionWords, term))
+			if inTitle+inDescription == 0 {
+				matched = false
+				break
+			}
+			rank += 10*float64(inTitle) + float64(inDescription)
+		}
+		if !matched {
+			continue
+		}
+
+		snippet := highlightWords(video.Title, terms)
+		if !strings.Contains(snippet, highlightStart) {
+			snippet = highlightWords(video.Description, terms)
+		}
+		results = append(results, VideoSearchResult{
+			Video:   video,
+			Snippet: snippet,
+			Rank:    rank,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].CreatedAt.After(results[j].CreatedAt)
+	})
+
+	if params.Offset >= len(results) {
+		return []VideoSearchResult{}
+	}
+	results = results[params.Offset:]
+	if len(results) > params.Limit {
+		results = results[:params.Limit]
+	}
+	return results
+}
+
+// matchTerm returns the indexes in words where term starts.
+func matchTerm(words []string, term searchTerm) []int {
+	matches := []int{}
+	for i := 0; i+len(term.words) <= len(words); i++ {
+		ok := true
+		for j, want := range term.words {
+			got := words[i+j]
+			last := j == len(term.words)-1
+			if got != want && !(term.prefix && last && strings.HasPrefix(got, want)) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// highlightWords returns up to snippetWords words of text around the first
+// match, with every matching word wrapped in highlight tags.
+func highlightWords(text string, terms []searchTerm) string {
+	fields := strings.Fields(text)
+	words := make([]string, len(fields))
+	for i, field := range fields {
+		words[i] = strings.Join(searchWords(field), "")
+	}
+
+	marked := make([]bool, len(fields))
+	first := -1
+	for _, term := range terms {
+		for _, start := range matchTerm(words, term) {
+			for k := start; k < start+len(term.words); k++ {
+				marked[k] = true
+			}
+			if first == -1 || start < first {
+				first = start
+			}
+		}
+	}
+
+	from := 0
+	if first > snippetWords/2 {
+		from = first - snippetWords/2
+	}
+	to := min(from+snippetWords, len(fields))
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("… ")
+	}
+	for i := from; i < to; i++ {
+		if i > from {
+			b.WriteByte(' ')
+		}
+		if marked[i] {
+			b.WriteString(highlightStart + fields[i] + highlightEnd)
+		} else {
+			b.WriteString(fields[i])
+		}
+	}
+	if to < len(fields) {
+		b.WriteString(" …")
+	}
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a login as seen by its user: a refresh token family and the
+// client that holds its current token.
+type Session struct {
+	// ID is the refresh token family's ID.
+	ID uuid.UUID `json:"id"`
+	// LastUsedAt is when the session was last refreshed, which is when its
+	// current token was issued.
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	SessionClient
+}
+
+// ListSessions returns the user's sessions that can still be refreshed,
+// most recently used first.
+func (c Client) ListSessions(userID uuid.UUID) ([]Session, error) {
+	query := `
+	SELECT
+		rt.family_id,
+		rt.created_at,
+		rt.expires_at,
+		rt.user_agent,
+		rt.ip_address
+	FROM refresh_tokens rt
+	WHERE rt.user_id = ? AND rt.revoked_at IS NULL AND rt.expires_at > ?
+	ORDER BY rt.created_at DESC, rt.family_id
+	`
+	rows, err := c.query(query, userID, c.dialect.timeArg(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		err := rows.Scan(&s.ID, &s.LastUsedAt, &s.ExpiresAt, &s.UserAgent, &s.IPAddress)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// RevokeSession revokes every token in a refresh token family, ending the
+// session.
+func (c Client) RevokeSession(id uuid.UUID) error {
+	query := `
+	UPDATE refresh_tokens
+	SET revoked_at = CURRENT_TIMESTAMP
+	WHERE family_id = ? AND revoked_at IS NULL
+	`
+	_, err := c.exec(query, id)
+	return err
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StorageObject names a stored file: Backend says which storage it lives in
+// ("videos" or "thumbnails") and Key where.
+type StorageObject struct {
+	Backend string `json:"backend"`
+	Key     string `json:"key"`
+}
+
+type StorageDeletion struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	VideoID   uuid.UUID `json:"video_id"`
+	StorageObject
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	LastError     *string   `json:"last_error"`
+}
+
+// DeleteVideoWithObjects deletes a video and, in the same transaction,
+// queues its stored files for deletion so none are leaked if the server
+// dies halfway.
+func (c Client) DeleteVideoWithObjects(videoID uuid.UUID, objects []StorageObject) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := c.dialect.timeArg(time.Now())
+	for _, object := range objects {
+		_, err := tx.Exec(c.dialect.rebind(`
+		INSERT INTO storage_deletions (id, created_at, video_id, backend, object_key, next_attempt_at)
+		VALUES (?, CURRENT_TIMESTAMP, ?, ?, ?, ?)
+		`), uuid.New(), videoID, object.Backend, object.Key, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := c.deleteVideoRelations(tx, videoID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind("DELETE FROM videos WHERE id = ?"), videoID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimStorageDeletions returns up to limit deletions that are due and
+// pushes their next attempt back by lease, so that other server instances
+// leave them alone while this one works on them.
+func (c Client) ClaimStorageDeletions(limit int, lease time.Duration) ([]StorageDeletion, error) {
+	now := time.Now()
+	query := `
+	SELECT id, created_at, video_id, backend, object_key, attempts, next_attempt_at, last_error
+	FROM storage_deletions
+	WHERE next_attempt_at <= ?
+	ORDER BY next_attempt_at
+	LIMIT ?
+	`
+	rows, err := c.query(query, c.dialect.timeArg(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	due := []StorageDeletion{}
+	for rows.Next() {
+		var d StorageDeletion
+		err := rows.Scan(&d.ID, &d.CreatedAt, &d.VideoID, &d.Backend, &d.Key, &d.Attempts, &d.NextAttemptAt, &d.LastError)
+		if err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	claimed := []StorageDeletion{}
+	leaseUntil := now.Add(lease)
+	for _, d := range due {
+		// Only one instance gets to move next_attempt_at from the value it
+		// read.
+		result, err := c.exec(
+			"UPDATE storage_deletions SET next_attempt_at = ? WHERE id = ? AND next_attempt_at = ?",
+			c.dialect.timeArg(leaseUntil), d.ID, c.dialect.timeArg(d.NextAttemptAt),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			continue
+		}
+		d.NextAttemptAt = leaseUntil
+		claimed = append(claimed, d)
+	}
+	return claimed, nil
+}
+
+// CompleteStorageDeletion forgets a deletion once its file is gone.
+func (c Client) CompleteStorageDeletion(id uuid.UUID) error {
+	_, err := c.exec("DELETE FROM storage_deletions WHERE id = ?", id)
+	return err
+}
+
+// FailStorageDeletion records a failed attempt and when to retry.
+func (c Client) FailStorageDeletion(id uuid.UUID, lastError string, retryAt time.Time) error {
+	query := `
+	UPDATE storage_deletions
+	SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?
+	WHERE id = ?
+	`
+	_, err := c.exec(query, lastError, c.dialect.timeArg(retryAt), id)
+	return err
+}
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The store interfaces describe what the HTTP handlers need from the
+// database so they can run against either Client or a MemoryStore.
+// Lookups of rows that don't exist return zero values rather than errors.
+
+type UserStore interface {
+	GetUsers() ([]User, error)
+	GetUser(id uuid.UUID) (*User, error)
+	GetUserByEmail(email string) (User, error)
+	CreateUser(params CreateUserParams) (*User, error)
+	SetUserRole(id uuid.UUID, role Role) error
+	SetUserDisabled(id uuid.UUID, disabled bool) error
+	SetUserEmailVerified(id uuid.UUID) error
+	SetUserPassword(id uuid.UUID, hashedPassword string) error
+	GetUserByIdentity(issuer, subject string) (*User, error)
+	LinkIdentity(userID uuid.UUID, issuer, subject string) error
+	DeleteUser(id uuid.UUID) error
+}
+
+// ProfileStore keeps users' public channel profiles. Handles are compared
+// after NormalizeHandle.
+type ProfileStore interface {
+	GetProfile(userID uuid.UUID) (Profile, error)
+	GetProfileByHandle(handle string) (Profile, error)
+	SaveProfile(p Profile) (Profile, error)
+}
+
+type VideoStore interface {
+	GetVideos(userID uuid.UUID) ([]Video, error)
+	ListVideos(params ListVideosParams) (VideoPage, error)
+	SearchVideos(params SearchVideosParams) ([]VideoSearchResult, error)
+	GetVideo(id uuid.UUID) (Video, error)
+	GetVideoByThumbnailURL(url string) (Video, error)
+	CreateVideo(params CreateVideoParams) (Video, error)
+	UpdateVideo(video Video) error
+	UpdateVideoIfUnmodified(video Video, lastUpdatedAt time.Time) error
+	DeleteVideo(id uuid.UUID) error
+
+	TrashVideo(id uuid.UUID) error
+	RestoreVideo(id uuid.UUID) error
+	GetTrashedVideo(id uuid.UUID) (Video, error)
+	ListTrashedVideos(userID uuid.UUID) ([]Video, error)
+	ListVideosTrashedBefore(cutoff time.Time, limit int) ([]Video, error)
+}
+
+type TagStore interface {
+	SetVideoTags(videoID uuid.UUID, names []string) ([]string, error)
+	GetVideoTags(videoID uuid.UUID) ([]string, error)
+	ListTagCounts(userID uuid.UUID) ([]TagCount, error)
+}
+
+// PlaylistStore keeps playlists and the ordered videos in them. Item
+// changes on a missing playlist return ErrPlaylistNotFound.
+type PlaylistStore interface {
+	CreatePlaylist(params CreatePlaylistParams) (Playlist, error)
+	GetPlaylist(id uuid.UUID) (Playlist, error)
+	ListPlaylists(userID uuid.UUID) ([]Playlist, error)
+	UpdatePlaylist(p Playlist) error
+	DeletePlaylist(id uuid.UUID) error
+	GetPlaylistItems(playlistID uuid.UUID) ([]PlaylistItem, error)
+	AddPlaylistItem(playlistID, videoID uuid.UUID, position int) error
+	MovePlaylistItem(playlistID, videoID uuid.UUID, position int) error
+	RemovePlaylistItem(playlistID, videoID uuid.UUID) error
+}
+
+// ViewStore records views of videos and rolls them up into daily stats.
+type ViewStore interface {
+	RecordView(videoID uuid.UUID, viewerKey string, window time.Duration) (View, bool, error)
+	GetView(id uuid.UUID) (View, error)
+	RecordViewHeartbeat(id uuid.UUID, position float64, maxGap time.Duration) error
+	RollupViewStats(since time.Time) error
+	GetVideoStats(videoID uuid.UUID, from, to time.Time) (VideoStats, error)
+}
+
+type CommentStore interface {
+	CreateComment(params CreateCommentParams) (Comment, error)
+	GetComment(id uuid.UUID) (Comment, error)
+	GetPinnedComment(videoID uuid.UUID) (Comment, error)
+	ListComments(params ListCommentsParams) (CommentPage, error)
+	UpdateCommentBody(id uuid.UUID, body string) error
+	DeleteComment(id uuid.UUID) error
+	SetCommentHidden(id uuid.UUID, hidden bool) error
+	PinComment(videoID, commentID uuid.UUID) error
+}
+
+type ReactionStore interface {
+	SetReaction(userID, videoID uuid.UUID, reaction Reaction) error
+	DeleteReaction(userID, videoID uuid.UUID) error
+	GetReactionSummaries(videoIDs []uuid.UUID, viewerID uuid.UUID) (map[uuid.UUID]ReactionSummary, error)
+}
+
+// SubscriptionStore records which channels users follow. Feeds are listed
+// with ListVideosParams.SubscriberID.
+type SubscriptionStore interface {
+	Subscribe(subscriberID, channelID uuid.UUID) error
+	Unsubscribe(subscriberID, channelID uuid.UUID) error
+	IsSubscribed(subscriberID, channelID uuid.UUID) (bool, error)
+	CountSubscribers(channelID uuid.UUID) (int, error)
+	ListSubscriptions(subscriberID uuid.UUID) ([]Profile, error)
+}
+
+// RefreshTokenStore keeps refresh tokens, which are rotated on every use.
+type RefreshTokenStore interface {
+	CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error)
+	RotateRefreshToken(oldToken, newToken string, expiresAt time.Time, client SessionClient) (RefreshToken, error)
+	GetRefreshToken(token string) (RefreshToken, error)
+	GetUserByRefreshToken(token string) (*User, error)
+	RevokeRefreshToken(token string) error
+	RevokeUserRefreshTokens(userID uuid.UUID) error
+	DeleteRefreshToken(token string) error
+
+	// A session is a refresh token family; its ID is the family ID.
+	ListSessions(userID uuid.UUID) ([]Session, error)
+	RevokeSession(id uuid.UUID) error
+}
+
+// APIKeyStore keeps users' API keys. Lookups return revoked keys too;
+// it's up to the caller to reject them.
+type APIKeyStore interface {
+	CreateAPIKey(params CreateAPIKeyParams) (APIKey, error)
+	GetAPIKey(id uuid.UUID) (APIKey, error)
+	GetAPIKeyByPrefix(prefix string) (APIKey, error)
+	ListAPIKeys(userID uuid.UUID) ([]APIKey, error)
+	RenameAPIKey(id uuid.UUID, name string) error
+	RevokeAPIKey(id uuid.UUID) error
+	MarkAPIKeyUsed(id uuid.UUID, at time.Time) error
+}
+
+// TOTPStore keeps users' authenticator app secrets and the hashes of their
+// recovery codes.
+type TOTPStore interface {
+	GetTOTP(userID uuid.UUID) (TOTP, error)
+	StartTOTPEnrollment(userID uuid.UUID, secret string) error
+	ConfirmTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(userID uuid.UUID, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID uuid.UUID, hashes []string) error
+	UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error)
+	RecordTOTPAttempt(userID uuid.UUID, at time.Time, maxFailures int, lockout time.Duration) (bool, error)
+	ClearTOTPFailures(userID uuid.UUID) error
+	DeleteTOTP(userID uuid.UUID) error
+}
+
+// EmailTokenStore keeps the single-use tokens emailed to users, stored as
+// hashes.
+type EmailTokenStore interface {
+	CreateEmailToken(params CreateEmailTokenParams) error
+	GetEmailTokenUser(purpose EmailTokenPurpose, tokenHash string) (uuid.UUID, error)
+	UseEmailToken(purpose EmailTokenPurpose, tokenHash string) (uuid.UUID, error)
+}
+
+// LoginCodeStore keeps the single-use codes the web app trades for tokens
+// after single sign-on, stored as hashes.
+type LoginCodeStore interface {
+	CreateLoginCode(params CreateLoginCodeParams) error
+	UseLoginCode(codeHash string) (uuid.UUID, error)
+}
+
+// StorageDeletionStore is the queue of stored files left behind by deleted
+// videos.
+type StorageDeletionStore interface {
+	DeleteVideoWithObjects(videoID uuid.UUID, objects []StorageObject) error
+	ClaimStorageDeletions(limit int, lease time.Duration) ([]StorageDeletion, error)
+	CompleteStorageDeletion(id uuid.UUID) error
+	FailStorageDeletion(id uuid.UUID, lastError string, retryAt time.Time) error
+}
+
+// AdminStore backs the admin API's system-wide queries.
+type AdminStore interface {
+	GetSystemStats() (SystemStats, error)
+}
+
+type Store interface {
+	UserStore
+	ProfileStore
+	VideoStore
+	TagStore
+	PlaylistStore
+	ViewStore
+	CommentStore
+	ReactionStore
+	SubscriptionStore
+	RefreshTokenStore
+	APIKeyStore
+	TOTPStore
+	EmailTokenStore
+	LoginCodeStore
+	StorageDeletionStore
+	AdminStore
+	Reset() error
+}
+
+var (
+	_ Store = Client{}
+	_ Store = (*MemoryStore)(nil)
+)
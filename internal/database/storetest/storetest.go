@@ -0,0 +1,1987 @@
+// Package storetest is a conformance suite for database.Store
+// implementations. Every implementation's tests should call Run so that the
+// handlers see the same behaviour no matter which store backs them.
+package storetest
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Run exercises store implementations returned by newStore, which must hand
+// back a fresh, empty store on every call.
+func Run(t *testing.T, newStore func(t *testing.T) database.Store) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, newStore(t)) })
+	t.Run("Roles", func(t *testing.T) { testRoles(t, newStore(t)) })
+	t.Run("Profiles", func(t *testing.T) { testProfiles(t, newStore(t)) })
+	t.Run("Videos", func(t *testing.T) { testVideos(t, newStore(t)) })
+	t.Run("ListVideos", func(t *testing.T) { testListVideos(t, newStore(t)) })
+	t.Run("SearchVideos", func(t *testing.T) { testSearchVideos(t, newStore(t)) })
+	t.Run("RefreshTokens", func(t *testing.T) { testRefreshTokens(t, newStore(t)) })
+	t.Run("RefreshTokenRotation", func(t *testing.T) { testRefreshTokenRotation(t, newStore(t)) })
+	t.Run("RefreshTokenRevokedNotReused", func(t *testing.T) { testRefreshTokenRevokedNotReused(t, newStore(t)) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, newStore(t)) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, newStore(t)) })
+	t.Run("TOTP", func(t *testing.T) { testTOTP(t, newStore(t)) })
+	t.Run("EmailTokens", func(t *testing.T) { testEmailTokens(t, newStore(t)) })
+	t.Run("LoginCodes", func(t *testing.T) { testLoginCodes(t, newStore(t)) })
+	t.Run("Identities", func(t *testing.T) { testIdentities(t, newStore(t)) })
+	t.Run("StorageDeletions", func(t *testing.T) { testStorageDeletions(t, newStore(t)) })
+	t.Run("Trash", func(t *testing.T) { testTrash(t, newStore(t)) })
+	t.Run("Tags", func(t *testing.T) { testTags(t, newStore(t)) })
+	t.Run("Playlists", func(t *testing.T) { testPlaylists(t, newStore(t)) })
+	t.Run("Views", func(t *testing.T) { testViews(t, newStore(t)) })
+	t.Run("Comments", func(t *testing.T) { testComments(t, newStore(t)) })
+	t.Run("Reactions", func(t *testing.T) { testReactions(t, newStore(t)) })
+	t.Run("Subscriptions", func(t *testing.T) { testSubscriptions(t, newStore(t)) })
+	t.Run("Reset", func(t *testing.T) { testReset(t, newStore(t)) })
+}
+
+func mustCreateUser(t *testing.T, s database.Store, email string) *database.User {
+	t.Helper()
+	user, err := s.CreateUser(database.CreateUserParams{
+		Email:    email,
+		Password: "hashed-" + email,
+	})
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", email, err)
+	}
+	if user == nil {
+		t.Fatalf("CreateUser(%q) returned no user", email)
+	}
+	return user
+}
+
+func mustCreateVideo(t *testing.T, s database.Store, userID uuid.UUID, title string) database.Video {
+	t.Helper()
+	video, err := s.CreateVideo(database.CreateVideoParams{
+		Title:       title,
+		Description: "about " + title,
+		UserID:      userID,
+	})
+	if err != nil {
+		t.Fatalf("CreateVideo(%q): %v", title, err)
+	}
+	return video
+}
+
+func testUsers(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+	if user.ID == uuid.Nil {
+		t.Error("created user has no ID")
+	}
+	if user.Email != "alice@example.com" || user.Password != "hashed-alice@example.com" {
+		t.Errorf("created user = %+v, want email and password to round-trip", user.CreateUserParams)
+	}
+	if user.CreatedAt.IsZero() {
+		t.Error("created user has no created_at")
+	}
+
+	if _, err := s.CreateUser(database.CreateUserParams{Email: "alice@example.com", Password: "x"}); err == nil {
+		t.Error("CreateUser with a duplicate email succeeded")
+	}
+
+	got, err := s.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got == nil || got.ID != user.ID || got.Email != user.Email {
+		t.Errorf("GetUser = %+v, want %+v", got, user)
+	}
+
+	byEmail, err := s.GetUserByEmail("alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if byEmail.ID != user.ID || byEmail.Password != user.Password {
+		t.Errorf("GetUserByEmail = %+v, want %+v", byEmail, user)
+	}
+
+	missing, err := s.GetUserByEmail("nobody@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail for unknown email: %v", err)
+	}
+	if missing.ID != uuid.Nil {
+		t.Errorf("GetUserByEmail for unknown email = %+v, want zero user", missing)
+	}
+
+	mustCreateUser(t, s, "bob@example.com")
+	users, err := s.GetUsers()
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != 2 {
+		t.Errorf("GetUsers returned %d users, want 2", len(users))
+	}
+
+	if err := s.DeleteUser(user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	deleted, err := s.GetUser(user.ID)
+	if err != nil {
+		t.Fatalf("GetUser after delete: %v", err)
+	}
+	if deleted != nil {
+		t.Errorf("GetUser after delete = %+v, want nil", deleted)
+	}
+}
+
+func testRoles(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	if alice.Role != database.RoleCreator {
+		t.Errorf("new user's role = %q, want %q", alice.Role, database.RoleCreator)
+	}
+	if alice.DisabledAt != nil {
+		t.Errorf("new user is disabled at %v", alice.DisabledAt)
+	}
+
+	if err := s.SetUserRole(alice.ID, database.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+	got, err := s.GetUser(alice.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if got.Role != database.RoleAdmin {
+		t.Errorf("role after SetUserRole = %q, want %q", got.Role, database.RoleAdmin)
+	}
+
+	if err := s.SetUserDisabled(bob.ID, true); err != nil {
+		t.Fatalf("SetUserDisabled: %v", err)
+	}
+	disabled, err := s.GetUserByEmail("bob@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if disabled.DisabledAt == nil {
+		t.Fatal("disabled user has no disabled_at")
+	}
+	if err := s.SetUserDisabled(bob.ID, true); err != nil {
+		t.Fatalf("SetUserDisabled again: %v", err)
+	}
+	again, err := s.GetUser(bob.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if again.DisabledAt == nil || !again.DisabledAt.Equal(*disabled.DisabledAt) {
+		t.Errorf("disabling again moved disabled_at from %v to %v", disabled.DisabledAt, again.DisabledAt)
+	}
+
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	for _, token := range []string{"bob-1", "bob-2"} {
+		if _, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: token, UserID: bob.ID, ExpiresAt: expiresAt}); err != nil {
+			t.Fatalf("CreateRefreshToken: %v", err)
+		}
+	}
+	if _, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "alice-1", UserID: alice.ID, ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if err := s.RevokeUserRefreshTokens(bob.ID); err != nil {
+		t.Fatalf("RevokeUserRefreshTokens: %v", err)
+	}
+	for token, wantRevoked := range map[string]bool{"bob-1": true, "bob-2": true, "alice-1": false} {
+		rt, err := s.GetRefreshToken(token)
+		if err != nil {
+			t.Fatalf("GetRefreshToken(%q): %v", token, err)
+		}
+		if (rt.RevokedAt != nil) != wantRevoked {
+			t.Errorf("refresh token %q revoked = %v, want %v", token, rt.RevokedAt != nil, wantRevoked)
+		}
+	}
+
+	video := mustCreateVideo(t, s, bob.ID, "handed over")
+	trashed := mustCreateVideo(t, s, bob.ID, "trashed")
+	if err := s.TrashVideo(trashed.ID); err != nil {
+		t.Fatalf("TrashVideo: %v", err)
+	}
+	video.UserID = alice.ID
+	if err := s.UpdateVideo(video); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	moved, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if moved.UserID != alice.ID {
+		t.Errorf("video owner after transfer = %s, want %s", moved.UserID, alice.ID)
+	}
+
+	stats, err := s.GetSystemStats()
+	if err != nil {
+		t.Fatalf("GetSystemStats: %v", err)
+	}
+	want := database.SystemStats{Users: 2, DisabledUsers: 1, Admins: 1, Videos: 1, TrashedVideos: 1}
+	if stats != want {
+		t.Errorf("GetSystemStats = %+v, want %+v", stats, want)
+	}
+
+	if err := s.SetUserDisabled(bob.ID, false); err != nil {
+		t.Fatalf("SetUserDisabled(false): %v", err)
+	}
+	enabled, err := s.GetUser(bob.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if enabled.DisabledAt != nil {
+		t.Errorf("re-enabled user is still disabled at %v", enabled.DisabledAt)
+	}
+
+	users, err := s.GetUsers()
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != 2 || users[0].Role != database.RoleAdmin || users[1].Role != database.RoleCreator {
+		t.Errorf("GetUsers = %+v, want alice as admin then bob as creator", users)
+	}
+}
+
+func testProfiles(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+
+	missing, err := s.GetProfile(alice.ID)
+	if err != nil || missing.UserID != uuid.Nil {
+		t.Fatalf("GetProfile before saving = %+v, %v; want zero value", missing, err)
+	}
+
+	saved, err := s.SaveProfile(database.Profile{UserID: alice.ID, Handle: " @Alice_1 ", DisplayName: "Alice"})
+	if err != nil {
+		t.Fatalf("SaveProfile: %v", err)
+	}
+	if saved.Handle != "alice_1" || saved.DisplayName != "Alice" || saved.CreatedAt.IsZero() {
+		t.Errorf("saved profile = %+v, want normalized handle alice_1", saved)
+	}
+
+	got, err := s.GetProfileByHandle("ALICE_1")
+	if err != nil {
+		t.Fatalf("GetProfileByHandle: %v", err)
+	}
+	if got.UserID != alice.ID {
+		t.Errorf("GetProfileByHandle returned user %s, want %s", got.UserID, alice.ID)
+	}
+
+	_, err = s.SaveProfile(database.Profile{UserID: bob.ID, Handle: "alice_1"})
+	if !errors.Is(err, database.ErrHandleTaken) {
+		t.Errorf("SaveProfile with a taken handle = %v, want ErrHandleTaken", err)
+	}
+
+	avatar := "http://localhost/assets/avatars/a.png"
+	saved.Handle = "alice"
+	saved.Bio = "hello"
+	saved.AvatarURL = &avatar
+	updated, err := s.SaveProfile(saved)
+	if err != nil {
+		t.Fatalf("SaveProfile update: %v", err)
+	}
+	if updated.Handle != "alice" || updated.Bio != "hello" || updated.AvatarURL == nil || *updated.AvatarURL != avatar {
+		t.Errorf("updated profile = %+v", updated)
+	}
+	if !updated.CreatedAt.Equal(saved.CreatedAt) {
+		t.Errorf("CreatedAt changed from %v to %v on update", saved.CreatedAt, updated.CreatedAt)
+	}
+
+	// The old handle is free again once renamed.
+	if _, err := s.SaveProfile(database.Profile{UserID: bob.ID, Handle: "alice_1"}); err != nil {
+		t.Errorf("SaveProfile with a released handle: %v", err)
+	}
+	old, err := s.GetProfileByHandle("alice_1")
+	if err != nil || old.UserID != bob.ID {
+		t.Errorf("GetProfileByHandle(alice_1) = %+v, %v; want bob's profile", old, err)
+	}
+}
+
+func testVideos(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+
+	video := mustCreateVideo(t, s, alice.ID, "first")
+	if video.ID == uuid.Nil {
+		t.Fatal("created video has no ID")
+	}
+	if video.Title != "first" || video.Description != "about first" || video.UserID != alice.ID {
+		t.Errorf("created video = %+v, want params to round-trip", video.CreateVideoParams)
+	}
+	if video.Visibility != database.VisibilityPrivate {
+		t.Errorf("created video visibility = %q, want private by default", video.Visibility)
+	}
+	if video.ThumbnailURL != nil || video.VideoURL != nil {
+		t.Errorf("created video has URLs %v %v, want none", video.ThumbnailURL, video.VideoURL)
+	}
+	mustCreateVideo(t, s, alice.ID, "second")
+	mustCreateVideo(t, s, bob.ID, "bobs")
+
+	videos, err := s.GetVideos(alice.ID)
+	if err != nil {
+		t.Fatalf("GetVideos: %v", err)
+	}
+	if len(videos) != 2 {
+		t.Fatalf("GetVideos returned %d videos, want 2", len(videos))
+	}
+	for _, v := range videos {
+		if v.UserID != alice.ID {
+			t.Errorf("GetVideos returned video %s owned by %s", v.ID, v.UserID)
+		}
+	}
+
+	thumbnailURL := "http://localhost:8091/assets/thumb.png"
+	videoURL := "https://cdn.example.com/landscape/video.mp4"
+	time.Sleep(5 * time.Millisecond)
+	video.Title = "renamed"
+	video.Visibility = database.VisibilityUnlisted
+	video.ThumbnailURL = &thumbnailURL
+	video.VideoURL = &videoURL
+	if err := s.UpdateVideo(video); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+	got, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if got.Title != "renamed" {
+		t.Errorf("title after update = %q, want %q", got.Title, "renamed")
+	}
+	if !got.UpdatedAt.After(video.UpdatedAt) {
+		t.Errorf("updated_at after update = %v, want later than %v", got.UpdatedAt, video.UpdatedAt)
+	}
+
+	got.Description = "edited"
+	if err := s.UpdateVideoIfUnmodified(got, video.UpdatedAt); !errors.Is(err, database.ErrVideoModified) {
+		t.Errorf("UpdateVideoIfUnmodified with a stale updated_at: err = %v, want ErrVideoModified", err)
+	}
+	if err := s.UpdateVideoIfUnmodified(got, got.UpdatedAt); err != nil {
+		t.Errorf("UpdateVideoIfUnmodified with the current updated_at: %v", err)
+	}
+	edited, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if edited.Description != "edited" {
+		t.Errorf("description after conditional update = %q, want %q", edited.Description, "edited")
+	}
+	if got.Visibility != database.VisibilityUnlisted {
+		t.Errorf("visibility after update = %q, want unlisted", got.Visibility)
+	}
+	if got.ThumbnailURL == nil || *got.ThumbnailURL != thumbnailURL {
+		t.Errorf("thumbnail_url after update = %v, want %q", got.ThumbnailURL, thumbnailURL)
+	}
+	if got.VideoURL == nil || *got.VideoURL != videoURL {
+		t.Errorf("video_url after update = %v, want %q", got.VideoURL, videoURL)
+	}
+	byThumbnail, err := s.GetVideoByThumbnailURL(thumbnailURL)
+	if err != nil {
+		t.Fatalf("GetVideoByThumbnailURL: %v", err)
+	}
+	if byThumbnail.ID != video.ID {
+		t.Errorf("GetVideoByThumbnailURL = %s, want %s", byThumbnail.ID, video.ID)
+	}
+	if other, err := s.GetVideoByThumbnailURL("http://localhost:8091/assets/other.png"); err != nil || other.ID != uuid.Nil {
+		t.Errorf("GetVideoByThumbnailURL of an unknown thumbnail = %s, %v; want the zero video", other.ID, err)
+	}
+
+	if err := s.DeleteVideo(video.ID); err != nil {
+		t.Fatalf("DeleteVideo: %v", err)
+	}
+	gone, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo after delete: %v", err)
+	}
+	if gone.ID != uuid.Nil {
+		t.Errorf("GetVideo after delete = %+v, want zero video", gone)
+	}
+}
+
+// listAll follows cursors until the listing is exhausted and returns the
+// titles in the order they were served.
+func listAll(t *testing.T, s database.Store, params database.ListVideosParams) []string {
+	t.Helper()
+	titles := []string{}
+	for range 20 {
+		page, err := s.ListVideos(params)
+		if err != nil {
+			t.Fatalf("ListVideos(%+v): %v", params, err)
+		}
+		if len(page.Items) > params.Limit {
+			t.Fatalf("ListVideos returned %d items, limit was %d", len(page.Items), params.Limit)
+		}
+		for _, v := range page.Items {
+			titles = append(titles, v.Title)
+		}
+		if page.NextCursor == nil {
+			return titles
+		}
+		params.Cursor = *page.NextCursor
+	}
+	t.Fatal("ListVideos kept returning cursors")
+	return nil
+}
+
+func equalTitles(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testListVideos(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	bobs := mustCreateVideo(t, s, bob.ID, "bobs")
+	bobs.Visibility = database.VisibilityPublic
+	if err := s.UpdateVideo(bobs); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+
+	videoURL := "https://cdn.example.com/landscape/video.mp4"
+	durations := map[string]float64{"e": 5, "a": 1, "d": 4, "c": 3, "b": 2}
+	for _, title := range []string{"e", "a", "d", "c", "b"} {
+		video := mustCreateVideo(t, s, alice.ID, title)
+		video.Duration = durations[title]
+		if title == "a" || title == "c" {
+			video.VideoURL = &videoURL
+		}
+		if title == "a" {
+			video.Visibility = database.VisibilityPublic
+		}
+		if title == "b" {
+			video.Visibility = database.VisibilityUnlisted
+		}
+		if err := s.UpdateVideo(video); err != nil {
+			t.Fatalf("UpdateVideo: %v", err)
+		}
+	}
+
+	got := listAll(t, s, database.ListVideosParams{UserID: alice.ID, Limit: 2, Sort: database.VideoSortTitle, Ascending: true})
+	if want := []string{"a", "b", "c", "d", "e"}; !equalTitles(got, want) {
+		t.Errorf("by title ascending = %v, want %v", got, want)
+	}
+
+	got = listAll(t, s, database.ListVideosParams{UserID: alice.ID, Limit: 3, Sort: database.VideoSortDuration})
+	if want := []string{"e", "d", "c", "b", "a"}; !equalTitles(got, want) {
+		t.Errorf("by duration descending = %v, want %v", got, want)
+	}
+
+	// Every video was created within the same second or so, so this relies
+	// on the ID tiebreak to neither skip nor repeat anything.
+	got = listAll(t, s, database.ListVideosParams{UserID: alice.ID, Limit: 1})
+	seen := map[string]bool{}
+	for _, title := range got {
+		seen[title] = true
+	}
+	if len(got) != 5 || len(seen) != 5 {
+		t.Errorf("by created_at = %v, want each of alice's 5 videos once", got)
+	}
+
+	hasVideo := true
+	got = listAll(t, s, database.ListVideosParams{UserID: alice.ID, Limit: 10, Sort: database.VideoSortTitle, Ascending: true, HasVideo: &hasVideo})
+	if want := []string{"a", "c"}; !equalTitles(got, want) {
+		t.Errorf("has_video=true = %v, want %v", got, want)
+	}
+
+	hasThumbnail := true
+	got = listAll(t, s, database.ListVideosParams{UserID: alice.ID, Limit: 10, HasThumbnail: &hasThumbnail})
+	if len(got) != 0 {
+		t.Errorf("has_thumbnail=true = %v, want none", got)
+	}
+
+	future := time.Now().Add(time.Hour)
+	got = listAll(t, s, database.ListVideosParams{UserID: alice.ID, Limit: 10, CreatedAfter: &future})
+	if len(got) != 0 {
+		t.Errorf("created_after in the future = %v, want none", got)
+	}
+	past := time.Now().Add(-time.Hour)
+	got = listAll(t, s, database.ListVideosParams{UserID: alice.ID, Limit: 10, CreatedAfter: &past})
+	if len(got) != 5 {
+		t.Errorf("created_after an hour ago = %v, want all 5", got)
+	}
+
+	got = listAll(t, s, database.ListVideosParams{Visibility: database.VisibilityPublic, Limit: 1, Sort: database.VideoSortTitle, Ascending: true})
+	if want := []string{"a", "bobs"}; !equalTitles(got, want) {
+		t.Errorf("public videos of every user = %v, want %v", got, want)
+	}
+
+	page, err := s.ListVideos(database.ListVideosParams{UserID: alice.ID, Limit: 2, Sort: database.VideoSortTitle})
+	if err != nil {
+		t.Fatalf("ListVideos: %v", err)
+	}
+	if page.NextCursor == nil {
+		t.Fatal("first page of 5 videos has no cursor")
+	}
+	_, err = s.ListVideos(database.ListVideosParams{UserID: alice.ID, Limit: 2, Sort: database.VideoSortDuration, Cursor: *page.NextCursor})
+	if !errors.Is(err, database.ErrInvalidCursor) {
+		t.Errorf("cursor reused with another sort: err = %v, want ErrInvalidCursor", err)
+	}
+	_, err = s.ListVideos(database.ListVideosParams{UserID: alice.ID, Cursor: "not a cursor"})
+	if !errors.Is(err, database.ErrInvalidCursor) {
+		t.Errorf("garbage cursor: err = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func testSearchVideos(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	for _, v := range []database.CreateVideoParams{
+		{Title: "Learning Go concurrency", Description: "channels and goroutines explained", UserID: alice.ID},
+		{Title: "Cooking pasta", Description: "a quick dinner, learning by doing", UserID: alice.ID},
+		{Title: "Gopher facts", Description: "all about the go gopher", UserID: alice.ID},
+		{Title: "Learning Rust", Description: "ownership and borrowing", UserID: bob.ID},
+	} {
+		if _, err := s.CreateVideo(v); err != nil {
+			t.Fatalf("CreateVideo: %v", err)
+		}
+	}
+
+	search := func(q string) []database.VideoSearchResult {
+		t.Helper()
+		results, err := s.SearchVideos(database.SearchVideosParams{UserID: alice.ID, Query: q})
+		if err != nil {
+			t.Fatalf("SearchVideos(%q): %v", q, err)
+		}
+		return results
+	}
+	titles := func(results []database.VideoSearchResult) []string {
+		titles := []string{}
+		for _, r := range results {
+			titles = append(titles, r.Title)
+		}
+		return titles
+	}
+
+	results := search("learning")
+	if want := []string{"Learning Go concurrency", "Cooking pasta"}; !equalTitles(titles(results), want) {
+		t.Errorf("search for learning = %v, want %v (title matches first)", titles(results), want)
+	}
+	if len(results) > 0 && !strings.Contains(results[0].Snippet, "<mark>Learning</mark>") {
+		t.Errorf("snippet = %q, want the match highlighted", results[0].Snippet)
+	}
+	if len(results) == 2 && results[0].Rank <= results[1].Rank {
+		t.Errorf("ranks = %v, %v, want descending", results[0].Rank, results[1].Rank)
+	}
+
+	if got, want := titles(search("gorout*")), []string{"Learning Go concurrency"}; !equalTitles(got, want) {
+		t.Errorf("prefix search = %v, want %v", got, want)
+	}
+	if got, want := titles(search(`"go gopher"`)), []string{"Gopher facts"}; !equalTitles(got, want) {
+		t.Errorf("phrase search = %v, want %v", got, want)
+	}
+	if got, want := titles(search("quick dinner")), []string{"Cooking pasta"}; !equalTitles(got, want) {
+		t.Errorf("search for every word = %v, want %v", got, want)
+	}
+	if got := search("rust"); len(got) != 0 {
+		t.Errorf("search matched another user's video: %v", titles(got))
+	}
+
+	_, err := s.SearchVideos(database.SearchVideosParams{UserID: alice.ID, Query: " !!! "})
+	if !errors.Is(err, database.ErrEmptySearchQuery) {
+		t.Errorf("search without terms: err = %v, want ErrEmptySearchQuery", err)
+	}
+}
+
+func testRefreshTokens(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+
+	rt, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{
+		Token:     "token-1",
+		UserID:    user.ID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if rt.Token != "token-1" || rt.UserID != user.ID || !rt.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("created refresh token = %+v, want params to round-trip", rt.CreateRefreshTokenParams)
+	}
+	if rt.RevokedAt != nil {
+		t.Errorf("new refresh token is revoked at %v", rt.RevokedAt)
+	}
+
+	if _, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "token-1", UserID: user.ID, ExpiresAt: expiresAt}); err == nil {
+		t.Error("CreateRefreshToken with a duplicate token succeeded")
+	}
+
+	owner, err := s.GetUserByRefreshToken("token-1")
+	if err != nil {
+		t.Fatalf("GetUserByRefreshToken: %v", err)
+	}
+	if owner == nil || owner.ID != user.ID {
+		t.Errorf("GetUserByRefreshToken = %+v, want user %s", owner, user.ID)
+	}
+
+	unknown, err := s.GetUserByRefreshToken("no-such-token")
+	if err != nil {
+		t.Fatalf("GetUserByRefreshToken for unknown token: %v", err)
+	}
+	if unknown != nil {
+		t.Errorf("GetUserByRefreshToken for unknown token = %+v, want nil", unknown)
+	}
+
+	if err := s.RevokeRefreshToken("token-1"); err != nil {
+		t.Fatalf("RevokeRefreshToken: %v", err)
+	}
+	revoked, err := s.GetRefreshToken("token-1")
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	if revoked.RevokedAt == nil {
+		t.Error("revoked refresh token has no revoked_at")
+	}
+	if owner, err := s.GetUserByRefreshToken("token-1"); err != nil || owner != nil {
+		t.Errorf("GetUserByRefreshToken for revoked token = %+v, %v; want nil", owner, err)
+	}
+
+	if _, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "expired", UserID: user.ID, ExpiresAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if owner, err := s.GetUserByRefreshToken("expired"); err != nil || owner != nil {
+		t.Errorf("GetUserByRefreshToken for expired token = %+v, %v; want nil", owner, err)
+	}
+	if _, err := s.RotateRefreshToken("expired", "from-expired", expiresAt, database.SessionClient{}); !errors.Is(err, database.ErrRefreshTokenInvalid) {
+		t.Errorf("RotateRefreshToken of an expired token = %v, want ErrRefreshTokenInvalid", err)
+	}
+	if _, err := s.RotateRefreshToken("no-such-token", "from-unknown", expiresAt, database.SessionClient{}); !errors.Is(err, database.ErrRefreshTokenInvalid) {
+		t.Errorf("RotateRefreshToken of an unknown token = %v, want ErrRefreshTokenInvalid", err)
+	}
+
+	if err := s.DeleteRefreshToken("token-1"); err != nil {
+		t.Fatalf("DeleteRefreshToken: %v", err)
+	}
+	deleted, err := s.GetRefreshToken("token-1")
+	if err != nil {
+		t.Fatalf("GetRefreshToken after delete: %v", err)
+	}
+	if deleted.Token != "" {
+		t.Errorf("GetRefreshToken after delete = %+v, want zero token", deleted)
+	}
+}
+
+func testRefreshTokenRotation(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+
+	first, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "first", UserID: user.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	other, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "other-login", UserID: user.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if first.FamilyID == uuid.Nil || first.FamilyID == other.FamilyID {
+		t.Errorf("family IDs = %s and %s, want two distinct families", first.FamilyID, other.FamilyID)
+	}
+
+	laterExpiry := expiresAt.Add(time.Hour)
+	second, err := s.RotateRefreshToken("first", "second", laterExpiry, database.SessionClient{})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if second.Token != "second" || second.UserID != user.ID || second.FamilyID != first.FamilyID || !second.ExpiresAt.Equal(laterExpiry) {
+		t.Errorf("rotated token = %+v, want second in family %s for %s", second, first.FamilyID, user.ID)
+	}
+	if rotated, _ := s.GetRefreshToken("first"); rotated.RevokedAt == nil || rotated.RotatedAt == nil {
+		t.Errorf("rotated-out token = %+v, want it revoked and rotated", rotated)
+	}
+	if owner, err := s.GetUserByRefreshToken("second"); err != nil || owner == nil || owner.ID != user.ID {
+		t.Errorf("GetUserByRefreshToken(second) = %+v, %v; want %s", owner, err, user.ID)
+	}
+
+	third, err := s.RotateRefreshToken("second", "third", laterExpiry, database.SessionClient{})
+	if err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	if _, err := s.RotateRefreshToken("first", "stolen", laterExpiry, database.SessionClient{}); !errors.Is(err, database.ErrRefreshTokenReused) {
+		t.Fatalf("RotateRefreshToken of a rotated token = %v, want ErrRefreshTokenReused", err)
+	}
+	if stolen, _ := s.GetRefreshToken("stolen"); stolen.Token != "" {
+		t.Errorf("reuse created token %+v", stolen)
+	}
+	if latest, _ := s.GetRefreshToken(third.Token); latest.RevokedAt == nil {
+		t.Error("reuse didn't revoke the rest of the family")
+	}
+	if _, err := s.RotateRefreshToken(third.Token, "fourth", laterExpiry, database.SessionClient{}); !errors.Is(err, database.ErrRefreshTokenInvalid) {
+		t.Errorf("RotateRefreshToken of a token revoked for reuse = %v, want ErrRefreshTokenInvalid", err)
+	}
+	if unrelated, _ := s.GetRefreshToken("other-login"); unrelated.RevokedAt != nil {
+		t.Error("reuse revoked a token from another family")
+	}
+}
+
+func testRefreshTokenRevokedNotReused(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+
+	// Logging out revokes the current token, which isn't reuse when it's
+	// presented again: the family isn't revoked for it.
+	if _, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "first", UserID: user.ID, ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if _, err := s.RotateRefreshToken("first", "second", expiresAt, database.SessionClient{}); err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+	if err := s.RevokeRefreshToken("second"); err != nil {
+		t.Fatalf("RevokeRefreshToken: %v", err)
+	}
+	if _, err := s.RotateRefreshToken("second", "third", expiresAt, database.SessionClient{}); !errors.Is(err, database.ErrRefreshTokenInvalid) {
+		t.Errorf("RotateRefreshToken of a logged-out token = %v, want ErrRefreshTokenInvalid", err)
+	}
+	if revoked, _ := s.GetRefreshToken("second"); revoked.RotatedAt != nil {
+		t.Errorf("logged-out token = %+v, want it not rotated", revoked)
+	}
+	if third, _ := s.GetRefreshToken("third"); third.Token != "" {
+		t.Errorf("logged-out token was rotated to %+v", third)
+	}
+
+	// The same goes for a session ended from another device or a user's
+	// tokens revoked when they're disabled.
+	session, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "session", UserID: user.ID, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if err := s.RevokeSession(session.FamilyID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if _, err := s.RotateRefreshToken("session", "from-session", expiresAt, database.SessionClient{}); !errors.Is(err, database.ErrRefreshTokenInvalid) {
+		t.Errorf("RotateRefreshToken of a revoked session's token = %v, want ErrRefreshTokenInvalid", err)
+	}
+	if _, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "disabled", UserID: user.ID, ExpiresAt: expiresAt}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if err := s.RevokeUserRefreshTokens(user.ID); err != nil {
+		t.Fatalf("RevokeUserRefreshTokens: %v", err)
+	}
+	if _, err := s.RotateRefreshToken("disabled", "from-disabled", expiresAt, database.SessionClient{}); !errors.Is(err, database.ErrRefreshTokenInvalid) {
+		t.Errorf("RotateRefreshToken of a token revoked with its user's = %v, want ErrRefreshTokenInvalid", err)
+	}
+}
+
+func testSessions(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	expiresAt := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	laptop := database.SessionClient{UserAgent: "Firefox", IPAddress: "192.0.2.1"}
+
+	create := func(token string, userID uuid.UUID, client database.SessionClient) database.RefreshToken {
+		t.Helper()
+		rt, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{
+			Token:         token,
+			UserID:        userID,
+			ExpiresAt:     expiresAt,
+			SessionClient: client,
+		})
+		if err != nil {
+			t.Fatalf("CreateRefreshToken(%q): %v", token, err)
+		}
+		return rt
+	}
+	phoneLogin := create("phone", alice.ID, database.SessionClient{UserAgent: "Safari", IPAddress: "198.51.100.7"})
+	laptopLogin := create("laptop", alice.ID, laptop)
+	create("bob", bob.ID, laptop)
+	if laptopLogin.UserAgent != "Firefox" || laptopLogin.IPAddress != "192.0.2.1" {
+		t.Errorf("created refresh token client = %+v, want %+v", laptopLogin.SessionClient, laptop)
+	}
+
+	moved := database.SessionClient{UserAgent: "Firefox", IPAddress: "203.0.113.9"}
+	if _, err := s.RotateRefreshToken("laptop", "laptop-2", expiresAt, moved); err != nil {
+		t.Fatalf("RotateRefreshToken: %v", err)
+	}
+
+	sessions, err := s.ListSessions(alice.ID)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("ListSessions returned %d sessions, want 2: %+v", len(sessions), sessions)
+	}
+	if sessions[0].ID != laptopLogin.FamilyID || sessions[0].SessionClient != moved {
+		t.Errorf("most recent session = %+v, want family %s from %+v", sessions[0], laptopLogin.FamilyID, moved)
+	}
+	if sessions[1].ID != phoneLogin.FamilyID || sessions[1].UserAgent != "Safari" {
+		t.Errorf("older session = %+v, want family %s", sessions[1], phoneLogin.FamilyID)
+	}
+	if !sessions[0].ExpiresAt.Equal(expiresAt) || sessions[0].LastUsedAt.IsZero() {
+		t.Errorf("session times = %v to %v, want last use and %v", sessions[0].LastUsedAt, sessions[0].ExpiresAt, expiresAt)
+	}
+
+	if err := s.RevokeSession(laptopLogin.FamilyID); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	sessions, err = s.ListSessions(alice.ID)
+	if err != nil {
+		t.Fatalf("ListSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != phoneLogin.FamilyID {
+		t.Errorf("sessions after revoking laptop = %+v, want only the phone", sessions)
+	}
+	if owner, err := s.GetUserByRefreshToken("laptop-2"); err != nil || owner != nil {
+		t.Errorf("GetUserByRefreshToken of a revoked session = %+v, %v; want nil", owner, err)
+	}
+
+	if err := s.RevokeUserRefreshTokens(alice.ID); err != nil {
+		t.Fatalf("RevokeUserRefreshTokens: %v", err)
+	}
+	if sessions, err := s.ListSessions(alice.ID); err != nil || len(sessions) != 0 {
+		t.Errorf("ListSessions after logging out everywhere = %+v, %v; want none", sessions, err)
+	}
+	if sessions, err := s.ListSessions(bob.ID); err != nil || len(sessions) != 1 {
+		t.Errorf("another user's sessions = %+v, %v; want 1", sessions, err)
+	}
+}
+
+func testAPIKeys(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+
+	key, err := s.CreateAPIKey(database.CreateAPIKeyParams{
+		UserID:  alice.ID,
+		Name:    "ci",
+		Prefix:  "abc123",
+		KeyHash: "hash-1",
+		Scopes:  []string{"videos:read", "uploads"},
+	})
+	if err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	if key.ID == uuid.Nil || key.CreatedAt.IsZero() || key.LastUsedAt != nil || key.RevokedAt != nil {
+		t.Errorf("created key = %+v, want a fresh unused key", key)
+	}
+	if !slices.Equal(key.Scopes, []string{"videos:read", "uploads"}) {
+		t.Errorf("Scopes = %v, want videos:read and uploads", key.Scopes)
+	}
+	if _, err := s.CreateAPIKey(database.CreateAPIKeyParams{UserID: bob.ID, Name: "dup", Prefix: "abc123", KeyHash: "hash-2"}); err == nil {
+		t.Error("CreateAPIKey with a duplicate prefix succeeded")
+	}
+
+	got, err := s.GetAPIKeyByPrefix("abc123")
+	if err != nil {
+		t.Fatalf("GetAPIKeyByPrefix: %v", err)
+	}
+	if got.ID != key.ID || got.KeyHash != "hash-1" || got.UserID != alice.ID {
+		t.Errorf("GetAPIKeyByPrefix = %+v, want key %s", got, key.ID)
+	}
+	if missing, err := s.GetAPIKeyByPrefix("nope"); err != nil || missing.ID != uuid.Nil {
+		t.Errorf("GetAPIKeyByPrefix(missing) = %+v, %v; want zero value", missing, err)
+	}
+
+	usedAt := time.Now().UTC().Truncate(time.Microsecond)
+	if err := s.MarkAPIKeyUsed(key.ID, usedAt); err != nil {
+		t.Fatalf("MarkAPIKeyUsed: %v", err)
+	}
+	if err := s.RenameAPIKey(key.ID, "deploys"); err != nil {
+		t.Fatalf("RenameAPIKey: %v", err)
+	}
+	got, err = s.GetAPIKey(key.ID)
+	if err != nil {
+		t.Fatalf("GetAPIKey: %v", err)
+	}
+	if got.Name != "deploys" || got.LastUsedAt == nil || !got.LastUsedAt.Equal(usedAt) {
+		t.Errorf("key after use and rename = %+v, want name deploys last used at %v", got, usedAt)
+	}
+
+	if err := s.RevokeAPIKey(key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	revoked, err := s.GetAPIKeyByPrefix("abc123")
+	if err != nil || revoked.RevokedAt == nil {
+		t.Fatalf("GetAPIKeyByPrefix after revoking = %+v, %v; want a revoked key", revoked, err)
+	}
+
+	keys, err := s.ListAPIKeys(alice.ID)
+	if err != nil {
+		t.Fatalf("ListAPIKeys: %v", err)
+	}
+	if len(keys) != 1 || keys[0].ID != key.ID {
+		t.Errorf("ListAPIKeys(alice) = %+v, want the revoked key", keys)
+	}
+	if keys, err := s.ListAPIKeys(bob.ID); err != nil || len(keys) != 0 {
+		t.Errorf("ListAPIKeys(bob) = %+v, %v; want none", keys, err)
+	}
+}
+
+func testTOTP(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+
+	none, err := s.GetTOTP(user.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if none.UserID != uuid.Nil || none.Enabled() {
+		t.Errorf("GetTOTP before enrolling = %+v, want zero", none)
+	}
+
+	if err := s.StartTOTPEnrollment(user.ID, "FIRSTSECRET"); err != nil {
+		t.Fatalf("StartTOTPEnrollment: %v", err)
+	}
+	if err := s.StartTOTPEnrollment(user.ID, "SECONDSECRET"); err != nil {
+		t.Fatalf("StartTOTPEnrollment again: %v", err)
+	}
+	pending, err := s.GetTOTP(user.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if pending.Secret != "SECONDSECRET" || pending.Enabled() {
+		t.Errorf("pending enrollment = %+v, want the second secret unconfirmed", pending)
+	}
+
+	if err := s.ConfirmTOTP(user.ID, 100, []string{"hash-a", "hash-b"}); err != nil {
+		t.Fatalf("ConfirmTOTP: %v", err)
+	}
+	if err := s.StartTOTPEnrollment(user.ID, "THIRDSECRET"); err != nil {
+		t.Fatalf("StartTOTPEnrollment after confirming: %v", err)
+	}
+	enabled, err := s.GetTOTP(user.ID)
+	if err != nil {
+		t.Fatalf("GetTOTP: %v", err)
+	}
+	if !enabled.Enabled() || enabled.Secret != "SECONDSECRET" || enabled.LastUsedStep != 100 || enabled.RecoveryCodesLeft != 2 {
+		t.Errorf("confirmed TOTP = %+v, want the second secret, step 100 and 2 recovery codes", enabled)
+	}
+
+	for _, tc := range []struct {
+		step int64
+		want bool
+	}{{100, false}, {99, false}, {101, true}, {101, false}} {
+		ok, err := s.UseTOTPStep(user.ID, tc.step)
+		if err != nil {
+			t.Fatalf("UseTOTPStep(%d): %v", tc.step, err)
+		}
+		if ok != tc.want {
+			t.Errorf("UseTOTPStep(%d) = %v, want %v", tc.step, ok, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		hash string
+		want bool
+	}{{"hash-a", true}, {"hash-a", false}, {"hash-c", false}} {
+		ok, err := s.UseRecoveryCode(user.ID, tc.hash)
+		if err != nil {
+			t.Fatalf("UseRecoveryCode(%q): %v", tc.hash, err)
+		}
+		if ok != tc.want {
+			t.Errorf("UseRecoveryCode(%q) = %v, want %v", tc.hash, ok, tc.want)
+		}
+	}
+	if got, _ := s.GetTOTP(user.ID); got.RecoveryCodesLeft != 1 {
+		t.Errorf("recovery codes left = %d, want 1", got.RecoveryCodesLeft)
+	}
+
+	if err := s.ReplaceRecoveryCodes(user.ID, []string{"hash-a", "hash-d", "hash-e"}); err != nil {
+		t.Fatalf("ReplaceRecoveryCodes: %v", err)
+	}
+	if ok, _ := s.UseRecoveryCode(user.ID, "hash-b"); ok {
+		t.Error("a replaced recovery code still worked")
+	}
+	if ok, _ := s.UseRecoveryCode(user.ID, "hash-a"); !ok {
+		t.Error("a reissued recovery code didn't work")
+	}
+	if got, _ := s.GetTOTP(user.ID); got.RecoveryCodesLeft != 2 {
+		t.Errorf("recovery codes left after replacing = %d, want 2", got.RecoveryCodesLeft)
+	}
+
+	const maxFailures, lockout = 3, 15 * time.Minute
+	attempt := func(at time.Time) bool {
+		t.Helper()
+		ok, err := s.RecordTOTPAttempt(user.ID, at, maxFailures, lockout)
+		if err != nil {
+			t.Fatalf("RecordTOTPAttempt: %v", err)
+		}
+		return ok
+	}
+	failedAt := time.Now().UTC().Truncate(time.Second)
+	for range maxFailures {
+		if !attempt(failedAt) {
+			t.Fatal("attempt under the limit was refused")
+		}
+	}
+	if got, _ := s.GetTOTP(user.ID); got.FailedAttempts != maxFailures || got.LastFailedAt == nil || !got.LastFailedAt.Equal(failedAt) {
+		t.Errorf("after %d attempts = %d at %v, want %d at %v", maxFailures, got.FailedAttempts, got.LastFailedAt, maxFailures, failedAt)
+	}
+	if attempt(failedAt.Add(lockout - time.Second)) {
+		t.Error("attempt during the lockout was allowed")
+	}
+	if got, _ := s.GetTOTP(user.ID); got.FailedAttempts != maxFailures || !got.LastFailedAt.Equal(failedAt) {
+		t.Errorf("refused attempt was counted: %d at %v", got.FailedAttempts, got.LastFailedAt)
+	}
+	if !attempt(failedAt.Add(lockout)) {
+		t.Error("attempt after the lockout was refused")
+	}
+	if got, _ := s.GetTOTP(user.ID); got.FailedAttempts != 1 {
+		t.Errorf("after the lockout the count is %d, want it to start over at 1", got.FailedAttempts)
+	}
+	if err := s.ClearTOTPFailures(user.ID); err != nil {
+		t.Fatalf("ClearTOTPFailures: %v", err)
+	}
+	if got, _ := s.GetTOTP(user.ID); got.FailedAttempts != 0 || got.LastFailedAt != nil {
+		t.Errorf("after clearing = %d at %v, want none", got.FailedAttempts, got.LastFailedAt)
+	}
+	if ok, _ := s.RecordTOTPAttempt(uuid.New(), failedAt, maxFailures, lockout); ok {
+		t.Error("attempt for a user without TOTP was allowed")
+	}
+
+	if err := s.DeleteTOTP(user.ID); err != nil {
+		t.Fatalf("DeleteTOTP: %v", err)
+	}
+	if got, _ := s.GetTOTP(user.ID); got.UserID != uuid.Nil {
+		t.Errorf("GetTOTP after delete = %+v, want zero", got)
+	}
+	if ok, _ := s.UseRecoveryCode(user.ID, "hash-d"); ok {
+		t.Error("a recovery code worked after TOTP was turned off")
+	}
+}
+
+func testEmailTokens(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	if alice.EmailVerifiedAt != nil {
+		t.Errorf("new user's email is verified at %v", alice.EmailVerifiedAt)
+	}
+
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	for _, p := range []database.CreateEmailTokenParams{
+		{UserID: alice.ID, Purpose: database.EmailTokenVerifyEmail, TokenHash: "verify-old", ExpiresAt: expiresAt},
+		{UserID: alice.ID, Purpose: database.EmailTokenVerifyEmail, TokenHash: "verify-new", ExpiresAt: expiresAt},
+		{UserID: alice.ID, Purpose: database.EmailTokenResetPassword, TokenHash: "reset", ExpiresAt: expiresAt},
+		{UserID: bob.ID, Purpose: database.EmailTokenResetPassword, TokenHash: "expired", ExpiresAt: time.Now().UTC().Add(-time.Minute)},
+	} {
+		if err := s.CreateEmailToken(p); err != nil {
+			t.Fatalf("CreateEmailToken(%q): %v", p.TokenHash, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		purpose database.EmailTokenPurpose
+		hash    string
+		want    uuid.UUID
+	}{
+		{database.EmailTokenVerifyEmail, "verify-new", alice.ID},
+		{database.EmailTokenResetPassword, "verify-new", uuid.Nil},
+		{database.EmailTokenVerifyEmail, "verify-old", uuid.Nil},
+		{database.EmailTokenResetPassword, "expired", uuid.Nil},
+	} {
+		got, err := s.GetEmailTokenUser(tc.purpose, tc.hash)
+		if err != nil {
+			t.Fatalf("GetEmailTokenUser(%s, %q): %v", tc.purpose, tc.hash, err)
+		}
+		if got != tc.want {
+			t.Errorf("GetEmailTokenUser(%s, %q) = %s, want %s", tc.purpose, tc.hash, got, tc.want)
+		}
+	}
+
+	for _, tc := range []struct {
+		purpose database.EmailTokenPurpose
+		hash    string
+		want    uuid.UUID
+	}{
+		{database.EmailTokenVerifyEmail, "verify-old", uuid.Nil},
+		{database.EmailTokenResetPassword, "verify-new", uuid.Nil},
+		{database.EmailTokenVerifyEmail, "verify-new", alice.ID},
+		{database.EmailTokenVerifyEmail, "verify-new", uuid.Nil},
+		{database.EmailTokenResetPassword, "reset", alice.ID},
+		{database.EmailTokenResetPassword, "expired", uuid.Nil},
+		{database.EmailTokenResetPassword, "unknown", uuid.Nil},
+	} {
+		got, err := s.UseEmailToken(tc.purpose, tc.hash)
+		if err != nil {
+			t.Fatalf("UseEmailToken(%s, %q): %v", tc.purpose, tc.hash, err)
+		}
+		if got != tc.want {
+			t.Errorf("UseEmailToken(%s, %q) = %s, want %s", tc.purpose, tc.hash, got, tc.want)
+		}
+	}
+	if got, _ := s.GetEmailTokenUser(database.EmailTokenResetPassword, "reset"); got != uuid.Nil {
+		t.Errorf("GetEmailTokenUser of a used token = %s, want none", got)
+	}
+
+	if err := s.SetUserEmailVerified(alice.ID); err != nil {
+		t.Fatalf("SetUserEmailVerified: %v", err)
+	}
+	verified, err := s.GetUser(alice.ID)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	if verified.EmailVerifiedAt == nil {
+		t.Fatal("email_verified_at not set")
+	}
+	if err := s.SetUserEmailVerified(alice.ID); err != nil {
+		t.Fatalf("SetUserEmailVerified again: %v", err)
+	}
+	again, _ := s.GetUser(alice.ID)
+	if again.EmailVerifiedAt == nil || !again.EmailVerifiedAt.Equal(*verified.EmailVerifiedAt) {
+		t.Errorf("verifying again moved email_verified_at from %v to %v", verified.EmailVerifiedAt, again.EmailVerifiedAt)
+	}
+
+	if err := s.SetUserPassword(alice.ID, "new-hash"); err != nil {
+		t.Fatalf("SetUserPassword: %v", err)
+	}
+	if got, _ := s.GetUserByEmail("alice@example.com"); got.Password != "new-hash" {
+		t.Errorf("password after SetUserPassword = %q, want %q", got.Password, "new-hash")
+	}
+}
+
+func testLoginCodes(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+
+	expiresAt := time.Now().UTC().Add(time.Minute)
+	for _, p := range []database.CreateLoginCodeParams{
+		{UserID: alice.ID, CodeHash: "alice-1", ExpiresAt: expiresAt},
+		{UserID: alice.ID, CodeHash: "alice-2", ExpiresAt: expiresAt},
+		{UserID: bob.ID, CodeHash: "expired", ExpiresAt: time.Now().UTC().Add(-time.Minute)},
+		{UserID: bob.ID, CodeHash: "bob", ExpiresAt: expiresAt},
+	} {
+		if err := s.CreateLoginCode(p); err != nil {
+			t.Fatalf("CreateLoginCode(%q): %v", p.CodeHash, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		hash string
+		want uuid.UUID
+	}{
+		{"alice-1", alice.ID},
+		{"alice-1", uuid.Nil},
+		{"alice-2", alice.ID},
+		{"expired", uuid.Nil},
+		{"bob", bob.ID},
+		{"unknown", uuid.Nil},
+	} {
+		got, err := s.UseLoginCode(tc.hash)
+		if err != nil {
+			t.Fatalf("UseLoginCode(%q): %v", tc.hash, err)
+		}
+		if got != tc.want {
+			t.Errorf("UseLoginCode(%q) = %s, want %s", tc.hash, got, tc.want)
+		}
+	}
+}
+
+func testIdentities(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	const issuer = "https://idp.example.com"
+
+	none, err := s.GetUserByIdentity(issuer, "alice-sub")
+	if err != nil {
+		t.Fatalf("GetUserByIdentity: %v", err)
+	}
+	if none != nil {
+		t.Errorf("GetUserByIdentity before linking = %+v, want nil", none)
+	}
+
+	if err := s.LinkIdentity(alice.ID, issuer, "alice-sub"); err != nil {
+		t.Fatalf("LinkIdentity: %v", err)
+	}
+	got, err := s.GetUserByIdentity(issuer, "alice-sub")
+	if err != nil {
+		t.Fatalf("GetUserByIdentity: %v", err)
+	}
+	if got == nil || got.ID != alice.ID {
+		t.Errorf("GetUserByIdentity = %+v, want alice", got)
+	}
+	if other, _ := s.GetUserByIdentity("https://other.example.com", "alice-sub"); other != nil {
+		t.Errorf("the same subject at another issuer found %+v", other)
+	}
+
+	bob := mustCreateUser(t, s, "bob@example.com")
+	if err := s.LinkIdentity(bob.ID, issuer, "alice-sub"); err == nil {
+		t.Error("linking an identity that's already linked succeeded")
+	}
+}
+
+func testStorageDeletions(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+	video := mustCreateVideo(t, s, user.ID, "first")
+
+	err := s.DeleteVideoWithObjects(video.ID, []database.StorageObject{
+		{Backend: "videos", Key: "landscape/abc.mp4"},
+		{Backend: "thumbnails", Key: "abc.png"},
+	})
+	if err != nil {
+		t.Fatalf("DeleteVideoWithObjects: %v", err)
+	}
+	gone, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if gone.ID != uuid.Nil {
+		t.Error("video still exists after DeleteVideoWithObjects")
+	}
+
+	claimed, err := s.ClaimStorageDeletions(10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimStorageDeletions: %v", err)
+	}
+	if len(claimed) != 2 {
+		t.Fatalf("claimed %d deletions, want 2", len(claimed))
+	}
+	for _, d := range claimed {
+		if d.VideoID != video.ID {
+			t.Errorf("deletion %+v belongs to video %s, want %s", d.StorageObject, d.VideoID, video.ID)
+		}
+	}
+
+	again, err := s.ClaimStorageDeletions(10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimStorageDeletions: %v", err)
+	}
+	if len(again) != 0 {
+		t.Errorf("claimed %d leased deletions, want 0", len(again))
+	}
+
+	if err := s.CompleteStorageDeletion(claimed[0].ID); err != nil {
+		t.Fatalf("CompleteStorageDeletion: %v", err)
+	}
+	if err := s.FailStorageDeletion(claimed[1].ID, "access denied", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("FailStorageDeletion: %v", err)
+	}
+
+	retried, err := s.ClaimStorageDeletions(10, time.Minute)
+	if err != nil {
+		t.Fatalf("ClaimStorageDeletions: %v", err)
+	}
+	if len(retried) != 1 || retried[0].ID != claimed[1].ID {
+		t.Fatalf("claimed %+v after a failure, want only the failed deletion", retried)
+	}
+	if retried[0].Attempts != 1 || retried[0].LastError == nil || *retried[0].LastError != "access denied" {
+		t.Errorf("failed deletion = attempts %d, last error %v; want 1 and %q", retried[0].Attempts, retried[0].LastError, "access denied")
+	}
+}
+
+func testTrash(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+	kept := mustCreateVideo(t, s, user.ID, "kept")
+	trashed := mustCreateVideo(t, s, user.ID, "trashed")
+
+	if err := s.TrashVideo(trashed.ID); err != nil {
+		t.Fatalf("TrashVideo: %v", err)
+	}
+
+	got, err := s.GetVideo(trashed.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if got.ID != uuid.Nil {
+		t.Error("GetVideo returned a trashed video")
+	}
+	videos, err := s.GetVideos(user.ID)
+	if err != nil {
+		t.Fatalf("GetVideos: %v", err)
+	}
+	if len(videos) != 1 || videos[0].ID != kept.ID {
+		t.Errorf("GetVideos returned %d videos, want only the kept one", len(videos))
+	}
+	if titles := listAll(t, s, database.ListVideosParams{UserID: user.ID, Limit: 10}); !equalTitles(titles, []string{"kept"}) {
+		t.Errorf("ListVideos = %v, want [kept]", titles)
+	}
+
+	inTrash, err := s.GetTrashedVideo(trashed.ID)
+	if err != nil {
+		t.Fatalf("GetTrashedVideo: %v", err)
+	}
+	if inTrash.ID != trashed.ID || inTrash.DeletedAt == nil {
+		t.Errorf("GetTrashedVideo = %+v, want the trashed video with DeletedAt set", inTrash)
+	}
+	inTrash.Title = "renamed in the trash"
+	if err := s.UpdateVideo(inTrash); !errors.Is(err, database.ErrVideoNotFound) {
+		t.Errorf("UpdateVideo of a trashed video: err = %v, want ErrVideoNotFound", err)
+	}
+	if err := s.UpdateVideoIfUnmodified(inTrash, inTrash.UpdatedAt); !errors.Is(err, database.ErrVideoNotFound) {
+		t.Errorf("UpdateVideoIfUnmodified of a trashed video: err = %v, want ErrVideoNotFound", err)
+	}
+	if got, _ := s.GetTrashedVideo(trashed.ID); got.Title != "trashed" {
+		t.Errorf("trashed video's title = %q, want it unchanged", got.Title)
+	}
+	notTrashed, err := s.GetTrashedVideo(kept.ID)
+	if err != nil {
+		t.Fatalf("GetTrashedVideo: %v", err)
+	}
+	if notTrashed.ID != uuid.Nil {
+		t.Error("GetTrashedVideo returned a video that isn't in the trash")
+	}
+
+	trash, err := s.ListTrashedVideos(user.ID)
+	if err != nil {
+		t.Fatalf("ListTrashedVideos: %v", err)
+	}
+	if len(trash) != 1 || trash[0].ID != trashed.ID {
+		t.Errorf("ListTrashedVideos returned %d videos, want only the trashed one", len(trash))
+	}
+
+	expired, err := s.ListVideosTrashedBefore(time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("ListVideosTrashedBefore: %v", err)
+	}
+	if len(expired) != 1 || expired[0].ID != trashed.ID {
+		t.Errorf("ListVideosTrashedBefore(+1h) returned %d videos, want the trashed one", len(expired))
+	}
+	expired, err = s.ListVideosTrashedBefore(time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatalf("ListVideosTrashedBefore: %v", err)
+	}
+	if len(expired) != 0 {
+		t.Errorf("ListVideosTrashedBefore(-1h) returned %d videos, want 0", len(expired))
+	}
+
+	if err := s.RestoreVideo(trashed.ID); err != nil {
+		t.Fatalf("RestoreVideo: %v", err)
+	}
+	restored, err := s.GetVideo(trashed.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if restored.ID != trashed.ID || restored.DeletedAt != nil {
+		t.Errorf("GetVideo after restore = %+v, want the restored video", restored)
+	}
+	trash, err = s.ListTrashedVideos(user.ID)
+	if err != nil {
+		t.Fatalf("ListTrashedVideos: %v", err)
+	}
+	if len(trash) != 0 {
+		t.Errorf("ListTrashedVideos returned %d videos after restore, want 0", len(trash))
+	}
+}
+
+func testTags(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	cooking := mustCreateVideo(t, s, alice.ID, "cooking")
+	hiking := mustCreateVideo(t, s, alice.ID, "hiking")
+	bobs := mustCreateVideo(t, s, bob.ID, "bobs")
+
+	tags, err := s.SetVideoTags(cooking.ID, []string{"  Food ", "howto", "food", "", "Slow  Cooking"})
+	if err != nil {
+		t.Fatalf("SetVideoTags: %v", err)
+	}
+	if want := []string{"food", "howto", "slow cooking"}; !equalTitles(tags, want) {
+		t.Errorf("SetVideoTags = %v, want %v", tags, want)
+	}
+	if _, err := s.SetVideoTags(hiking.ID, []string{"outdoors", "howto"}); err != nil {
+		t.Fatalf("SetVideoTags: %v", err)
+	}
+	if _, err := s.SetVideoTags(bobs.ID, []string{"food"}); err != nil {
+		t.Fatalf("SetVideoTags: %v", err)
+	}
+
+	tags, err = s.GetVideoTags(hiking.ID)
+	if err != nil {
+		t.Fatalf("GetVideoTags: %v", err)
+	}
+	if want := []string{"howto", "outdoors"}; !equalTitles(tags, want) {
+		t.Errorf("GetVideoTags = %v, want %v", tags, want)
+	}
+
+	counts, err := s.ListTagCounts(alice.ID)
+	if err != nil {
+		t.Fatalf("ListTagCounts: %v", err)
+	}
+	want := []database.TagCount{{Name: "howto", Count: 2}, {Name: "food", Count: 1}, {Name: "outdoors", Count: 1}, {Name: "slow cooking", Count: 1}}
+	if !slices.Equal(counts, want) {
+		t.Errorf("ListTagCounts = %v, want %v", counts, want)
+	}
+
+	titles := listAll(t, s, database.ListVideosParams{UserID: alice.ID, Tag: "HowTo", Limit: 1, Sort: database.VideoSortTitle, Ascending: true})
+	if want := []string{"cooking", "hiking"}; !equalTitles(titles, want) {
+		t.Errorf("ListVideos(tag=howto) = %v, want %v", titles, want)
+	}
+	titles = listAll(t, s, database.ListVideosParams{Tag: "food", Limit: 10, Sort: database.VideoSortTitle, Ascending: true})
+	if want := []string{"bobs", "cooking"}; !equalTitles(titles, want) {
+		t.Errorf("ListVideos(tag=food) across users = %v, want %v", titles, want)
+	}
+
+	if _, err := s.SetVideoTags(cooking.ID, nil); err != nil {
+		t.Fatalf("SetVideoTags: %v", err)
+	}
+	tags, err = s.GetVideoTags(cooking.ID)
+	if err != nil {
+		t.Fatalf("GetVideoTags: %v", err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("GetVideoTags after clearing = %v, want none", tags)
+	}
+
+	if err := s.TrashVideo(hiking.ID); err != nil {
+		t.Fatalf("TrashVideo: %v", err)
+	}
+	counts, err = s.ListTagCounts(alice.ID)
+	if err != nil {
+		t.Fatalf("ListTagCounts: %v", err)
+	}
+	if len(counts) != 0 {
+		t.Errorf("ListTagCounts with every tagged video cleared or trashed = %v, want none", counts)
+	}
+
+	if err := s.DeleteVideo(bobs.ID); err != nil {
+		t.Fatalf("DeleteVideo: %v", err)
+	}
+	tags, err = s.GetVideoTags(bobs.ID)
+	if err != nil {
+		t.Fatalf("GetVideoTags: %v", err)
+	}
+	if len(tags) != 0 {
+		t.Errorf("GetVideoTags of a deleted video = %v, want none", tags)
+	}
+}
+
+// playlistTitles returns the titles of a playlist's videos, checking that
+// positions are contiguous.
+func playlistTitles(t *testing.T, s database.Store, playlistID uuid.UUID) []string {
+	t.Helper()
+	items, err := s.GetPlaylistItems(playlistID)
+	if err != nil {
+		t.Fatalf("GetPlaylistItems: %v", err)
+	}
+	titles := []string{}
+	for i, item := range items {
+		if item.Position != i {
+			t.Errorf("item %q is at position %d, want %d", item.Video.Title, item.Position, i)
+		}
+		titles = append(titles, item.Video.Title)
+	}
+	return titles
+}
+
+func testPlaylists(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+	a := mustCreateVideo(t, s, user.ID, "a")
+	b := mustCreateVideo(t, s, user.ID, "b")
+	c := mustCreateVideo(t, s, user.ID, "c")
+	d := mustCreateVideo(t, s, user.ID, "d")
+
+	p, err := s.CreatePlaylist(database.CreatePlaylistParams{UserID: user.ID, Title: "mix"})
+	if err != nil {
+		t.Fatalf("CreatePlaylist: %v", err)
+	}
+	if p.ID == uuid.Nil || p.Title != "mix" || p.UserID != user.ID || p.Visibility != database.VisibilityPrivate {
+		t.Fatalf("CreatePlaylist = %+v", p)
+	}
+
+	for _, v := range []database.Video{a, b, c} {
+		if err := s.AddPlaylistItem(p.ID, v.ID, -1); err != nil {
+			t.Fatalf("AddPlaylistItem(%s): %v", v.Title, err)
+		}
+	}
+	if err := s.AddPlaylistItem(p.ID, d.ID, 1); err != nil {
+		t.Fatalf("AddPlaylistItem at 1: %v", err)
+	}
+	if got, want := playlistTitles(t, s, p.ID), []string{"a", "d", "b", "c"}; !equalTitles(got, want) {
+		t.Errorf("after adding = %v, want %v", got, want)
+	}
+	if err := s.AddPlaylistItem(p.ID, a.ID, -1); !errors.Is(err, database.ErrPlaylistItemExists) {
+		t.Errorf("adding a video twice: got %v, want ErrPlaylistItemExists", err)
+	}
+	if err := s.AddPlaylistItem(uuid.New(), a.ID, -1); !errors.Is(err, database.ErrPlaylistNotFound) {
+		t.Errorf("adding to a missing playlist: got %v, want ErrPlaylistNotFound", err)
+	}
+
+	if err := s.MovePlaylistItem(p.ID, c.ID, 0); err != nil {
+		t.Fatalf("MovePlaylistItem: %v", err)
+	}
+	if got, want := playlistTitles(t, s, p.ID), []string{"c", "a", "d", "b"}; !equalTitles(got, want) {
+		t.Errorf("after moving c to the front = %v, want %v", got, want)
+	}
+	if err := s.MovePlaylistItem(p.ID, a.ID, 99); err != nil {
+		t.Fatalf("MovePlaylistItem: %v", err)
+	}
+	if got, want := playlistTitles(t, s, p.ID), []string{"c", "d", "b", "a"}; !equalTitles(got, want) {
+		t.Errorf("after moving a to the end = %v, want %v", got, want)
+	}
+	if err := s.MovePlaylistItem(p.ID, d.ID, 2); err != nil {
+		t.Fatalf("MovePlaylistItem: %v", err)
+	}
+	if got, want := playlistTitles(t, s, p.ID), []string{"c", "b", "d", "a"}; !equalTitles(got, want) {
+		t.Errorf("after moving d down one = %v, want %v", got, want)
+	}
+	if err := s.MovePlaylistItem(p.ID, uuid.New(), 0); !errors.Is(err, database.ErrPlaylistItemNotFound) {
+		t.Errorf("moving a video not in the playlist: got %v, want ErrPlaylistItemNotFound", err)
+	}
+
+	if err := s.RemovePlaylistItem(p.ID, b.ID); err != nil {
+		t.Fatalf("RemovePlaylistItem: %v", err)
+	}
+	if got, want := playlistTitles(t, s, p.ID), []string{"c", "d", "a"}; !equalTitles(got, want) {
+		t.Errorf("after removing b = %v, want %v", got, want)
+	}
+	if err := s.RemovePlaylistItem(p.ID, b.ID); !errors.Is(err, database.ErrPlaylistItemNotFound) {
+		t.Errorf("removing b again: got %v, want ErrPlaylistItemNotFound", err)
+	}
+
+	if err := s.DeleteVideo(c.ID); err != nil {
+		t.Fatalf("DeleteVideo: %v", err)
+	}
+	if got, want := playlistTitles(t, s, p.ID), []string{"d", "a"}; !equalTitles(got, want) {
+		t.Errorf("after deleting video c = %v, want %v", got, want)
+	}
+
+	p.Title = "renamed"
+	p.Visibility = database.VisibilityPublic
+	if err := s.UpdatePlaylist(p); err != nil {
+		t.Fatalf("UpdatePlaylist: %v", err)
+	}
+	got, err := s.GetPlaylist(p.ID)
+	if err != nil {
+		t.Fatalf("GetPlaylist: %v", err)
+	}
+	if got.Title != "renamed" || got.Visibility != database.VisibilityPublic || got.UpdatedAt.Before(p.UpdatedAt) {
+		t.Errorf("GetPlaylist after update = %+v", got)
+	}
+
+	other, err := s.CreatePlaylist(database.CreatePlaylistParams{UserID: user.ID, Title: "other"})
+	if err != nil {
+		t.Fatalf("CreatePlaylist: %v", err)
+	}
+	playlists, err := s.ListPlaylists(user.ID)
+	if err != nil {
+		t.Fatalf("ListPlaylists: %v", err)
+	}
+	if len(playlists) != 2 {
+		t.Errorf("ListPlaylists returned %d playlists, want 2", len(playlists))
+	}
+
+	if err := s.DeletePlaylist(other.ID); err != nil {
+		t.Fatalf("DeletePlaylist: %v", err)
+	}
+	gone, err := s.GetPlaylist(other.ID)
+	if err != nil {
+		t.Fatalf("GetPlaylist: %v", err)
+	}
+	if gone.ID != uuid.Nil {
+		t.Error("GetPlaylist returned a deleted playlist")
+	}
+}
+
+func testViews(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+	video := mustCreateVideo(t, s, user.ID, "clip")
+	video.Duration = 100
+	if err := s.UpdateVideo(video); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+
+	first, counted, err := s.RecordView(video.ID, "viewer-a", 30*time.Minute)
+	if err != nil {
+		t.Fatalf("RecordView: %v", err)
+	}
+	if !counted || first.ID == uuid.Nil || first.VideoID != video.ID {
+		t.Fatalf("RecordView = %+v, counted %v; want a new counted view", first, counted)
+	}
+	again, counted, err := s.RecordView(video.ID, "viewer-a", 30*time.Minute)
+	if err != nil {
+		t.Fatalf("RecordView: %v", err)
+	}
+	if counted || again.ID != first.ID {
+		t.Errorf("repeated RecordView within the window = %s, counted %v; want %s not counted", again.ID, counted, first.ID)
+	}
+	other, counted, err := s.RecordView(video.ID, "viewer-b", 30*time.Minute)
+	if err != nil {
+		t.Fatalf("RecordView: %v", err)
+	}
+	if !counted || other.ID == first.ID {
+		t.Errorf("RecordView by another viewer wasn't counted separately")
+	}
+
+	for _, hb := range []struct {
+		id       uuid.UUID
+		position float64
+	}{{first.ID, 55}, {first.ID, 30}, {other.ID, 100}} {
+		if err := s.RecordViewHeartbeat(hb.id, hb.position, time.Minute); err != nil {
+			t.Fatalf("RecordViewHeartbeat: %v", err)
+		}
+	}
+	got, err := s.GetView(first.ID)
+	if err != nil {
+		t.Fatalf("GetView: %v", err)
+	}
+	if got.MaxPosition != 55 {
+		t.Errorf("MaxPosition = %v, want 55", got.MaxPosition)
+	}
+	if got.WatchSeconds < 0 || got.WatchSeconds > 60 {
+		t.Errorf("WatchSeconds = %v, want at most the one minute gap cap", got.WatchSeconds)
+	}
+	missing, err := s.GetView(uuid.New())
+	if err != nil {
+		t.Fatalf("GetView: %v", err)
+	}
+	if missing.ID != uuid.Nil {
+		t.Error("GetView returned a view that doesn't exist")
+	}
+
+	now := time.Now()
+	for range 2 {
+		if err := s.RollupViewStats(now.Add(-time.Hour)); err != nil {
+			t.Fatalf("RollupViewStats: %v", err)
+		}
+	}
+	stats, err := s.GetVideoStats(video.ID, now.Add(-48*time.Hour), now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetVideoStats: %v", err)
+	}
+	if len(stats.Daily) != 1 {
+		t.Fatalf("GetVideoStats returned %d days, want 1", len(stats.Daily))
+	}
+	if day := stats.Daily[0]; day.Views != 2 || day.UniqueViewers != 2 || !day.Day.Equal(now.UTC().Truncate(24*time.Hour)) {
+		t.Errorf("daily stats = %+v, want 2 views by 2 viewers today", day)
+	}
+	want := [database.RetentionBuckets + 1]int{2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1}
+	if stats.Retention != want {
+		t.Errorf("Retention = %v, want %v", stats.Retention, want)
+	}
+
+	if _, counted, err := s.RecordView(video.ID, "viewer-a", 0); err != nil || !counted {
+		t.Fatalf("RecordView with no dedup window: counted %v, err %v; want a counted view", counted, err)
+	}
+	if err := s.RollupViewStats(now.Add(-time.Hour)); err != nil {
+		t.Fatalf("RollupViewStats: %v", err)
+	}
+	stats, err = s.GetVideoStats(video.ID, now.Add(-48*time.Hour), now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetVideoStats: %v", err)
+	}
+	if day := stats.Daily[0]; day.Views != 3 || day.UniqueViewers != 2 {
+		t.Errorf("daily stats after a repeat view = %+v, want 3 views by 2 viewers", day)
+	}
+	if stats.UniqueViewers != 2 {
+		t.Errorf("UniqueViewers = %d, want 2", stats.UniqueViewers)
+	}
+
+	stats, err = s.GetVideoStats(video.ID, now.Add(-96*time.Hour), now.Add(-72*time.Hour))
+	if err != nil {
+		t.Fatalf("GetVideoStats: %v", err)
+	}
+	if len(stats.Daily) != 0 || stats.Retention[0] != 0 || stats.UniqueViewers != 0 {
+		t.Errorf("GetVideoStats for days without views = %+v, want nothing", stats)
+	}
+}
+
+// listCommentBodies follows cursors through a comment listing and returns
+// the bodies in the order they were served.
+func listCommentBodies(t *testing.T, s database.Store, params database.ListCommentsParams) []string {
+	t.Helper()
+	bodies := []string{}
+	for range 20 {
+		page, err := s.ListComments(params)
+		if err != nil {
+			t.Fatalf("ListComments(%+v): %v", params, err)
+		}
+		for _, c := range page.Items {
+			bodies = append(bodies, c.Body)
+		}
+		if page.NextCursor == nil {
+			return bodies
+		}
+		params.Cursor = *page.NextCursor
+	}
+	t.Fatal("ListComments kept returning cursors")
+	return nil
+}
+
+func testComments(t *testing.T, s database.Store) {
+	owner := mustCreateUser(t, s, "alice@example.com")
+	viewer := mustCreateUser(t, s, "bob@example.com")
+	video := mustCreateVideo(t, s, owner.ID, "clip")
+
+	mustComment := func(userID uuid.UUID, parentID *uuid.UUID, body string) database.Comment {
+		t.Helper()
+		c, err := s.CreateComment(database.CreateCommentParams{VideoID: video.ID, UserID: userID, ParentID: parentID, Body: body})
+		if err != nil {
+			t.Fatalf("CreateComment(%q): %v", body, err)
+		}
+		return c
+	}
+
+	first := mustComment(viewer.ID, nil, "first")
+	second := mustComment(owner.ID, nil, "second")
+	third := mustComment(viewer.ID, nil, "third")
+	for _, body := range []string{"reply 1", "reply 2", "reply 3"} {
+		mustComment(owner.ID, &first.ID, body)
+	}
+
+	got, err := s.GetComment(first.ID)
+	if err != nil {
+		t.Fatalf("GetComment: %v", err)
+	}
+	if got.Body != "first" || got.UserID != viewer.ID || got.ParentID != nil || got.ReplyCount != 3 {
+		t.Errorf("GetComment = %+v, want the first comment with 3 replies", got)
+	}
+
+	top := database.ListCommentsParams{VideoID: video.ID, Limit: 2}
+	if bodies, want := listCommentBodies(t, s, top), []string{"third", "second", "first"}; !equalTitles(bodies, want) {
+		t.Errorf("top-level comments = %v, want %v", bodies, want)
+	}
+	replies := database.ListCommentsParams{VideoID: video.ID, ParentID: first.ID, Limit: 2}
+	if bodies, want := listCommentBodies(t, s, replies), []string{"reply 1", "reply 2", "reply 3"}; !equalTitles(bodies, want) {
+		t.Errorf("replies = %v, want %v", bodies, want)
+	}
+
+	// Comments made in quick succession, likely within the same
+	// microsecond, still list in the order they were made.
+	burst := mustComment(owner.ID, nil, "burst")
+	want := []string{}
+	for i := range 20 {
+		body := fmt.Sprintf("burst reply %d", i)
+		mustComment(viewer.ID, &burst.ID, body)
+		want = append(want, body)
+	}
+	burstReplies := database.ListCommentsParams{VideoID: video.ID, ParentID: burst.ID, Limit: 3}
+	if bodies := listCommentBodies(t, s, burstReplies); !equalTitles(bodies, want) {
+		t.Errorf("replies made in quick succession = %v, want %v", bodies, want)
+	}
+	if err := s.DeleteComment(burst.ID); err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+
+	if err := s.UpdateCommentBody(second.ID, "second, edited"); err != nil {
+		t.Fatalf("UpdateCommentBody: %v", err)
+	}
+	got, err = s.GetComment(second.ID)
+	if err != nil {
+		t.Fatalf("GetComment: %v", err)
+	}
+	if got.Body != "second, edited" || got.UpdatedAt.Before(second.UpdatedAt) {
+		t.Errorf("GetComment after edit = %+v", got)
+	}
+
+	if err := s.SetCommentHidden(third.ID, true); err != nil {
+		t.Fatalf("SetCommentHidden: %v", err)
+	}
+	if bodies, want := listCommentBodies(t, s, top), []string{"second, edited", "first"}; !equalTitles(bodies, want) {
+		t.Errorf("top-level comments for anonymous viewers = %v, want %v", bodies, want)
+	}
+	withOwn := top
+	withOwn.ViewerID = viewer.ID
+	if bodies, want := listCommentBodies(t, s, withOwn), []string{"third", "second, edited", "first"}; !equalTitles(bodies, want) {
+		t.Errorf("top-level comments for the hidden comment's author = %v, want %v", bodies, want)
+	}
+	withHidden := top
+	withHidden.IncludeHidden = true
+	if bodies := listCommentBodies(t, s, withHidden); len(bodies) != 3 {
+		t.Errorf("top-level comments including hidden = %v, want all 3", bodies)
+	}
+
+	if err := s.PinComment(video.ID, first.ID); err != nil {
+		t.Fatalf("PinComment: %v", err)
+	}
+	if err := s.PinComment(video.ID, second.ID); err != nil {
+		t.Fatalf("PinComment: %v", err)
+	}
+	pinned, err := s.GetPinnedComment(video.ID)
+	if err != nil {
+		t.Fatalf("GetPinnedComment: %v", err)
+	}
+	if pinned.ID != second.ID || !pinned.Pinned {
+		t.Errorf("GetPinnedComment = %+v, want the second comment", pinned)
+	}
+	if bodies, want := listCommentBodies(t, s, top), []string{"first"}; !equalTitles(bodies, want) {
+		t.Errorf("top-level comments with one pinned and one hidden = %v, want %v", bodies, want)
+	}
+	if err := s.PinComment(video.ID, uuid.Nil); err != nil {
+		t.Fatalf("PinComment: %v", err)
+	}
+	pinned, err = s.GetPinnedComment(video.ID)
+	if err != nil {
+		t.Fatalf("GetPinnedComment: %v", err)
+	}
+	if pinned.ID != uuid.Nil {
+		t.Error("GetPinnedComment returned a comment after unpinning")
+	}
+
+	if err := s.DeleteComment(first.ID); err != nil {
+		t.Fatalf("DeleteComment: %v", err)
+	}
+	if bodies := listCommentBodies(t, s, replies); len(bodies) != 0 {
+		t.Errorf("replies of a deleted comment = %v, want none", bodies)
+	}
+
+	if err := s.DeleteVideo(video.ID); err != nil {
+		t.Fatalf("DeleteVideo: %v", err)
+	}
+	gone, err := s.GetComment(second.ID)
+	if err != nil {
+		t.Fatalf("GetComment: %v", err)
+	}
+	if gone.ID != uuid.Nil {
+		t.Error("comment survived the deletion of its video")
+	}
+}
+
+func testReactions(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	carol := mustCreateUser(t, s, "carol@example.com")
+	liked := mustCreateVideo(t, s, alice.ID, "liked")
+	quiet := mustCreateVideo(t, s, alice.ID, "quiet")
+
+	for _, r := range []struct {
+		userID   uuid.UUID
+		reaction database.Reaction
+	}{
+		{alice.ID, database.ReactionLike},
+		{bob.ID, database.ReactionDislike},
+		// Reacting again replaces the earlier reaction.
+		{bob.ID, database.ReactionLike},
+		{carol.ID, database.ReactionDislike},
+	} {
+		if err := s.SetReaction(r.userID, liked.ID, r.reaction); err != nil {
+			t.Fatalf("SetReaction: %v", err)
+		}
+	}
+
+	summaries, err := s.GetReactionSummaries([]uuid.UUID{liked.ID, quiet.ID}, carol.ID)
+	if err != nil {
+		t.Fatalf("GetReactionSummaries: %v", err)
+	}
+	got := summaries[liked.ID]
+	if got.Counts[database.ReactionLike] != 2 || got.Counts[database.ReactionDislike] != 1 {
+		t.Errorf("counts = %v, want 2 likes and 1 dislike", got.Counts)
+	}
+	if got.Mine == nil || *got.Mine != database.ReactionDislike {
+		t.Errorf("Mine = %v, want dislike", got.Mine)
+	}
+	empty, ok := summaries[quiet.ID]
+	if !ok || empty.Mine != nil || len(empty.Counts) != len(database.Reactions) || empty.Counts[database.ReactionLike] != 0 {
+		t.Errorf("summary of a video without reactions = %+v, %v; want zero counts for every reaction", empty, ok)
+	}
+
+	if err := s.DeleteReaction(carol.ID, liked.ID); err != nil {
+		t.Fatalf("DeleteReaction: %v", err)
+	}
+	if err := s.DeleteReaction(carol.ID, liked.ID); err != nil {
+		t.Fatalf("DeleteReaction twice: %v", err)
+	}
+	summaries, err = s.GetReactionSummaries([]uuid.UUID{liked.ID}, uuid.Nil)
+	if err != nil {
+		t.Fatalf("GetReactionSummaries: %v", err)
+	}
+	got = summaries[liked.ID]
+	if got.Counts[database.ReactionDislike] != 0 || got.Mine != nil {
+		t.Errorf("anonymous summary after removing the dislike = %+v, want no dislikes and no own reaction", got)
+	}
+
+	summaries, err = s.GetReactionSummaries(nil, alice.ID)
+	if err != nil || len(summaries) != 0 {
+		t.Errorf("GetReactionSummaries(nil) = %v, %v; want no summaries", summaries, err)
+	}
+}
+
+func testSubscriptions(t *testing.T, s database.Store) {
+	alice := mustCreateUser(t, s, "alice@example.com")
+	bob := mustCreateUser(t, s, "bob@example.com")
+	carol := mustCreateUser(t, s, "carol@example.com")
+	for _, p := range []database.Profile{{UserID: bob.ID, Handle: "bob"}, {UserID: carol.ID, Handle: "carol"}} {
+		if _, err := s.SaveProfile(p); err != nil {
+			t.Fatalf("SaveProfile: %v", err)
+		}
+	}
+
+	// Subscribing twice is harmless.
+	for _, channelID := range []uuid.UUID{carol.ID, bob.ID, bob.ID} {
+		if err := s.Subscribe(alice.ID, channelID); err != nil {
+			t.Fatalf("Subscribe: %v", err)
+		}
+	}
+	if err := s.Subscribe(carol.ID, bob.ID); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	subscribed, err := s.IsSubscribed(alice.ID, bob.ID)
+	if err != nil || !subscribed {
+		t.Errorf("IsSubscribed(alice, bob) = %v, %v; want true", subscribed, err)
+	}
+	subscribed, err = s.IsSubscribed(bob.ID, alice.ID)
+	if err != nil || subscribed {
+		t.Errorf("IsSubscribed(bob, alice) = %v, %v; want false", subscribed, err)
+	}
+	if n, err := s.CountSubscribers(bob.ID); err != nil || n != 2 {
+		t.Errorf("CountSubscribers(bob) = %d, %v; want 2", n, err)
+	}
+
+	channels, err := s.ListSubscriptions(alice.ID)
+	if err != nil {
+		t.Fatalf("ListSubscriptions: %v", err)
+	}
+	if len(channels) != 2 || channels[0].Handle != "bob" || channels[1].Handle != "carol" {
+		t.Errorf("ListSubscriptions = %+v, want bob and carol", channels)
+	}
+
+	public := database.VisibilityPublic
+	for _, v := range []struct {
+		userID     uuid.UUID
+		title      string
+		visibility database.Visibility
+	}{
+		{bob.ID, "bob public", public},
+		{bob.ID, "bob private", database.VisibilityPrivate},
+		{carol.ID, "carol public", public},
+		{alice.ID, "alice public", public},
+	} {
+		_, err := s.CreateVideo(database.CreateVideoParams{UserID: v.userID, Title: v.title, Visibility: v.visibility})
+		if err != nil {
+			t.Fatalf("CreateVideo: %v", err)
+		}
+	}
+	feed := database.ListVideosParams{SubscriberID: alice.ID, Visibility: public, Sort: database.VideoSortTitle, Ascending: true, Limit: 1}
+	if got := listAll(t, s, feed); !equalTitles(got, []string{"bob public", "carol public"}) {
+		t.Errorf("feed = %v, want bob's and carol's public videos", got)
+	}
+
+	if err := s.Unsubscribe(alice.ID, carol.ID); err != nil {
+		t.Fatalf("Unsubscribe: %v", err)
+	}
+	if got := listAll(t, s, feed); !equalTitles(got, []string{"bob public"}) {
+		t.Errorf("feed after unsubscribing = %v, want only bob's public video", got)
+	}
+	if got := listAll(t, s, database.ListVideosParams{SubscriberID: bob.ID, Limit: 10}); len(got) != 0 {
+		t.Errorf("feed without subscriptions = %v, want nothing", got)
+	}
+}
+
+func testReset(t *testing.T, s database.Store) {
+	user := mustCreateUser(t, s, "alice@example.com")
+	mustCreateVideo(t, s, user.ID, "first")
+	_, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{
+		Token:     "token-1",
+		UserID:    user.ID,
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	if err := s.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+
+	users, err := s.GetUsers()
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("%d users left after reset", len(users))
+	}
+	videos, err := s.GetVideos(user.ID)
+	if err != nil {
+		t.Fatalf("GetVideos: %v", err)
+	}
+	if len(videos) != 0 {
+		t.Errorf("%d videos left after reset", len(videos))
+	}
+	rt, err := s.GetRefreshToken("token-1")
+	if err != nil {
+		t.Fatalf("GetRefreshToken: %v", err)
+	}
+	if rt.Token != "" {
+		t.Error("refresh token left after reset")
+	}
+}
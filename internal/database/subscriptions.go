@@ -0,0 +1,62 @@
+package database
+
+import (
+	"github.com/google/uuid"
+)
+
+// Subscribe makes subscriberID follow channelID. Subscribing again is a
+// no-op.
+func (c Client) Subscribe(subscriberID, channelID uuid.UUID) error {
+	_, err := c.exec(`
+	INSERT INTO subscriptions (subscriber_id, channel_id, created_at)
+	VALUES (?, ?, CURRENT_TIMESTAMP)
+	ON CONFLICT (subscriber_id, channel_id) DO NOTHING
+	`, subscriberID, channelID)
+	return err
+}
+
+func (c Client) Unsubscribe(subscriberID, channelID uuid.UUID) error {
+	_, err := c.exec("DELETE FROM subscriptions WHERE subscriber_id = ? AND channel_id = ?", subscriberID, channelID)
+	return err
+}
+
+func (c Client) IsSubscribed(subscriberID, channelID uuid.UUID) (bool, error) {
+	var n int
+	err := c.queryRow(
+		"SELECT COUNT(*) FROM subscriptions WHERE subscriber_id = ? AND channel_id = ?",
+		subscriberID, channelID,
+	).Scan(&n)
+	return n > 0, err
+}
+
+func (c Client) CountSubscribers(channelID uuid.UUID) (int, error) {
+	var n int
+	err := c.queryRow("SELECT COUNT(*) FROM subscriptions WHERE channel_id = ?", channelID).Scan(&n)
+	return n, err
+}
+
+// ListSubscriptions returns the profiles of the channels subscriberID
+// follows, ordered by handle.
+func (c Client) ListSubscriptions(subscriberID uuid.UUID) ([]Profile, error) {
+	query := `
+	SELECT` + profileColumns + `
+	FROM profiles
+	WHERE user_id IN (SELECT channel_id FROM subscriptions WHERE subscriber_id = ?)
+	ORDER BY handle
+	`
+	rows, err := c.query(query, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []Profile{}
+	for rows.Next() {
+		p, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
@@ -0,0 +1,33 @@
+package database
+
+// SystemStats counts the rows behind the admin dashboard.
+type SystemStats struct {
+	Users                   int `json:"users"`
+	DisabledUsers           int `json:"disabled_users"`
+	Admins                  int `json:"admins"`
+	Videos                  int `json:"videos"`
+	TrashedVideos           int `json:"trashed_videos"`
+	Views                   int `json:"views"`
+	Comments                int `json:"comments"`
+	PendingStorageDeletions int `json:"pending_storage_deletions"`
+}
+
+func (c Client) GetSystemStats() (SystemStats, error) {
+	query := `
+	SELECT
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL),
+		(SELECT COUNT(*) FROM users WHERE role = 'admin'),
+		(SELECT COUNT(*) FROM videos WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM videos WHERE deleted_at IS NOT NULL),
+		(SELECT COUNT(*) FROM video_views),
+		(SELECT COUNT(*) FROM comments),
+		(SELECT COUNT(*) FROM storage_deletions)
+	`
+	var s SystemStats
+	err := c.queryRow(query).Scan(
+		&s.Users, &s.DisabledUsers, &s.Admins, &s.Videos, &s.TrashedVideos,
+		&s.Views, &s.Comments, &s.PendingStorageDeletions,
+	)
+	return s, err
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// TagCount is how many of a user's videos carry a tag.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// NormalizeTag folds a tag to the form it's stored and matched in: trimmed,
+// lower case, with runs of whitespace collapsed to single spaces.
+func NormalizeTag(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// normalizeTags normalizes names and drops empty tags and duplicates,
+// keeping the first occurrence's position.
+func normalizeTags(names []string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		tag := NormalizeTag(name)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// SetVideoTags replaces the tags on a video and returns them, normalized
+// and sorted.
+func (c Client) SetVideoTags(videoID uuid.UUID, names []string) ([]string, error) {
+	tags := normalizeTags(names)
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := c.deleteVideoTags(tx, videoID); err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		_, err := tx.Exec(c.dialect.rebind(`
+		INSERT INTO tags (id, name)
+		VALUES (?, ?)
+		ON CONFLICT (name) DO NOTHING
+		`), uuid.New(), tag)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(c.dialect.rebind(`
+		INSERT INTO video_tags (video_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?
+		`), videoID, tag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c.GetVideoTags(videoID)
+}
+
+// GetVideoTags returns the tags on a video in alphabetical order.
+func (c Client) GetVideoTags(videoID uuid.UUID) ([]string, error) {
+	query := `
+	SELECT t.name
+	FROM video_tags vt
+	JOIN tags t ON t.id = vt.tag_id
+	WHERE vt.video_id = ?
+	ORDER BY t.name
+	`
+	rows, err := c.query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// ListTagCounts returns every tag on the user's videos outside the trash,
+// most used first.
+func (c Client) ListTagCounts(userID uuid.UUID) ([]TagCount, error) {
+	query := `
+	SELECT t.name, COUNT(*)
+	FROM video_tags vt
+	JOIN tags t ON t.id = vt.tag_id
+	JOIN videos v ON v.id = vt.video_id
+	WHERE v.user_id = ? AND v.deleted_at IS NULL
+	GROUP BY t.name
+	ORDER BY COUNT(*) DESC, t.name
+	`
+	rows, err := c.query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []TagCount{}
+	for rows.Next() {
+		var count TagCount
+		if err := rows.Scan(&count.Name, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// deleteVideoTags removes a video's tag links.
+func (c Client) deleteVideoTags(tx *sql.Tx, videoID uuid.UUID) error {
+	_, err := tx.Exec(c.dialect.rebind("DELETE FROM video_tags WHERE video_id = ?"), videoID)
+	return err
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTP is a user's authenticator app enrollment.
+type TOTP struct {
+	UserID    uuid.UUID `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"-"`
+	// ConfirmedAt is set once the user has entered a code from their app.
+	// Until then TOTP isn't required at login.
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	LastUsedStep int64      `json:"-"`
+	// FailedAttempts counts wrong codes since the last right one.
+	FailedAttempts int        `json:"-"`
+	LastFailedAt   *time.Time `json:"-"`
+	// RecoveryCodesLeft counts the unused recovery codes.
+	RecoveryCodesLeft int `json:"recovery_codes_left"`
+}
+
+func (t TOTP) Enabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+func (c Client) GetTOTP(userID uuid.UUID) (TOTP, error) {
+	query := `
+	SELECT
+		t.user_id,
+		t.created_at,
+		t.secret,
+		t.confirmed_at,
+		t.last_used_step,
+		t.failed_attempts,
+		t.last_failed_at,
+		(SELECT COUNT(*) FROM recovery_codes rc WHERE rc.user_id = t.user_id AND rc.used_at IS NULL)
+	FROM user_totp t
+	WHERE t.user_id = ?
+	`
+	var t TOTP
+	err := c.queryRow(query, userID).Scan(&t.UserID, &t.CreatedAt, &t.Secret, &t.ConfirmedAt, &t.LastUsedStep, &t.FailedAttempts, &t.LastFailedAt, &t.RecoveryCodesLeft)
+	if errors.Is(err, sql.ErrNoRows) {
+		return TOTP{}, nil
+	}
+	return t, err
+}
+
+// StartTOTPEnrollment saves a new, unconfirmed secret for the user,
+// replacing any enrollment they didn't finish. It doesn't touch a
+// confirmed one.
+func (c Client) StartTOTPEnrollment(userID uuid.UUID, secret string) error {
+	query := `
+	INSERT INTO user_totp (user_id, created_at, secret)
+	VALUES (?, ?, ?)
+	ON CONFLICT (user_id) DO UPDATE
+	SET created_at = excluded.created_at, secret = excluded.secret, last_used_step = 0,
+		failed_attempts = 0, last_failed_at = NULL
+	WHERE user_totp.confirmed_at IS NULL
+	`
+	_, err := c.exec(query, userID, c.dialect.timeArg(roundTripNow()), secret)
+	return err
+}
+
+// ConfirmTOTP turns on TOTP for the user after they entered the code for
+// step, and replaces their recovery codes with the given hashes.
+func (c Client) ConfirmTOTP(userID uuid.UUID, step int64, recoveryCodeHashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := c.dialect.timeArg(roundTripNow())
+	_, err = tx.Exec(c.dialect.rebind(`
+		UPDATE user_totp
+		SET confirmed_at = ?, last_used_step = ?
+		WHERE user_id = ?
+	`), now, step, userID)
+	if err != nil {
+		return err
+	}
+	if err := c.replaceRecoveryCodes(tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes swaps the user's recovery codes, used or not, for
+// the given hashes.
+func (c Client) ReplaceRecoveryCodes(userID uuid.UUID, hashes []string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := c.replaceRecoveryCodes(tx, userID, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (c Client) replaceRecoveryCodes(tx *sql.Tx, userID uuid.UUID, hashes []string) error {
+	_, err := tx.Exec(c.dialect.rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userID)
+	if err != nil {
+		return err
+	}
+	now := c.dialect.timeArg(roundTripNow())
+	for _, hash := range hashes {
+		_, err := tx.Exec(c.dialect.rebind(`
+			INSERT INTO recovery_codes (user_id, code_hash, created_at)
+			VALUES (?, ?, ?)
+		`), userID, hash, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseTOTPStep records that the code for step was used, and reports false
+// if that step or a later one already was, so each code works only once.
+func (c Client) UseTOTPStep(userID uuid.UUID, step int64) (bool, error) {
+	query := `
+	UPDATE user_totp
+	SET last_used_step = ?
+	WHERE user_id = ? AND last_used_step < ?
+	`
+	result, err := c.exec(query, step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// UseRecoveryCode marks the recovery code with the given hash as used, and
+// reports false if the user has no such unused code.
+func (c Client) UseRecoveryCode(userID uuid.UUID, codeHash string) (bool, error) {
+	query := `
+	UPDATE recovery_codes
+	SET used_at = ?
+	WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+	`
+	result, err := c.exec(query, c.dialect.timeArg(roundTripNow()), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RecordTOTPAttempt counts a code entered at at as wrong until
+// ClearTOTPFailures says otherwise, so concurrent guesses can't get past
+// the limit. It reports false, and counts nothing, if maxFailures wrong
+// codes were already entered with the last less than lockout before at.
+// The count starts over once the lockout has passed.
+func (c Client) RecordTOTPAttempt(userID uuid.UUID, at time.Time, maxFailures int, lockout time.Duration) (bool, error) {
+	query := `
+	UPDATE user_totp
+	SET
+		failed_attempts = CASE WHEN failed_attempts >= ? THEN 1 ELSE failed_attempts + 1 END,
+		last_failed_at = ?
+	WHERE user_id = ? AND (failed_attempts < ? OR last_failed_at IS NULL OR last_failed_at <= ?)
+	`
+	result, err := c.exec(query,
+		maxFailures, c.dialect.timeArg(at), userID, maxFailures, c.dialect.timeArg(at.Add(-lockout)),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ClearTOTPFailures resets the count of wrong codes after a right one.
+func (c Client) ClearTOTPFailures(userID uuid.UUID) error {
+	query := `
+	UPDATE user_totp
+	SET failed_attempts = 0, last_failed_at = NULL
+	WHERE user_id = ?
+	`
+	_, err := c.exec(query, userID)
+	return err
+}
+
+// DeleteTOTP turns TOTP off for the user and drops their recovery codes.
+func (c Client) DeleteTOTP(userID uuid.UUID) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(c.dialect.rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind("DELETE FROM user_totp WHERE user_id = ?"), userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TrashVideo moves a video to the trash, hiding it everywhere but
+// ListTrashedVideos.
+func (c Client) TrashVideo(id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET deleted_at = ?
+	WHERE id = ? AND deleted_at IS NULL
+	`
+	_, err := c.exec(query, c.dialect.timeArg(time.Now()), id)
+	return err
+}
+
+// RestoreVideo takes a video back out of the trash.
+func (c Client) RestoreVideo(id uuid.UUID) error {
+	query := `
+	UPDATE videos
+	SET deleted_at = NULL, updated_at = ?
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+	_, err := c.exec(query, c.dialect.timeArg(roundTripNow()), id)
+	return err
+}
+
+// GetTrashedVideo returns the video with the given ID only if it's in the
+// trash.
+func (c Client) GetTrashedVideo(id uuid.UUID) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`
+
+	video, err := scanVideo(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+	return video, nil
+}
+
+// ListTrashedVideos returns a user's trash, most recently deleted first.
+func (c Client) ListTrashedVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC
+	`
+	rows, err := c.query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+// ListVideosTrashedBefore returns up to limit videos of any user that went
+// into the trash before cutoff, oldest first.
+func (c Client) ListVideosTrashedBefore(cutoff time.Time, limit int) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE deleted_at IS NOT NULL AND deleted_at < ?
+	ORDER BY deleted_at
+	LIMIT ?
+	`
+	rows, err := c.query(query, c.dialect.timeArg(cutoff), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
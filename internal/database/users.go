@@ -12,23 +12,70 @@ type User struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Role      Role      `json:"role"`
+	// DisabledAt is set while an admin has locked the account.
+	DisabledAt *time.Time `json:"disabled_at,omitempty"`
+	// EmailVerifiedAt is set once the user follows a link emailed to them.
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
 	CreateUserParams
 }
 
 type CreateUserParams struct {
 	Email    string `json:"email"`
-	Password string `json:"password"`
+	Password string `json:"-"`
 }
 
+type Role string
+
+const (
+	RoleViewer  Role = "viewer"
+	RoleCreator Role = "creator"
+	RoleAdmin   Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:  1,
+	RoleCreator: 2,
+	RoleAdmin:   3,
+}
+
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Includes reports whether r may do everything other can. Each role
+// includes the ones below it: admin, then creator, then viewer.
+func (r Role) Includes(other Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[other]
+}
+
+const userColumns = `
+	u.id,
+	u.created_at,
+	u.updated_at,
+	u.role,
+	u.disabled_at,
+	u.email_verified_at,
+	u.email,
+	u.password
+`
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt, &user.Role, &user.DisabledAt, &user.EmailVerifiedAt, &user.Email, &user.Password)
+	return user, err
+}
+
+// GetUsers returns every user, oldest first.
 func (c Client) GetUsers() ([]User, error) {
 	query := `
-		SELECT
-			id,
-			email
-		FROM users
+		SELECT` + userColumns + `
+		FROM users u
+		ORDER BY u.created_at, u.email
 	`
 
-	rows, err := c.db.Query(query)
+	rows, err := c.query(query)
 	if err != nil {
 		return nil, err
 	}
@@ -36,64 +83,49 @@ func (c Client) GetUsers() ([]User, error) {
 
 	users := []User{}
 	for rows.Next() {
-		var user User
-		var id string
-		if err := rows.Scan(&id, &user.Email); err != nil {
-			return nil, err
-		}
-		user.ID, err = uuid.Parse(id)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, rows.Err()
 }
 
 func (c Client) GetUserByEmail(email string) (User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE email = ?
+		SELECT` + userColumns + `
+		FROM users u
+		WHERE u.email = ?
 	`
-	var user User
-	var id string
-	err := c.db.QueryRow(query, email).Scan(&id, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.queryRow(query, email))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, nil
 		}
 		return User{}, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return User{}, err
-	}
 	return user, nil
 }
 
+// GetUserByRefreshToken returns the owner of a refresh token that's still
+// usable, or nil if the token is unknown, revoked or expired.
 func (c Client) GetUserByRefreshToken(token string) (*User, error) {
 	query := `
-		SELECT u.id, u.email, u.created_at, u.updated_at, u.password
+		SELECT` + userColumns + `
 		FROM users u
 		JOIN refresh_tokens rt ON u.id = rt.user_id
-		WHERE rt.token = ?
+		WHERE rt.token = ? AND rt.revoked_at IS NULL AND rt.expires_at > ?
 	`
 
-	var user User
-	var id string
-	err := c.db.QueryRow(query, token).Scan(&id, &user.Email, &user.CreatedAt, &user.UpdatedAt, &user.Password)
+	user, err := scanUser(c.queryRow(query, token, c.dialect.timeArg(time.Now())))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(id)
-	if err != nil {
-		return nil, err
-	}
 
 	return &user, nil
 }
@@ -107,7 +139,7 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 		VALUES
 		    (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?)
 	`
-	_, err := c.db.Exec(query, id.String(), params.Email, params.Password)
+	_, err := c.exec(query, id.String(), params.Email, params.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -117,31 +149,77 @@ func (c Client) CreateUser(params CreateUserParams) (*User, error) {
 
 func (c Client) GetUser(id uuid.UUID) (*User, error) {
 	query := `
-		SELECT id, created_at, updated_at, email, password
-		FROM users
-		WHERE id = ?
+		SELECT` + userColumns + `
+		FROM users u
+		WHERE u.id = ?
 	`
-	var user User
-	var idStr string
-	err := c.db.QueryRow(query, id.String()).Scan(&idStr, &user.CreatedAt, &user.UpdatedAt, &user.Email, &user.Password)
+	user, err := scanUser(c.queryRow(query, id.String()))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	user.ID, err = uuid.Parse(idStr)
-	if err != nil {
-		return nil, err
-	}
 	return &user, nil
 }
 
+func (c Client) SetUserRole(id uuid.UUID, role Role) error {
+	query := `
+		UPDATE users
+		SET role = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.exec(query, role, id)
+	return err
+}
+
+// SetUserDisabled locks or unlocks an account. Locking it again keeps the
+// original time.
+func (c Client) SetUserDisabled(id uuid.UUID, disabled bool) error {
+	query := `
+		UPDATE users
+		SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	if !disabled {
+		query = `
+		UPDATE users
+		SET disabled_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	}
+	_, err := c.exec(query, id)
+	return err
+}
+
+// SetUserEmailVerified records that the user's email address works. Doing
+// it again keeps the original time.
+func (c Client) SetUserEmailVerified(id uuid.UUID) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP), updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.exec(query, id)
+	return err
+}
+
+// SetUserPassword replaces the user's password hash.
+func (c Client) SetUserPassword(id uuid.UUID, hashedPassword string) error {
+	query := `
+		UPDATE users
+		SET password = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+	_, err := c.exec(query, hashedPassword, id)
+	return err
+}
+
 func (c Client) DeleteUser(id uuid.UUID) error {
 	query := `
 		DELETE FROM users
 		WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id.String())
+	_, err := c.exec(query, id.String())
 	return err
 }
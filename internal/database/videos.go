@@ -14,18 +14,48 @@ type Video struct {
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
 	VideoURL     *string   `json:"video_url"`
+	Duration     float64   `json:"duration"`
+	// DeletedAt is set while the video is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 	CreateVideoParams
 }
 
 type CreateVideoParams struct {
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	UserID      uuid.UUID `json:"user_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	UserID      uuid.UUID  `json:"user_id"`
+	Visibility  Visibility `json:"visibility"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// Visibility controls who can see a video. Private videos are only shown to
+// their owner, unlisted ones to anyone with the ID and public ones are also
+// listed publicly.
+type Visibility string
+
+const (
+	VisibilityPrivate  Visibility = "private"
+	VisibilityUnlisted Visibility = "unlisted"
+	VisibilityPublic   Visibility = "public"
+)
+
+func (v Visibility) Valid() bool {
+	switch v {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// VisibleTo reports whether userID, which is uuid.Nil for anonymous
+// requests, may see the video.
+func (v Video) VisibleTo(userID uuid.UUID) bool {
+	if v.Visibility != VisibilityPrivate {
+		return true
+	}
+	return userID != uuid.Nil && userID == v.UserID
+}
+
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,41 +63,72 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+		duration,
+		user_id,
+		visibility,
+		deleted_at`
 
-	rows, err := c.db.Query(query, userID)
-	if err != nil {
-		return nil, err
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// videoFields returns scan destinations matching videoColumns.
+func videoFields(video *Video) []any {
+	return []any{
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.Duration,
+		&video.UserID,
+		&video.Visibility,
+		&video.DeletedAt,
 	}
+}
+
+func scanVideo(row rowScanner) (Video, error) {
+	var video Video
+	err := row.Scan(videoFields(&video)...)
+	return video, err
+}
+
+func scanVideos(rows *sql.Rows) ([]Video, error) {
 	defer rows.Close()
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	return videos, rows.Err()
+}
+
+func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE user_id = ? AND deleted_at IS NULL
+	ORDER BY created_at DESC
+	`
 
-	return videos, nil
+	rows, err := c.query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	id := uuid.New()
+	if params.Visibility == "" {
+		params.Visibility = VisibilityPrivate
+	}
 	query := `
 	INSERT INTO videos (
 		id,
@@ -75,10 +136,11 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
-		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+		user_id,
+		visibility
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.exec(query, id, params.Title, params.Description, params.UserID, params.Visibility)
 	if err != nil {
 		return Video{}, err
 	}
@@ -86,31 +148,15 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 	return c.GetVideo(id)
 }
 
+// GetVideo returns the video with the given ID unless it's in the trash.
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
-	WHERE id = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.queryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,7 +167,78 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
+// GetVideoByThumbnailURL returns the video whose thumbnail is at url unless
+// it's in the trash.
+func (c Client) GetVideoByThumbnailURL(url string) (Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE thumbnail_url = ? AND deleted_at IS NULL
+	`
+
+	video, err := scanVideo(c.queryRow(query, url))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+
+	return video, nil
+}
+
+var (
+	// ErrVideoModified is returned by UpdateVideoIfUnmodified when the video
+	// changed since it was read.
+	ErrVideoModified = errors.New("video was modified concurrently")
+	// ErrVideoNotFound is returned by UpdateVideo and
+	// UpdateVideoIfUnmodified when the video doesn't exist or is in the
+	// trash.
+	ErrVideoNotFound = errors.New("video not found")
+)
+
+// roundTripNow is the current time truncated to the precision Postgres
+// keeps, so timestamps written with it, like the updated_at written by
+// UpdateVideo, round-trip exactly.
+func roundTripNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// UpdateVideo saves video and bumps its updated_at.
 func (c Client) UpdateVideo(video Video) error {
+	updated, err := c.updateVideo(video, nil)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrVideoNotFound
+	}
+	return nil
+}
+
+// UpdateVideoIfUnmodified saves video only if its updated_at still equals
+// lastUpdatedAt, and returns ErrVideoModified otherwise.
+func (c Client) UpdateVideoIfUnmodified(video Video, lastUpdatedAt time.Time) error {
+	updated, err := c.updateVideo(video, &lastUpdatedAt)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		existing, err := c.GetVideo(video.ID)
+		if err != nil {
+			return err
+		}
+		if existing.ID == uuid.Nil {
+			return ErrVideoNotFound
+		}
+		return ErrVideoModified
+	}
+	return nil
+}
+
+// updateVideo reports false if no video was saved, because it doesn't
+// exist, is in the trash or, with lastUpdatedAt, has changed since.
+func (c Client) updateVideo(video Video, lastUpdatedAt *time.Time) (bool, error) {
 	query := `
 	UPDATE videos
 	SET
@@ -129,27 +246,70 @@ func (c Client) UpdateVideo(video Video) error {
 		description = ?,
 		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
-	WHERE id = ?
+		duration = ?,
+		user_id = ?,
+		visibility = ?,
+		updated_at = ?
+	WHERE id = ? AND deleted_at IS NULL
 	`
-
-	_, err := c.db.Exec(
-		query,
+	args := []any{
 		video.Title,
 		video.Description,
 		&video.ThumbnailURL,
 		&video.VideoURL,
+		video.Duration,
 		video.UserID,
+		video.Visibility,
+		c.dialect.timeArg(roundTripNow()),
 		video.ID,
-	)
-	return err
+	}
+	if lastUpdatedAt != nil {
+		query += " AND updated_at = ?"
+		args = append(args, c.dialect.timeArg(*lastUpdatedAt))
+	}
+
+	result, err := c.exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// deleteVideoRelations removes the rows referring to a video before it's
+// deleted. SQLite runs without foreign key enforcement, so nothing cascades.
+func (c Client) deleteVideoRelations(tx *sql.Tx, id uuid.UUID) error {
+	if err := c.deleteVideoTags(tx, id); err != nil {
+		return err
+	}
+	if err := c.deletePlaylistEntries(tx, id); err != nil {
+		return err
+	}
+	if err := c.deleteVideoViews(tx, id); err != nil {
+		return err
+	}
+	if err := c.deleteVideoComments(tx, id); err != nil {
+		return err
+	}
+	return c.deleteVideoReactions(tx, id)
 }
 
 func (c Client) DeleteVideo(id uuid.UUID) error {
-	query := `
-	DELETE FROM videos
-	WHERE id = ?
-	`
-	_, err := c.db.Exec(query, id)
-	return err
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := c.deleteVideoRelations(tx, id); err != nil {
+		return err
+	}
+	_, err = tx.Exec(c.dialect.rebind("DELETE FROM videos WHERE id = ?"), id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
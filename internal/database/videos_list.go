@@ -0,0 +1,291 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultVideoPageSize = 20
+	MaxVideoPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+type VideoSort string
+
+const (
+	VideoSortCreatedAt VideoSort = "created_at"
+	VideoSortUpdatedAt VideoSort = "updated_at"
+	VideoSortTitle     VideoSort = "title"
+	VideoSortDuration  VideoSort = "duration"
+)
+
+func (s VideoSort) Valid() bool {
+	switch s {
+	case VideoSortCreatedAt, VideoSortUpdatedAt, VideoSortTitle, VideoSortDuration:
+		return true
+	}
+	return false
+}
+
+type ListVideosParams struct {
+	// UserID limits the listing to one user's videos. uuid.Nil lists
+	// everyone's, which only makes sense together with Visibility.
+	UserID uuid.UUID
+	// Visibility, when set, only lists videos with that visibility.
+	Visibility Visibility
+	// Tag, when set, only lists videos carrying that tag.
+	Tag string
+	// SubscriberID, when set, only lists videos from the channels that
+	// user subscribes to.
+	SubscriberID uuid.UUID
+	// Limit defaults to DefaultVideoPageSize and is capped at
+	// MaxVideoPageSize.
+	Limit int
+	// Cursor is the NextCursor of the previous page, if any. It is only
+	// valid with the same sort and direction it was issued for.
+	Cursor    string
+	Sort      VideoSort
+	Ascending bool
+
+	HasVideo     *bool
+	HasThumbnail *bool
+	CreatedAfter *time.Time
+}
+
+type VideoPage struct {
+	Items      []Video `json:"items"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// videoCursor marks the last video of a page. It's handed to clients as
+// opaque base64 so the encoding can change without breaking the API.
+type videoCursor struct {
+	Sort      VideoSort `json:"s"`
+	Ascending bool      `json:"a"`
+	Value     string    `json:"v"`
+	ID        uuid.UUID `json:"id"`
+}
+
+func encodeVideoCursor(params ListVideosParams, last Video) string {
+	cur := videoCursor{
+		Sort:      params.Sort,
+		Ascending: params.Ascending,
+		ID:        last.ID,
+	}
+	switch params.Sort {
+	case VideoSortCreatedAt:
+		cur.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case VideoSortUpdatedAt:
+		cur.Value = last.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	case VideoSortTitle:
+		cur.Value = last.Title
+	case VideoSortDuration:
+		cur.Value = strconv.FormatFloat(last.Duration, 'g', -1, 64)
+	}
+	dat, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(dat)
+}
+
+// decodeVideoCursor returns the position a cursor points at as a partial
+// Video holding just the sort field and ID.
+func decodeVideoCursor(params ListVideosParams) (Video, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(params.Cursor)
+	if err != nil {
+		return Video{}, ErrInvalidCursor
+	}
+	var cur videoCursor
+	if err := json.Unmarshal(dat, &cur); err != nil {
+		return Video{}, ErrInvalidCursor
+	}
+	if cur.Sort != params.Sort || cur.Ascending != params.Ascending {
+		return Video{}, fmt.Errorf("%w: issued for a different sort order", ErrInvalidCursor)
+	}
+
+	video := Video{ID: cur.ID}
+	switch cur.Sort {
+	case VideoSortCreatedAt, VideoSortUpdatedAt:
+		t, err := time.Parse(time.RFC3339Nano, cur.Value)
+		if err != nil {
+			return Video{}, ErrInvalidCursor
+		}
+		video.CreatedAt, video.UpdatedAt = t, t
+	case VideoSortTitle:
+		video.Title = cur.Value
+	case VideoSortDuration:
+		video.Duration, err = strconv.ParseFloat(cur.Value, 64)
+		if err != nil {
+			return Video{}, ErrInvalidCursor
+		}
+	}
+	return video, nil
+}
+
+// normalize fills in defaults and rejects parameters neither store can
+// serve.
+func (p ListVideosParams) normalize() (ListVideosParams, error) {
+	if p.Sort == "" {
+		p.Sort = VideoSortCreatedAt
+	}
+	if !p.Sort.Valid() {
+		return p, fmt.Errorf("unknown sort %q", p.Sort)
+	}
+	if p.Visibility != "" && !p.Visibility.Valid() {
+		return p, fmt.Errorf("unknown visibility %q", p.Visibility)
+	}
+	p.Tag = NormalizeTag(p.Tag)
+	if p.Limit <= 0 {
+		p.Limit = DefaultVideoPageSize
+	}
+	if p.Limit > MaxVideoPageSize {
+		p.Limit = MaxVideoPageSize
+	}
+	return p, nil
+}
+
+// compareVideos orders a and b by sort, breaking ties on ID.
+func compareVideos(a, b Video, sort VideoSort) int {
+	var c int
+	switch sort {
+	case VideoSortCreatedAt:
+		c = a.CreatedAt.Compare(b.CreatedAt)
+	case VideoSortUpdatedAt:
+		c = a.UpdatedAt.Compare(b.UpdatedAt)
+	case VideoSortTitle:
+		c = strings.Compare(a.Title, b.Title)
+	case VideoSortDuration:
+		switch {
+		case a.Duration < b.Duration:
+			c = -1
+		case a.Duration > b.Duration:
+			c = 1
+		}
+	}
+	if c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID.String(), b.ID.String())
+}
+
+func (d dialect) videoSortColumn(sort VideoSort) string {
+	if sort == VideoSortTitle && d == dialectPostgres {
+		// Byte order, to match SQLite and the cursor comparisons.
+		return `title COLLATE "C"`
+	}
+	return string(sort)
+}
+
+func (d dialect) videoSortArg(sort VideoSort, v Video) any {
+	switch sort {
+	case VideoSortCreatedAt:
+		return d.timeArg(v.CreatedAt)
+	case VideoSortUpdatedAt:
+		return d.timeArg(v.UpdatedAt)
+	case VideoSortTitle:
+		return v.Title
+	default:
+		return v.Duration
+	}
+}
+
+// ListVideos returns one page of videos using keyset pagination on
+// (sort column, id).
+func (c Client) ListVideos(params ListVideosParams) (VideoPage, error) {
+	params, err := params.normalize()
+	if err != nil {
+		return VideoPage{}, err
+	}
+
+	conditions := []string{"deleted_at IS NULL"}
+	args := []any{}
+	if params.UserID != uuid.Nil {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, params.UserID)
+	}
+	if params.Visibility != "" {
+		conditions = append(conditions, "visibility = ?")
+		args = append(args, params.Visibility)
+	}
+	if params.Tag != "" {
+		conditions = append(conditions, `id IN (
+		SELECT vt.video_id FROM video_tags vt JOIN tags t ON t.id = vt.tag_id WHERE t.name = ?
+	)`)
+		args = append(args, params.Tag)
+	}
+	if params.SubscriberID != uuid.Nil {
+		conditions = append(conditions, "user_id IN (SELECT channel_id FROM subscriptions WHERE subscriber_id = ?)")
+		args = append(args, params.SubscriberID)
+	}
+	if params.HasVideo != nil {
+		conditions = append(conditions, nullCondition("video_url", *params.HasVideo))
+	}
+	if params.HasThumbnail != nil {
+		conditions = append(conditions, nullCondition("thumbnail_url", *params.HasThumbnail))
+	}
+	if params.CreatedAfter != nil {
+		conditions = append(conditions, "created_at > ?")
+		args = append(args, c.dialect.timeArg(*params.CreatedAfter))
+	}
+
+	column := c.dialect.videoSortColumn(params.Sort)
+	direction, op := "DESC", "<"
+	if params.Ascending {
+		direction, op = "ASC", ">"
+	}
+	if params.Cursor != "" {
+		after, err := decodeVideoCursor(params)
+		if err != nil {
+			return VideoPage{}, err
+		}
+		value := c.dialect.videoSortArg(params.Sort, after)
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, op))
+		args = append(args, value, value, after.ID)
+	}
+
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE ` + strings.Join(conditions, " AND ") + `
+	ORDER BY ` + column + ` ` + direction + `, id ` + direction + `
+	LIMIT ?
+	`
+	// Fetch one extra row to know whether there's another page.
+	args = append(args, params.Limit+1)
+
+	rows, err := c.query(query, args...)
+	if err != nil {
+		return VideoPage{}, err
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return VideoPage{}, err
+	}
+	return newVideoPage(params, videos), nil
+}
+
+func nullCondition(column string, present bool) string {
+	if present {
+		return column + " IS NOT NULL"
+	}
+	return column + " IS NULL"
+}
+
+// newVideoPage trims videos, which may hold one row more than the limit, to
+// a page and sets its cursor.
+func newVideoPage(params ListVideosParams, videos []Video) VideoPage {
+	page := VideoPage{Items: videos}
+	if len(videos) > params.Limit {
+		page.Items = videos[:params.Limit]
+		cursor := encodeVideoCursor(params, page.Items[len(page.Items)-1])
+		page.NextCursor = &cursor
+	}
+	return page
+}
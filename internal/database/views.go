@@ -0,0 +1,355 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RetentionBuckets is how many parts a video is split into for retention
+// curves. A view lands in bucket b when it got b tenths of the way through;
+// bucket RetentionBuckets means it reached the end.
+const RetentionBuckets = 10
+
+// View is one counted viewing of a video.
+type View struct {
+	ID           uuid.UUID `json:"id"`
+	VideoID      uuid.UUID `json:"video_id"`
+	ViewerKey    string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastSeenAt   time.Time `json:"last_seen_at"`
+	WatchSeconds float64   `json:"watch_seconds"`
+	MaxPosition  float64   `json:"max_position"`
+}
+
+// DailyVideoStats is one day of a video's rolled up views. Viewers are
+// counted once per day.
+type DailyVideoStats struct {
+	Day           time.Time `json:"day"`
+	Views         int       `json:"views"`
+	UniqueViewers int       `json:"unique_viewers"`
+	WatchSeconds  float64   `json:"watch_seconds"`
+}
+
+type VideoStats struct {
+	Daily []DailyVideoStats
+	// UniqueViewers is how many different viewers watched over all the
+	// days, each counted once. It's counted from the raw views, so it
+	// doesn't wait for the next rollup.
+	UniqueViewers int
+	// Retention[b] is how many views got at least b tenths of the way
+	// through the video.
+	Retention [RetentionBuckets + 1]int
+}
+
+// statsDay is the UTC day t falls on, which is what rollups are keyed by.
+func statsDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func retentionBucket(maxPosition, duration float64) int {
+	if duration <= 0 {
+		return 0
+	}
+	b := int(math.Floor(maxPosition / duration * RetentionBuckets))
+	return min(max(b, 0), RetentionBuckets)
+}
+
+// viewRecord is a view with what rollups need to know about its video.
+type viewRecord struct {
+	View
+	Duration float64
+}
+
+type viewRollup struct {
+	VideoID uuid.UUID
+	Stats   DailyVideoStats
+	Buckets [RetentionBuckets + 1]int
+}
+
+// rollupViews aggregates views into one rollup per video and day.
+func rollupViews(records []viewRecord) []viewRollup {
+	type key struct {
+		videoID uuid.UUID
+		day     int64
+	}
+	rollups := map[key]*viewRollup{}
+	viewers := map[key]map[string]bool{}
+	for _, r := range records {
+		day := statsDay(r.CreatedAt)
+		k := key{r.VideoID, day.Unix()}
+		rollup, ok := rollups[k]
+		if !ok {
+			rollup = &viewRollup{VideoID: r.VideoID, Stats: DailyVideoStats{Day: day}}
+			rollups[k] = rollup
+			viewers[k] = map[string]bool{}
+		}
+		rollup.Stats.Views++
+		rollup.Stats.WatchSeconds += r.WatchSeconds
+		rollup.Buckets[retentionBucket(r.MaxPosition, r.Duration)]++
+		viewers[k][r.ViewerKey] = true
+	}
+
+	result := make([]viewRollup, 0, len(rollups))
+	for k, rollup := range rollups {
+		rollup.Stats.UniqueViewers = len(viewers[k])
+		result = append(result, *rollup)
+	}
+	return result
+}
+
+// cumulativeRetention turns per-bucket view counts into how many views got
+// at least as far as each bucket.
+func cumulativeRetention(buckets [RetentionBuckets + 1]int) [RetentionBuckets + 1]int {
+	var retention [RetentionBuckets + 1]int
+	total := 0
+	for b := RetentionBuckets; b >= 0; b-- {
+		total += buckets[b]
+		retention[b] = total
+	}
+	return retention
+}
+
+const viewColumns = `
+	id,
+	video_id,
+	viewer_key,
+	created_at,
+	last_seen_at,
+	watch_seconds,
+	max_position
+`
+
+func scanView(row rowScanner) (View, error) {
+	var v View
+	err := row.Scan(&v.ID, &v.VideoID, &v.ViewerKey, &v.CreatedAt, &v.LastSeenAt, &v.WatchSeconds, &v.MaxPosition)
+	return v, err
+}
+
+// RecordView counts a view of a video unless the same viewer already has
+// one that started within window, in which case that view is returned and
+// counted is false.
+func (c Client) RecordView(videoID uuid.UUID, viewerKey string, window time.Duration) (view View, counted bool, err error) {
+	now := time.Now()
+	query := `
+	SELECT` + viewColumns + `
+	FROM video_views
+	WHERE video_id = ? AND viewer_key = ? AND created_at > ?
+	ORDER BY created_at DESC
+	LIMIT 1
+	`
+	view, err = scanView(c.queryRow(query, videoID, viewerKey, c.dialect.timeArg(now.Add(-window))))
+	if err == nil {
+		return view, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return View{}, false, err
+	}
+
+	id := uuid.New()
+	_, err = c.exec(`
+	INSERT INTO video_views (id, video_id, viewer_key, created_at, last_seen_at)
+	VALUES (?, ?, ?, ?, ?)
+	`, id, videoID, viewerKey, c.dialect.timeArg(now), c.dialect.timeArg(now))
+	if err != nil {
+		return View{}, false, err
+	}
+	view, err = c.GetView(id)
+	return view, true, err
+}
+
+func (c Client) GetView(id uuid.UUID) (View, error) {
+	query := `
+	SELECT` + viewColumns + `
+	FROM video_views
+	WHERE id = ?
+	`
+	view, err := scanView(c.queryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return View{}, nil
+		}
+		return View{}, err
+	}
+	return view, nil
+}
+
+// heartbeat applies a player heartbeat at position to view. The time since
+// the last heartbeat counts as watched, up to maxGap so a paused or closed
+// player doesn't rack up watch time.
+func (v View) heartbeat(now time.Time, position float64, maxGap time.Duration) View {
+	gap := min(max(now.Sub(v.LastSeenAt), 0), maxGap)
+	v.WatchSeconds += gap.Seconds()
+	v.MaxPosition = max(v.MaxPosition, position)
+	v.LastSeenAt = now
+	return v
+}
+
+// RecordViewHeartbeat adds watch time to a view. Heartbeats for views that
+// don't exist, or that race with another heartbeat, are dropped.
+func (c Client) RecordViewHeartbeat(id uuid.UUID, position float64, maxGap time.Duration) error {
+	view, err := c.GetView(id)
+	if err != nil || view.ID == uuid.Nil {
+		return err
+	}
+	updated := view.heartbeat(time.Now(), position, maxGap)
+	_, err = c.exec(`
+	UPDATE video_views
+	SET last_seen_at = ?, watch_seconds = ?, max_position = ?
+	WHERE id = ? AND last_seen_at = ?
+	`,
+		c.dialect.timeArg(updated.LastSeenAt), updated.WatchSeconds, updated.MaxPosition,
+		id, c.dialect.timeArg(view.LastSeenAt),
+	)
+	return err
+}
+
+// RollupViewStats rebuilds the daily stats of every day from since's day
+// onwards out of the raw views.
+func (c Client) RollupViewStats(since time.Time) error {
+	query := `
+	SELECT` + prefixColumns("vv", viewColumns) + `, v.duration
+	FROM video_views vv
+	JOIN videos v ON v.id = vv.video_id
+	WHERE vv.created_at >= ?
+	`
+	rows, err := c.query(query, c.dialect.timeArg(statsDay(since)))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	records := []viewRecord{}
+	for rows.Next() {
+		var r viewRecord
+		err := rows.Scan(&r.ID, &r.VideoID, &r.ViewerKey, &r.CreatedAt, &r.LastSeenAt, &r.WatchSeconds, &r.MaxPosition, &r.Duration)
+		if err != nil {
+			return err
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, rollup := range rollupViews(records) {
+		day := c.dialect.timeArg(rollup.Stats.Day)
+		_, err := tx.Exec(c.dialect.rebind(`
+		INSERT INTO video_daily_stats (video_id, day, views, unique_viewers, watch_seconds)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (video_id, day) DO UPDATE SET
+			views = excluded.views,
+			unique_viewers = excluded.unique_viewers,
+			watch_seconds = excluded.watch_seconds
+		`), rollup.VideoID, day, rollup.Stats.Views, rollup.Stats.UniqueViewers, rollup.Stats.WatchSeconds)
+		if err != nil {
+			return err
+		}
+		for bucket, views := range rollup.Buckets {
+			_, err := tx.Exec(c.dialect.rebind(`
+			INSERT INTO video_daily_retention (video_id, day, bucket, views)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (video_id, day, bucket) DO UPDATE SET views = excluded.views
+			`), rollup.VideoID, day, bucket, views)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// GetVideoStats returns the rolled up stats of a video for the days from
+// from through to.
+func (c Client) GetVideoStats(videoID uuid.UUID, from, to time.Time) (VideoStats, error) {
+	fromDay, toDay := c.dialect.timeArg(statsDay(from)), c.dialect.timeArg(statsDay(to))
+	rows, err := c.query(`
+	SELECT day, views, unique_viewers, watch_seconds
+	FROM video_daily_stats
+	WHERE video_id = ? AND day >= ? AND day <= ?
+	ORDER BY day
+	`, videoID, fromDay, toDay)
+	if err != nil {
+		return VideoStats{}, err
+	}
+	defer rows.Close()
+
+	stats := VideoStats{Daily: []DailyVideoStats{}}
+	for rows.Next() {
+		var day DailyVideoStats
+		if err := rows.Scan(&day.Day, &day.Views, &day.UniqueViewers, &day.WatchSeconds); err != nil {
+			return VideoStats{}, err
+		}
+		day.Day = day.Day.UTC()
+		stats.Daily = append(stats.Daily, day)
+	}
+	if err := rows.Err(); err != nil {
+		return VideoStats{}, err
+	}
+	rows.Close()
+
+	rows, err = c.query(`
+	SELECT bucket, SUM(views)
+	FROM video_daily_retention
+	WHERE video_id = ? AND day >= ? AND day <= ?
+	GROUP BY bucket
+	`, videoID, fromDay, toDay)
+	if err != nil {
+		return VideoStats{}, err
+	}
+	defer rows.Close()
+
+	var buckets [RetentionBuckets + 1]int
+	for rows.Next() {
+		var bucket, views int
+		if err := rows.Scan(&bucket, &views); err != nil {
+			return VideoStats{}, err
+		}
+		if bucket >= 0 && bucket <= RetentionBuckets {
+			buckets[bucket] = views
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return VideoStats{}, err
+	}
+	stats.Retention = cumulativeRetention(buckets)
+
+	err = c.queryRow(`
+	SELECT COUNT(DISTINCT viewer_key)
+	FROM video_views
+	WHERE video_id = ? AND created_at >= ? AND created_at < ?
+	`, videoID, fromDay, c.dialect.timeArg(statsDay(to).AddDate(0, 0, 1))).Scan(&stats.UniqueViewers)
+	if err != nil {
+		return VideoStats{}, err
+	}
+	return stats, nil
+}
+
+// deleteVideoViews removes a video's views and stats.
+func (c Client) deleteVideoViews(tx *sql.Tx, videoID uuid.UUID) error {
+	for _, table := range []string{"video_daily_retention", "video_daily_stats", "video_views"} {
+		_, err := tx.Exec(c.dialect.rebind("DELETE FROM "+table+" WHERE video_id = ?"), videoID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortDailyStats orders daily stats by day, as GetVideoStats returns them.
+func sortDailyStats(daily []DailyVideoStats) {
+	sort.Slice(daily, func(i, j int) bool {
+		return daily[i].Day.Before(daily[j].Day)
+	})
+}
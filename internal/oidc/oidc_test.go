@@ -0,0 +1,173 @@
+package oidc_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const redirectURL = "http://localhost:8091/api/oidc/callback"
+
+var alice = oidctest.User{Subject: "alice-sub", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+
+func newProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+	srv := oidctest.NewServer("tubely", "s3cret")
+	t.Cleanup(srv.Close)
+	srv.SetUser(alice)
+	return srv, oidc.NewProvider(oidc.Config{
+		Issuer:       srv.URL,
+		ClientID:     "tubely",
+		ClientSecret: "s3cret",
+		RedirectURL:  redirectURL,
+	})
+}
+
+// authorize follows the provider's login page back to the redirect URL and
+// returns the code and state it came back with.
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) (code, gotState string) {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("GET %s: %v", authURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %s", resp.Status)
+	}
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse redirect: %v", err)
+	}
+	return back.Query().Get("code"), back.Query().Get("state")
+}
+
+// login runs a whole login and returns the verified ID token.
+func login(t *testing.T, p *oidc.Provider) (oidc.IDToken, error) {
+	t.Helper()
+	code, _ := authorize(t, p, "state", "nonce", "verifier-verifier-verifier-verifier-verifier")
+	raw, err := p.Exchange(context.Background(), code, "verifier-verifier-verifier-verifier-verifier")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	return p.VerifyIDToken(context.Background(), raw, "nonce")
+}
+
+func TestLogin(t *testing.T) {
+	_, p := newProvider(t)
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		t.Fatalf("RandomString: %v", err)
+	}
+
+	code, state := authorize(t, p, "the-state", "the-nonce", verifier)
+	if state != "the-state" {
+		t.Errorf("state = %q, want %q", state, "the-state")
+	}
+	raw, err := p.Exchange(context.Background(), code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	idToken, err := p.VerifyIDToken(context.Background(), raw, "the-nonce")
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	want := oidc.IDToken{Subject: alice.Subject, Email: alice.Email, EmailVerified: true, Name: alice.Name}
+	if idToken != want {
+		t.Errorf("ID token = %+v, want %+v", idToken, want)
+	}
+
+	if _, err := p.Exchange(context.Background(), code, verifier); err == nil {
+		t.Error("a code was exchanged twice")
+	}
+	if _, err := p.VerifyIDToken(context.Background(), raw, "another-nonce"); err == nil {
+		t.Error("an ID token was accepted with the wrong nonce")
+	}
+}
+
+func TestExchangeChecksPKCE(t *testing.T) {
+	_, p := newProvider(t)
+	code, _ := authorize(t, p, "state", "nonce", "the-right-verifier-the-right-verifier")
+	if _, err := p.Exchange(context.Background(), code, "the-wrong-verifier-the-wrong-verifier"); err == nil {
+		t.Error("a code was exchanged with the wrong code verifier")
+	}
+}
+
+func TestVerifyIDTokenRejects(t *testing.T) {
+	for name, edit := range map[string]func(jwt.MapClaims){
+		"wrong audience":           func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"wrong issuer":             func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":                  func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":                func(c jwt.MapClaims) { delete(c, "exp") },
+		"issued in the future":     func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"no subject":               func(c jwt.MapClaims) { delete(c, "sub") },
+		"another authorized party": func(c jwt.MapClaims) { c["aud"] = []string{"tubely", "other"}; c["azp"] = "other" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			srv, p := newProvider(t)
+			srv.EditClaims(edit)
+			if _, err := login(t, p); err == nil {
+				t.Error("ID token was accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenEmailVerifiedString(t *testing.T) {
+	srv, p := newProvider(t)
+	srv.EditClaims(func(c jwt.MapClaims) { c["email_verified"] = "true" })
+	idToken, err := login(t, p)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if !idToken.EmailVerified {
+		t.Error(`email_verified "true" wasn't taken as verified`)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	srv, p := newProvider(t)
+	if _, err := login(t, p); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	srv.RotateKey()
+	if _, err := login(t, p); err != nil {
+		t.Errorf("login after the provider rotated its key: %v", err)
+	}
+}
+
+func TestForgedAlgorithms(t *testing.T) {
+	srv, p := newProvider(t)
+	if _, err := login(t, p); err != nil {
+		t.Fatalf("login: %v", err)
+	}
+
+	claims := jwt.MapClaims{
+		"iss": srv.URL, "sub": "mallory", "aud": "tubely", "nonce": "nonce",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Hour).Unix(),
+	}
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("sign none: %v", err)
+	}
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("s3cret"))
+	if err != nil {
+		t.Fatalf("sign HS256: %v", err)
+	}
+	for name, raw := range map[string]string{"none": none, "HS256": hmac} {
+		if _, err := p.VerifyIDToken(context.Background(), raw, "nonce"); err == nil {
+			t.Errorf("ID token signed with %s was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Package storage saves uploaded files and removes them again, either in S3
+// or on local disk.
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// Store keeps objects under keys and knows the public URL of each one.
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	// Delete removes the object at key. Deleting a missing object is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+	// KeyForURL is the inverse of URL. It reports false for URLs that don't
+	// point into this store.
+	KeyForURL(url string) (string, bool)
+}
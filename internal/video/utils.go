@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os/exec"
+	"strconv"
 )
 
 type Stream struct {
@@ -12,8 +13,13 @@ type Stream struct {
 	Height int `json:"height"`
 }
 
+type Format struct {
+	Duration string `json:"duration"`
+}
+
 type FfprobeOutput struct {
 	Streams []Stream `json:"streams"`
+	Format  Format   `json:"format"`
 }
 
 // GetAspectRatio retrieves the aspect ratio of a video file
@@ -55,6 +61,30 @@ func GetAspectRatio(filePath string) (string, error) {
 	return "other", nil
 }
 
+// GetDuration retrieves the length of a video file in seconds
+func GetDuration(filePath string) (float64, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_format", filePath)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("failed to run ffprobe: %w", err)
+	}
+
+	var ffprobeOutput FfprobeOutput
+	if err := json.Unmarshal(out.Bytes(), &ffprobeOutput); err != nil {
+		return 0, fmt.Errorf("failed to unmarshal ffprobe output: %w", err)
+	}
+
+	duration, err := strconv.ParseFloat(ffprobeOutput.Format.Duration, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", ffprobeOutput.Format.Duration, err)
+	}
+
+	return duration, nil
+}
+
 // ProcessForFastStart optimizes video for web playback
 func ProcessForFastStart(filePath string) (string, error) {
 	outputPath := fmt.Sprintf("%s.processing.mp4", filePath)
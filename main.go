@@ -8,18 +8,21 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
-	"github.com/google/uuid"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mail"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/oidc"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
-	jwtSecret        string
+	db               database.Store
+	jwtKeys          *auth.KeySet
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
@@ -27,33 +30,62 @@ type apiConfig struct {
 	s3Region         string
 	s3CfDistribution string
 	port             string
-	s3Client         *s3.Client
+	videoStorage     storage.Store
+	thumbnailStorage storage.Store
+	cleaner          *storageCleaner
+	trashRetention   time.Duration
 	linkExpireTime   int
+	mailer           mail.Mailer
+	// appURL is the web app, where links in emails and single sign-on
+	// lead.
+	appURL string
+	// uploadsNeedVerifiedEmail blocks uploads until the user has verified
+	// their email address.
+	uploadsNeedVerifiedEmail bool
+	// oidc is the single sign-on provider, or nil if there isn't one.
+	oidc *oidc.Provider
 }
 
-type thumbnail struct {
-	data      []byte
-	mediaType string
-}
-
-var videoThumbnails = map[uuid.UUID]thumbnail{}
-
 func main() {
 	godotenv.Load(".env")
 
-	pathToDB := os.Getenv("DB_PATH")
-	if pathToDB == "" {
-		log.Fatal("DB_URL must be set")
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		dbURL = os.Getenv("DB_PATH")
+	}
+	if dbURL == "" {
+		log.Fatal("DB_URL or DB_PATH must be set")
 	}
 
-	db, err := database.NewClient(pathToDB)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(dbURL, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "set-role" {
+		if err := runSetRole(dbURL, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db, err := database.NewClient(dbURL)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
 
+	// Access tokens are signed with the key files in JWT_KEYS_DIR when it's
+	// set, and with the JWT_SECRET shared secret otherwise.
 	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is not set")
+	jwtKeys := auth.NewSecretKeySet(jwtSecret)
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		jwtKeys, err = auth.LoadKeySet(dir, os.Getenv("JWT_SIGNING_KEY_ID"), jwtSecret)
+		if err != nil {
+			log.Fatalf("Couldn't load JWT keys: %v", err)
+		}
+	} else if jwtSecret == "" {
+		log.Fatal("JWT_SECRET or JWT_KEYS_DIR environment variable must be set")
 	}
 
 	platform := os.Getenv("PLATFORM")
@@ -111,18 +143,95 @@ func main() {
 		log.Fatalf("Invalid LINK_EXPIRES_IN value: %v", err)
 	}
 
+	trashRetentionDays := defaultTrashRetentionDays
+	if days := os.Getenv("TRASH_RETENTION_DAYS"); days != "" {
+		trashRetentionDays, err = strconv.Atoi(days)
+		if err != nil || trashRetentionDays < 0 {
+			log.Fatalf("Invalid TRASH_RETENTION_DAYS value: %q", days)
+		}
+	}
+
+	// Emails go through SMTP_ADDR when it's set. Otherwise they're written
+	// to MAIL_LOG_FILE, or to standard output, for local development.
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Tubely <no-reply@localhost>"
+	}
+	var mailer mail.Mailer
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		mailer, err = mail.NewSMTPMailer(smtpAddr, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), mailFrom)
+		if err != nil {
+			log.Fatalf("Invalid SMTP settings: %v", err)
+		}
+	} else if path := os.Getenv("MAIL_LOG_FILE"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			log.Fatalf("Couldn't open MAIL_LOG_FILE: %v", err)
+		}
+		defer f.Close()
+		mailer = mail.NewLogMailer(f, mailFrom)
+	} else {
+		mailer = mail.NewLogMailer(os.Stdout, mailFrom)
+	}
+
+	appURL := os.Getenv("APP_URL")
+	if appURL == "" {
+		appURL = "http://localhost:" + port + "/app/"
+	}
+
+	uploadsNeedVerifiedEmail := false
+	if v := os.Getenv("REQUIRE_VERIFIED_EMAIL"); v != "" {
+		uploadsNeedVerifiedEmail, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid REQUIRE_VERIFIED_EMAIL value: %q", v)
+		}
+	}
+
+	// Single sign-on is turned on by setting OIDC_ISSUER. The provider is
+	// only contacted once somebody logs in through it.
+	var oidcProvider *oidc.Provider
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		clientID := os.Getenv("OIDC_CLIENT_ID")
+		if clientID == "" {
+			log.Fatal("OIDC_CLIENT_ID must be set with OIDC_ISSUER")
+		}
+		redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = "http://localhost:" + port + "/api/oidc/callback"
+		}
+		oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+		})
+	}
+
+	videoStorage := storage.NewS3Store(s3Client, s3Bucket, s3CfDistribution)
+	thumbnailStorage := storage.NewDiskStore(assetsRoot, "http://localhost:"+port+"/assets")
+
 	cfg := apiConfig{
-		db:               db,
-		jwtSecret:        jwtSecret,
-		platform:         platform,
-		filepathRoot:     filepathRoot,
-		assetsRoot:       assetsRoot,
-		s3Bucket:         s3Bucket,
-		s3Region:         s3Region,
-		s3CfDistribution: s3CfDistribution,
-		port:             port,
-		s3Client:         s3Client,
-		linkExpireTime:   linkExpireTime,
+		db:                       db,
+		jwtKeys:                  jwtKeys,
+		platform:                 platform,
+		filepathRoot:             filepathRoot,
+		assetsRoot:               assetsRoot,
+		s3Bucket:                 s3Bucket,
+		s3Region:                 s3Region,
+		s3CfDistribution:         s3CfDistribution,
+		port:                     port,
+		videoStorage:             videoStorage,
+		thumbnailStorage:         thumbnailStorage,
+		linkExpireTime:           linkExpireTime,
+		trashRetention:           time.Duration(trashRetentionDays) * 24 * time.Hour,
+		mailer:                   mailer,
+		appURL:                   appURL,
+		uploadsNeedVerifiedEmail: uploadsNeedVerifiedEmail,
+		oidc:                     oidcProvider,
+		cleaner: newStorageCleaner(db, map[string]storage.Store{
+			storageBackendVideos:     videoStorage,
+			storageBackendThumbnails: thumbnailStorage,
+		}),
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -130,28 +239,104 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	go cfg.cleaner.run(context.Background())
+	go cfg.runTrashPurger(context.Background())
+	go cfg.runViewRollup(context.Background())
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	// Routes authenticate through requireAuth or optionalAuth. Everything
+	// about videos also accepts API keys, limited to their scopes; the
+	// rest needs an access token.
+	read, write, uploads := auth.ScopeVideosRead, auth.ScopeVideosWrite, auth.ScopeUploads
+	account := auth.ScopeAccount
+	creator, admin := database.RoleCreator, database.RoleAdmin
 
+	mux.Handle("GET /assets/{key...}", noCacheMiddleware(cfg.optionalAuth(read, cfg.handlerAssetGet)))
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
+	mux.HandleFunc("POST /api/login/mfa", cfg.handlerLoginMFA)
+	if cfg.oidc != nil {
+		mux.HandleFunc("GET /api/oidc/login", cfg.handlerOIDCLogin)
+		mux.HandleFunc("GET /api/oidc/callback", cfg.handlerOIDCCallback)
+		mux.HandleFunc("POST /api/oidc/token", cfg.handlerOIDCToken)
+	}
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
 	mux.HandleFunc("POST /api/revoke", cfg.handlerRevoke)
+	mux.HandleFunc("GET /api/mfa/totp", cfg.requireAuth(account, cfg.handlerTOTPGet))
+	mux.HandleFunc("POST /api/mfa/totp", cfg.requireAuth(account, cfg.handlerTOTPEnroll))
+	mux.HandleFunc("POST /api/mfa/totp/confirm", cfg.requireAuth(account, cfg.handlerTOTPConfirm))
+	mux.HandleFunc("DELETE /api/mfa/totp", cfg.requireAuth(account, cfg.handlerTOTPDisable))
+	mux.HandleFunc("POST /api/mfa/recovery-codes", cfg.requireAuth(account, cfg.handlerRecoveryCodesRegenerate))
+	mux.HandleFunc("GET /api/sessions", cfg.requireAuth(account, cfg.handlerSessionsRetrieve))
+	mux.HandleFunc("DELETE /api/sessions", cfg.requireAuth(account, cfg.handlerSessionsRevokeAll))
+	mux.HandleFunc("DELETE /api/sessions/{sessionID}", cfg.requireAuth(account, cfg.handlerSessionRevoke))
 
 	mux.HandleFunc("POST /api/users", cfg.handlerUsersCreate)
-
-	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
-	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
-	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
-	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
-	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
-	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
+	mux.HandleFunc("POST /api/email/verification", cfg.requireAuth(account, cfg.handlerVerificationEmailSend))
+	mux.HandleFunc("POST /api/email/verify", cfg.handlerEmailVerify)
+	mux.HandleFunc("POST /api/password/forgot", cfg.handlerPasswordForgot)
+	mux.HandleFunc("POST /api/password/reset", cfg.handlerPasswordReset)
+	mux.HandleFunc("GET /api/profile", cfg.requireAuth(account, cfg.handlerProfileGet))
+	mux.HandleFunc("PUT /api/profile", cfg.requireAuth(account, cfg.handlerProfileUpdate))
+	mux.HandleFunc("POST /api/profile/avatar", cfg.requireAuth(account, cfg.handlerProfileAvatarUpload))
+	mux.HandleFunc("GET /api/channels/{handle}", cfg.optionalAuth(account, cfg.handlerChannelGet))
+	mux.HandleFunc("PUT /api/channels/{handle}/subscription", cfg.requireAuth(account, cfg.handlerSubscribe))
+	mux.HandleFunc("DELETE /api/channels/{handle}/subscription", cfg.requireAuth(account, cfg.handlerUnsubscribe))
+	mux.HandleFunc("GET /api/subscriptions", cfg.requireAuth(account, cfg.handlerSubscriptionsRetrieve))
+	mux.HandleFunc("GET /api/feed", cfg.requireAuth(account, cfg.handlerFeedRetrieve))
+
+	mux.HandleFunc("POST /api/videos", cfg.requireAuth(write, requireRole(creator, cfg.handlerVideoMetaCreate)))
+	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.requireAuth(uploads, requireRole(creator, cfg.requireVerifiedEmail(cfg.handlerUploadThumbnail))))
+	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.requireAuth(uploads, requireRole(creator, cfg.requireVerifiedEmail(cfg.handlerUploadVideo))))
+	mux.HandleFunc("GET /api/videos", cfg.requireAuth(read, cfg.handlerVideosRetrieve))
+	mux.HandleFunc("GET /api/videos/search", cfg.requireAuth(read, cfg.handlerVideosSearch))
+	mux.HandleFunc("GET /api/videos/{videoID}", cfg.optionalAuth(read, cfg.handlerVideoGet))
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.requireAuth(write, cfg.handlerVideoUpdate))
+	mux.HandleFunc("PUT /api/videos/{videoID}/visibility", cfg.requireAuth(write, cfg.handlerVideoVisibilityUpdate))
+	mux.HandleFunc("GET /api/public/videos", cfg.handlerPublicVideosRetrieve)
+	mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.optionalAuth(read, cfg.handlerThumbnailGet))
+	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.requireAuth(write, cfg.handlerVideoMetaDelete))
+	mux.HandleFunc("POST /api/videos/{videoID}/restore", cfg.requireAuth(write, cfg.handlerVideoRestore))
+	mux.HandleFunc("GET /api/trash", cfg.requireAuth(account, cfg.handlerTrashRetrieve))
+	mux.HandleFunc("PUT /api/videos/{videoID}/tags", cfg.requireAuth(write, cfg.handlerVideoTagsUpdate))
+	mux.HandleFunc("GET /api/videos/{videoID}/tags", cfg.optionalAuth(read, cfg.handlerVideoTagsGet))
+	mux.HandleFunc("GET /api/tags", cfg.requireAuth(account, cfg.handlerTagsRetrieve))
+	mux.HandleFunc("POST /api/videos/{videoID}/views", cfg.optionalAuth(read, cfg.handlerVideoViewRecord))
+	mux.HandleFunc("POST /api/videos/{videoID}/views/{viewID}/heartbeat", cfg.optionalAuth(read, cfg.handlerVideoViewHeartbeat))
+	mux.HandleFunc("GET /api/videos/{videoID}/stats", cfg.requireAuth(read, cfg.handlerVideoStatsGet))
+	mux.HandleFunc("GET /api/videos/{videoID}/comments", cfg.optionalAuth(read, cfg.handlerCommentsRetrieve))
+	mux.HandleFunc("POST /api/videos/{videoID}/comments", cfg.requireAuth(write, cfg.handlerCommentCreate))
+	mux.HandleFunc("PATCH /api/videos/{videoID}/comments/{commentID}", cfg.requireAuth(write, cfg.handlerCommentUpdate))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/comments/{commentID}", cfg.requireAuth(write, cfg.handlerCommentDelete))
+	mux.HandleFunc("GET /api/videos/{videoID}/comments/{commentID}/replies", cfg.optionalAuth(read, cfg.handlerCommentRepliesRetrieve))
+	mux.HandleFunc("PUT /api/videos/{videoID}/comments/{commentID}/hidden", cfg.requireAuth(write, cfg.handlerCommentHiddenUpdate))
+	mux.HandleFunc("PUT /api/videos/{videoID}/comments/{commentID}/pin", cfg.requireAuth(write, cfg.handlerCommentPin))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/comments/{commentID}/pin", cfg.requireAuth(write, cfg.handlerCommentUnpin))
+	mux.HandleFunc("PUT /api/videos/{videoID}/reaction", cfg.requireAuth(write, cfg.handlerReactionSet))
+	mux.HandleFunc("DELETE /api/videos/{videoID}/reaction", cfg.requireAuth(write, cfg.handlerReactionDelete))
+	mux.HandleFunc("POST /api/keys", cfg.requireAuth(account, cfg.handlerAPIKeyCreate))
+	mux.HandleFunc("GET /api/keys", cfg.requireAuth(account, cfg.handlerAPIKeysRetrieve))
+	mux.HandleFunc("PATCH /api/keys/{keyID}", cfg.requireAuth(account, cfg.handlerAPIKeyUpdate))
+	mux.HandleFunc("DELETE /api/keys/{keyID}", cfg.requireAuth(account, cfg.handlerAPIKeyRevoke))
+	mux.HandleFunc("POST /api/playlists", cfg.requireAuth(account, cfg.handlerPlaylistCreate))
+	mux.HandleFunc("GET /api/playlists", cfg.requireAuth(account, cfg.handlerPlaylistsRetrieve))
+	mux.HandleFunc("GET /api/playlists/{playlistID}", cfg.optionalAuth(account, cfg.handlerPlaylistGet))
+	mux.HandleFunc("PATCH /api/playlists/{playlistID}", cfg.requireAuth(account, cfg.handlerPlaylistUpdate))
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}", cfg.requireAuth(account, cfg.handlerPlaylistDelete))
+	mux.HandleFunc("GET /api/playlists/{playlistID}/queue", cfg.optionalAuth(account, cfg.handlerPlaylistQueue))
+	mux.HandleFunc("POST /api/playlists/{playlistID}/items", cfg.requireAuth(account, cfg.handlerPlaylistItemAdd))
+	mux.HandleFunc("PUT /api/playlists/{playlistID}/items/{videoID}", cfg.requireAuth(account, cfg.handlerPlaylistItemMove))
+	mux.HandleFunc("DELETE /api/playlists/{playlistID}/items/{videoID}", cfg.requireAuth(account, cfg.handlerPlaylistItemRemove))
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
+	mux.HandleFunc("GET /admin/users", cfg.requireAuth(account, requireRole(admin, cfg.handlerAdminUsersRetrieve)))
+	mux.HandleFunc("PUT /admin/users/{userID}/role", cfg.requireAuth(account, requireRole(admin, cfg.handlerAdminUserRoleUpdate)))
+	mux.HandleFunc("PUT /admin/users/{userID}/disabled", cfg.requireAuth(account, requireRole(admin, cfg.handlerAdminUserDisabledUpdate)))
+	mux.HandleFunc("PUT /admin/videos/{videoID}/owner", cfg.requireAuth(account, requireRole(admin, cfg.handlerAdminVideoOwnerUpdate)))
+	mux.HandleFunc("GET /admin/stats", cfg.requireAuth(account, requireRole(admin, cfg.handlerAdminStatsGet)))
 
 	srv := &http.Server{
 		Addr:    ":" + port,
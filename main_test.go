@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mail"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// The handler tests run against a MemoryStore and an assets directory of
+// their own. Routes are registered one at a time with serve, so the path
+// values handlers read are set as in main.
+
+const testAssetsURL = "http://localhost:8091/assets"
+
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	assetsRoot := t.TempDir()
+	return &apiConfig{
+		db:               database.NewMemoryStore(),
+		jwtKeys:          auth.NewSecretKeySet("test-secret"),
+		platform:         "dev",
+		assetsRoot:       assetsRoot,
+		port:             "8091",
+		thumbnailStorage: storage.NewDiskStore(assetsRoot, testAssetsURL),
+		mailer:           mail.NewLogMailer(io.Discard, "Tubely <no-reply@localhost>"),
+		appURL:           "http://localhost:8091/app/",
+	}
+}
+
+// createTestUser creates a user with password "password".
+func createTestUser(t *testing.T, cfg *apiConfig, email string) database.User {
+	t.Helper()
+	hash, err := auth.HashPassword("password")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: hash})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return *user
+}
+
+func testAccessToken(t *testing.T, cfg *apiConfig, userID uuid.UUID) string {
+	t.Helper()
+	token, err := auth.MakeJWT(userID, "", cfg.jwtKeys, time.Hour)
+	if err != nil {
+		t.Fatalf("MakeJWT: %v", err)
+	}
+	return token
+}
+
+// serve sends req to handler registered under pattern and records the
+// response.
+func serve(pattern string, handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle(pattern, handler)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// withBearer adds an access token to req, unless token is empty.
+func withBearer(req *http.Request, token string) *http.Request {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
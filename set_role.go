@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const setRoleUsage = "usage: tubely set-role <email> viewer|creator|admin"
+
+// runSetRole implements the `set-role` subcommand, which is how the first
+// admin is made. After that, admins manage roles through the admin API.
+func runSetRole(dbURL string, args []string) error {
+	if len(args) != 2 {
+		return errors.New(setRoleUsage)
+	}
+	email, role := args[0], database.Role(args[1])
+	if !role.Valid() {
+		return errors.New(setRoleUsage)
+	}
+
+	db, err := database.NewClient(dbURL)
+	if err != nil {
+		return fmt.Errorf("couldn't connect to database: %w", err)
+	}
+	defer db.Close()
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user.Email == "" {
+		return fmt.Errorf("no user with email %s", email)
+	}
+	if err := db.SetUserRole(user.ID, role); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", email, role)
+	return nil
+}
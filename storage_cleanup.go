@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// Storage backends as recorded on queued deletions.
+const (
+	storageBackendVideos     = "videos"
+	storageBackendThumbnails = "thumbnails"
+)
+
+const (
+	storageCleanupInterval = time.Minute
+	storageCleanupBatch    = 50
+	// storageCleanupLease is how long a claimed deletion is hidden from
+	// other instances before it's considered abandoned.
+	storageCleanupLease = 5 * time.Minute
+	maxStorageRetryWait = 6 * time.Hour
+)
+
+// storageCleaner works through the queue of files left behind by deleted
+// videos, retrying failures with exponential backoff.
+type storageCleaner struct {
+	db     database.StorageDeletionStore
+	stores map[string]storage.Store
+	wake   chan struct{}
+}
+
+func newStorageCleaner(db database.StorageDeletionStore, stores map[string]storage.Store) *storageCleaner {
+	return &storageCleaner{
+		db:     db,
+		stores: stores,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// notify asks the cleaner to look at the queue now rather than at its next
+// tick.
+func (c *storageCleaner) notify() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *storageCleaner) run(ctx context.Context) {
+	ticker := time.NewTicker(storageCleanupInterval)
+	defer ticker.Stop()
+	for {
+		c.processDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.wake:
+		}
+	}
+}
+
+func (c *storageCleaner) processDue(ctx context.Context) {
+	for {
+		deletions, err := c.db.ClaimStorageDeletions(storageCleanupBatch, storageCleanupLease)
+		if err != nil {
+			log.Printf("Couldn't claim storage deletions: %v", err)
+			return
+		}
+		for _, d := range deletions {
+			c.process(ctx, d)
+		}
+		if len(deletions) < storageCleanupBatch {
+			return
+		}
+	}
+}
+
+func (c *storageCleaner) process(ctx context.Context, d database.StorageDeletion) {
+	store, ok := c.stores[d.Backend]
+	if !ok {
+		c.fail(d, "unknown storage backend "+d.Backend)
+		return
+	}
+	if err := store.Delete(ctx, d.Key); err != nil {
+		c.fail(d, err.Error())
+		return
+	}
+
+	log.Printf("Removed %s/%s of deleted video %s", d.Backend, d.Key, d.VideoID)
+	if err := c.db.CompleteStorageDeletion(d.ID); err != nil {
+		log.Printf("Couldn't mark deletion of %s/%s complete: %v", d.Backend, d.Key, err)
+	}
+}
+
+func (c *storageCleaner) fail(d database.StorageDeletion, reason string) {
+	wait := min(30*time.Second<<min(d.Attempts, 20), maxStorageRetryWait)
+	log.Printf("Couldn't remove %s/%s of deleted video %s (attempt %d), retrying in %s: %s",
+		d.Backend, d.Key, d.VideoID, d.Attempts+1, wait, reason)
+	if err := c.db.FailStorageDeletion(d.ID, reason, time.Now().Add(wait)); err != nil {
+		log.Printf("Couldn't record failed deletion of %s/%s: %v", d.Backend, d.Key, err)
+	}
+}
+
+// videoObjects lists every stored file belonging to video. New kinds of
+// artifacts need to be added here so deleting a video cleans them up.
+func (cfg *apiConfig) videoObjects(video database.Video) []database.StorageObject {
+	objects := []database.StorageObject{}
+	if video.VideoURL != nil {
+		if key, ok := cfg.videoStorage.KeyForURL(*video.VideoURL); ok {
+			objects = append(objects, database.StorageObject{Backend: storageBackendVideos, Key: key})
+		}
+	}
+	if video.ThumbnailURL != nil {
+		if key, ok := cfg.thumbnailStorage.KeyForURL(*video.ThumbnailURL); ok {
+			objects = append(objects, database.StorageObject{Backend: storageBackendThumbnails, Key: key})
+		}
+	}
+	return objects
+}
+
+// deleteVideo removes a video for good and queues its files for cleanup.
+func (cfg *apiConfig) deleteVideo(video database.Video) error {
+	objects := cfg.videoObjects(video)
+	err := cfg.db.DeleteVideoWithObjects(video.ID, objects)
+	if err != nil {
+		return err
+	}
+	log.Printf("Deleted video %s, queued %d stored file(s) for removal", video.ID, len(objects))
+	cfg.cleaner.notify()
+	return nil
+}
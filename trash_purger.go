@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	defaultTrashRetentionDays = 30
+	trashPurgeInterval        = time.Hour
+	trashPurgeBatch           = 100
+)
+
+// runTrashPurger permanently deletes videos that have been in the trash for
+// longer than the retention period.
+func (cfg *apiConfig) runTrashPurger(ctx context.Context) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		cfg.purgeTrash()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) purgeTrash() {
+	cutoff := time.Now().Add(-cfg.trashRetention)
+	for {
+		videos, err := cfg.db.ListVideosTrashedBefore(cutoff, trashPurgeBatch)
+		if err != nil {
+			log.Printf("Couldn't list expired trash: %v", err)
+			return
+		}
+		for _, video := range videos {
+			if err := cfg.deleteVideo(video); err != nil {
+				log.Printf("Couldn't purge video %s from trash: %v", video.ID, err)
+				return
+			}
+		}
+		if len(videos) < trashPurgeBatch {
+			return
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// The helpers here are the one place that decides who may see and change a
+// video. Videos a user can't see look exactly like missing ones (404);
+// videos they can see but don't own are forbidden (403). They respond with
+// the error themselves and return false on failure.
+
+// getWatchableVideo loads the video named in the path if the requester may
+// watch it.
+func (cfg *apiConfig) getWatchableVideo(w http.ResponseWriter, r *http.Request, viewerID uuid.UUID) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return database.Video{}, false
+	}
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil || !video.VisibleTo(viewerID) {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+// getOwnedVideo loads the video named in the path for a request that has
+// to come from its owner.
+func (cfg *apiConfig) getOwnedVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	userID := requestUserID(r)
+	video, ok := cfg.getWatchableVideo(w, r, userID)
+	if !ok {
+		return database.Video{}, false
+	}
+	return video, authorizeVideoOwner(w, video, userID)
+}
+
+// authorizeVideoOwner checks that userID owns a video they've already been
+// allowed to see.
+func authorizeVideoOwner(w http.ResponseWriter, video database.Video, userID uuid.UUID) bool {
+	if userID == uuid.Nil || video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You don't own this video", nil)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	viewRollupInterval = 5 * time.Minute
+	// viewRollupLookback covers yesterday as well as today, so views that
+	// kept sending heartbeats past midnight are rolled up in full.
+	viewRollupLookback = 48 * time.Hour
+)
+
+// runViewRollup keeps the daily view stats of recent days up to date.
+func (cfg *apiConfig) runViewRollup(ctx context.Context) {
+	ticker := time.NewTicker(viewRollupInterval)
+	defer ticker.Stop()
+	for {
+		if err := cfg.db.RollupViewStats(time.Now().Add(-viewRollupLookback)); err != nil {
+			log.Printf("Couldn't roll up view stats: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}